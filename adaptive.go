@@ -0,0 +1,218 @@
+package pail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+const (
+	defaultAdaptiveMultiplier = 3
+	defaultAdaptivePercentile = 0.99
+	defaultAdaptiveMinSamples = 20
+	defaultAdaptiveWindow     = 256
+)
+
+// AdaptiveTimeouts configures the derivation of per-attempt timeouts from observed latencies.  Each attempt's
+// Timeout is set to Multiplier times the recent Percentile latency for the operation's kind, bounded by Min and Max
+// and by whatever remains of the caller's own Timeout or Context deadline.  Zero values take the defaults noted.
+type AdaptiveTimeouts struct {
+	// Multiplier is applied to the observed latency.  Defaults to 3.
+	Multiplier float64
+	// Percentile is the latency percentile, between 0 and 1, to derive timeouts from.  Defaults to 0.99.
+	Percentile float64
+	// Min and Max bound the derived timeout.  A Max of zero leaves it unbounded.
+	Min time.Duration
+	Max time.Duration
+	// MinSamples is the number of observations required before a timeout is derived.  Until then, the caller's
+	// timeout or gocb's default applies.  Defaults to 20.
+	MinSamples int
+	// Window is the number of recent observations retained per operation kind.  Defaults to 256.
+	Window int
+}
+
+func (at AdaptiveTimeouts) withDefaults() AdaptiveTimeouts {
+	if at.Multiplier <= 0 {
+		at.Multiplier = defaultAdaptiveMultiplier
+	}
+	if at.Percentile <= 0 || at.Percentile > 1 {
+		at.Percentile = defaultAdaptivePercentile
+	}
+	if at.MinSamples <= 0 {
+		at.MinSamples = defaultAdaptiveMinSamples
+	}
+	if at.Window <= 0 {
+		at.Window = defaultAdaptiveWindow
+	}
+	if at.MinSamples > at.Window {
+		at.MinSamples = at.Window
+	}
+	return at
+}
+
+// latencyWindow is a ring of the most recent observations for a single operation kind
+type latencyWindow struct {
+	samples []time.Duration
+	next    int
+}
+
+type adaptiveTimeouts struct {
+	cfg AdaptiveTimeouts
+
+	mu       sync.Mutex
+	windows  map[OperationKind]*latencyWindow
+	children map[string]*adaptiveTimeouts
+}
+
+func newAdaptiveTimeouts(cfg AdaptiveTimeouts) *adaptiveTimeouts {
+	return &adaptiveTimeouts{
+		cfg:     cfg.withDefaults(),
+		windows: make(map[OperationKind]*latencyWindow),
+	}
+}
+
+// child returns the tracker, sharing this tracker's configuration, used for the named collection.  Repeated calls with
+// the same name return the same tracker, so that a collection re-derived per request retains its observations.
+func (a *adaptiveTimeouts) child(name string) *adaptiveTimeouts {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.children == nil {
+		a.children = make(map[string]*adaptiveTimeouts)
+	}
+	c, ok := a.children[name]
+	if !ok {
+		c = newAdaptiveTimeouts(a.cfg)
+		a.children[name] = c
+	}
+	return c
+}
+
+func (a *adaptiveTimeouts) observe(kind OperationKind, d time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	w, ok := a.windows[kind]
+	if !ok {
+		w = &latencyWindow{samples: make([]time.Duration, 0, a.cfg.Window)}
+		a.windows[kind] = w
+	}
+	if len(w.samples) < a.cfg.Window {
+		w.samples = append(w.samples, d)
+		return
+	}
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % a.cfg.Window
+}
+
+func (a *adaptiveTimeouts) percentile(kind OperationKind, q float64) (time.Duration, int) {
+	a.mu.Lock()
+	w, ok := a.windows[kind]
+	if !ok || len(w.samples) == 0 {
+		a.mu.Unlock()
+		return 0, 0
+	}
+	sorted := make([]time.Duration, len(w.samples))
+	copy(sorted, w.samples)
+	a.mu.Unlock()
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return sorted[idx], len(sorted)
+}
+
+// timeout returns the timeout to use for the next attempt of an operation of the given kind, or zero if too few
+// observations have been made to derive one.
+func (a *adaptiveTimeouts) timeout(kind OperationKind) time.Duration {
+	p, n := a.percentile(kind, a.cfg.Percentile)
+	if n < a.cfg.MinSamples {
+		return 0
+	}
+	d := time.Duration(float64(p) * a.cfg.Multiplier)
+	if d < a.cfg.Min {
+		d = a.cfg.Min
+	}
+	if a.cfg.Max > 0 && d > a.cfg.Max {
+		d = a.cfg.Max
+	}
+	return d
+}
+
+// adaptTimeout wraps fn such that before each attempt *timeout is set from recent latencies of the given kind, and the
+// duration of the attempt is recorded, as measured by clock.  Attempts which time out are not recorded, their duration
+// being that of the timeout rather than of the operation.  The caller's original timeout and the deadline of ctx, if
+// any, are treated as the deadline across all attempts.  Once it has passed, no further attempts are made.  fn is
+// returned unchanged if adaptive timeouts are not enabled.
+func adaptTimeout[T any](a *adaptiveTimeouts, clock Clock, kind OperationKind, timeout *time.Duration, ctx context.Context, fn func(T) error) func(T) error {
+	if a == nil {
+		return fn
+	}
+	clock = clockOrSystem(clock)
+	var deadline time.Time
+	if *timeout > 0 {
+		deadline = clock.Now().Add(*timeout)
+	}
+	if ctx != nil {
+		if d, ok := ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+			deadline = d
+		}
+	}
+	return func(target T) error {
+		attempt := a.timeout(kind)
+		if !deadline.IsZero() {
+			remaining := deadline.Sub(clock.Now())
+			if remaining <= 0 {
+				return fmt.Errorf("%s deadline exceeded: %w", kind, context.DeadlineExceeded)
+			}
+			if attempt == 0 || remaining < attempt {
+				attempt = remaining
+			}
+		}
+		*timeout = attempt
+		start := clock.Now()
+		err := fn(target)
+		if !timedOut(err) {
+			a.observe(kind, clock.Now().Sub(start))
+		}
+		return err
+	}
+}
+
+func timedOut(err error) bool {
+	return errors.Is(err, gocb.ErrTimeout) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// LatencyPercentile returns the q percentile, between 0 and 1, of recently observed latencies for operations of the
+// given kind.  False is returned if adaptive timeouts are not enabled or no operations have been observed.
+func (c *commonRetryable) LatencyPercentile(kind OperationKind, q float64) (time.Duration, bool) {
+	if c.adaptive == nil {
+		return 0, false
+	}
+	d, n := c.adaptive.percentile(kind, q)
+	return d, n > 0
+}
+
+// WithAdaptiveTimeouts returns a copy of the cluster which derives per-attempt timeouts from observed latencies.
+// Collections subsequently derived from it track their own latencies using the same configuration.
+func (c *Cluster) WithAdaptiveTimeouts(cfg AdaptiveTimeouts) *Cluster {
+	out := *c
+	out.adaptive = newAdaptiveTimeouts(cfg)
+	return &out
+}
+
+// WithAdaptiveTimeouts returns a copy of the collection which derives per-attempt timeouts from observed latencies.
+func (c *Collection) WithAdaptiveTimeouts(cfg AdaptiveTimeouts) *Collection {
+	out := *c
+	out.adaptive = newAdaptiveTimeouts(cfg)
+	return &out
+}
@@ -0,0 +1,125 @@
+package pail
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// steppedClock only moves when advanced by the test
+type steppedClock struct {
+	systemClock
+	now time.Time
+}
+
+func (c *steppedClock) Now() time.Time {
+	return c.now
+}
+
+func TestAdaptivePercentileWindow(t *testing.T) {
+	a := newAdaptiveTimeouts(AdaptiveTimeouts{Window: 4, MinSamples: 1})
+	for i := 1; i <= 6; i++ {
+		a.observe(OperationGet, time.Duration(i)*time.Millisecond)
+	}
+	a.observe(OperationQuery, time.Second)
+
+	tests := []struct {
+		q    float64
+		want time.Duration
+	}{
+		{q: 0, want: 3 * time.Millisecond},
+		{q: 0.25, want: 3 * time.Millisecond},
+		{q: 0.5, want: 4 * time.Millisecond},
+		{q: 0.99, want: 6 * time.Millisecond},
+		{q: 1, want: 6 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got, n := a.percentile(OperationGet, tt.q); got != tt.want || n != 4 {
+			t.Fatalf("expected the %v percentile of the last 4 samples to be %s, got %s of %d", tt.q, tt.want, got, n)
+		}
+	}
+	if _, n := a.percentile(OperationLookupIn, 0.5); n != 0 {
+		t.Fatalf("expected no samples for an unobserved kind, got %d", n)
+	}
+}
+
+func TestAdaptiveTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     AdaptiveTimeouts
+		samples int
+		want    time.Duration
+	}{
+		{name: "too few samples", cfg: AdaptiveTimeouts{MinSamples: 5}, samples: 4},
+		{name: "multiplier", cfg: AdaptiveTimeouts{MinSamples: 5}, samples: 5, want: 30 * time.Millisecond},
+		{name: "min", cfg: AdaptiveTimeouts{MinSamples: 5, Min: 50 * time.Millisecond}, samples: 5, want: 50 * time.Millisecond},
+		{name: "max", cfg: AdaptiveTimeouts{MinSamples: 5, Max: 20 * time.Millisecond}, samples: 5, want: 20 * time.Millisecond},
+		{name: "min samples beyond window", cfg: AdaptiveTimeouts{MinSamples: 50, Window: 5, Multiplier: 2}, samples: 5, want: 20 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAdaptiveTimeouts(tt.cfg)
+			for i := 0; i < tt.samples; i++ {
+				a.observe(OperationGet, 10*time.Millisecond)
+			}
+			if got := a.timeout(OperationGet); got != tt.want {
+				t.Fatalf("expected a timeout of %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAdaptTimeout(t *testing.T) {
+	var (
+		clock   = &steppedClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+		a       = newAdaptiveTimeouts(AdaptiveTimeouts{MinSamples: 1, Multiplier: 2})
+		timeout = 25 * time.Millisecond
+		results []error
+		took    []time.Duration
+		given   []time.Duration
+	)
+	fn := adaptTimeout(a, clock, OperationGet, &timeout, context.Background(), func(struct{}) error {
+		given = append(given, timeout)
+		clock.now = clock.now.Add(took[len(given)-1])
+		return results[len(given)-1]
+	})
+
+	took = []time.Duration{5 * time.Millisecond, 10 * time.Millisecond, 4 * time.Millisecond}
+	results = []error{nil, gocb.ErrTimeout, nil}
+	for range took {
+		_ = fn(struct{}{})
+	}
+	// the caller's timeout bounds the first attempt, as nothing has been observed; later attempts take twice the
+	// observed latency, bounded by what remains of the caller's timeout
+	if want := []time.Duration{25 * time.Millisecond, 10 * time.Millisecond, 10 * time.Millisecond}; !reflect.DeepEqual(given, want) {
+		t.Fatalf("expected attempt timeouts %v, got %v", want, given)
+	}
+	// the timed out attempt is not observed
+	if p, n := a.percentile(OperationGet, 1); p != 5*time.Millisecond || n != 2 {
+		t.Fatalf("expected 2 samples of at most 5ms, got %d of at most %s", n, p)
+	}
+
+	took, results = append(took, 0), append(results, nil)
+	clock.now = clock.now.Add(6 * time.Millisecond)
+	if err := fn(struct{}{}); !errors.Is(err, context.DeadlineExceeded) || len(given) != 3 {
+		t.Fatalf("expected no attempt once the caller's timeout has passed, got %v after %d attempts", err, len(given))
+	}
+}
+
+func TestAdaptTimeoutContextDeadline(t *testing.T) {
+	clock := &steppedClock{now: time.Now()}
+	ctx, cancel := context.WithDeadline(context.Background(), clock.now.Add(3*time.Millisecond))
+	defer cancel()
+	a := newAdaptiveTimeouts(AdaptiveTimeouts{MinSamples: 1})
+	a.observe(OperationGet, 10*time.Millisecond)
+
+	timeout := time.Second
+	fn := adaptTimeout(a, clock, OperationGet, &timeout, ctx, func(struct{}) error { return nil })
+	if err := fn(struct{}{}); err != nil || timeout != 3*time.Millisecond {
+		t.Fatalf("expected the context deadline to bound the attempt, got a timeout of %s and %v", timeout, err)
+	}
+}
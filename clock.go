@@ -0,0 +1,63 @@
+package pail
+
+import (
+	"context"
+	"time"
+)
+
+// Clock is the source of time used when waiting between attempts.  Substituting a fake clock, such as pailtest's,
+// allows retry timing to be tested without actually waiting.
+type Clock interface {
+	Now() time.Time
+	// Sleep waits for d to elapse, or for ctx to end in which case ctx's error is returned.
+	Sleep(ctx context.Context, d time.Duration) error
+	// After returns a channel receiving the time once d has elapsed.
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+// SystemClock is the Clock used unless another is set, backed by the time package.
+var SystemClock Clock = systemClock{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func clockOrSystem(c Clock) Clock {
+	if c == nil {
+		return SystemClock
+	}
+	return c
+}
+
+// Clock returns the clock used when waiting between attempts.
+func (c *commonRetryable) Clock() Clock {
+	return clockOrSystem(c.clock)
+}
+
+// WithClock returns a copy of the cluster which, along with everything subsequently derived from it, uses clock when
+// waiting between attempts.
+func (c *Cluster) WithClock(clock Clock) *Cluster {
+	out := *c
+	out.clock = clock
+	return &out
+}
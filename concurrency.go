@@ -0,0 +1,197 @@
+package pail
+
+import (
+	"container/list"
+	"context"
+	"math"
+	"sync"
+)
+
+const (
+	defaultConcurrencyInitial  = 64
+	defaultConcurrencyMax      = 1024
+	defaultConcurrencyDecrease = 0.5
+)
+
+// ConcurrencyLimit configures a ConcurrencyLimiter.  Zero values take the defaults noted.
+type ConcurrencyLimit struct {
+	// Initial is the starting limit.  Defaults to 64.
+	Initial int
+	// Min and Max bound the limit.  Min defaults to 1, Max to 1024.
+	Min int
+	Max int
+	// Decrease is the factor the limit is multiplied by on overload.  Defaults to 0.5.
+	Decrease float64
+	// Overloaded returns true if err signals that the cluster is overloaded.  Defaults to DefaultErrorClassifier,
+	// i.e. overload and timeout errors.
+	Overloaded ErrorClassifier
+}
+
+func (cl ConcurrencyLimit) withDefaults() ConcurrencyLimit {
+	if cl.Min <= 0 {
+		cl.Min = 1
+	}
+	if cl.Max <= 0 {
+		cl.Max = defaultConcurrencyMax
+	}
+	if cl.Max < cl.Min {
+		cl.Max = cl.Min
+	}
+	if cl.Initial <= 0 {
+		cl.Initial = defaultConcurrencyInitial
+	}
+	if cl.Initial < cl.Min {
+		cl.Initial = cl.Min
+	} else if cl.Initial > cl.Max {
+		cl.Initial = cl.Max
+	}
+	if cl.Decrease <= 0 || cl.Decrease >= 1 {
+		cl.Decrease = defaultConcurrencyDecrease
+	}
+	if cl.Overloaded == nil {
+		cl.Overloaded = DefaultErrorClassifier
+	}
+	return cl
+}
+
+type ConcurrencyLimiterStats struct {
+	Limit    int
+	InFlight int
+	Queued   int
+	// Decreases is the number of times the limit has been reduced in response to overload.
+	Decreases uint64
+}
+
+// ConcurrencyLimiter caps the number of operations in flight using additive increase, multiplicative decrease.  Each
+// success grows the limit by roughly one per limit's worth of successes, and overload shrinks it by the configured
+// factor.  Only the first overload seen from operations admitted under a given limit shrinks it, so a burst of
+// failures from the same window is counted once.  Callers beyond the limit queue in order of arrival.
+type ConcurrencyLimiter struct {
+	cfg ConcurrencyLimit
+
+	mu        sync.Mutex
+	limit     float64
+	inFlight  int
+	epoch     uint64
+	decreases uint64
+	waiters   list.List
+}
+
+func NewConcurrencyLimiter(cfg ConcurrencyLimit) *ConcurrencyLimiter {
+	cfg = cfg.withDefaults()
+	return &ConcurrencyLimiter{cfg: cfg, limit: float64(cfg.Initial)}
+}
+
+type concurrencyWaiter struct {
+	ready chan uint64
+}
+
+// Acquire blocks until an operation may proceed, or ctx ends.  On success the returned func must be called exactly
+// once with the outcome of the operation.
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context) (func(err error), error) {
+	l.mu.Lock()
+	if l.waiters.Len() == 0 && l.inFlight < l.capacity() {
+		l.inFlight++
+		epoch := l.epoch
+		l.mu.Unlock()
+		return l.releaser(epoch), nil
+	}
+	w := &concurrencyWaiter{ready: make(chan uint64, 1)}
+	elem := l.waiters.PushBack(w)
+	l.mu.Unlock()
+
+	select {
+	case epoch := <-w.ready:
+		return l.releaser(epoch), nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		select {
+		case epoch := <-w.ready:
+			// admitted in the meantime, so hand the slot on
+			l.mu.Unlock()
+			l.release(epoch, ctx.Err())
+		default:
+			l.waiters.Remove(elem)
+			l.mu.Unlock()
+		}
+		return nil, ctx.Err()
+	}
+}
+
+func (l *ConcurrencyLimiter) releaser(epoch uint64) func(error) {
+	var once sync.Once
+	return func(err error) {
+		once.Do(func() { l.release(epoch, err) })
+	}
+}
+
+func (l *ConcurrencyLimiter) release(epoch uint64, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	switch {
+	case err == nil:
+		l.limit = math.Min(l.limit+1/l.limit, float64(l.cfg.Max))
+	case l.cfg.Overloaded(err) && epoch == l.epoch:
+		l.limit = math.Max(l.limit*l.cfg.Decrease, float64(l.cfg.Min))
+		l.epoch++
+		l.decreases++
+	}
+	l.admit()
+}
+
+// admit hands free slots to queued callers.  l.mu must be held.
+func (l *ConcurrencyLimiter) admit() {
+	for l.waiters.Len() > 0 && l.inFlight < l.capacity() {
+		w := l.waiters.Remove(l.waiters.Front()).(*concurrencyWaiter)
+		l.inFlight++
+		w.ready <- l.epoch
+	}
+}
+
+func (l *ConcurrencyLimiter) capacity() int {
+	return int(l.limit)
+}
+
+func (l *ConcurrencyLimiter) Stats() ConcurrencyLimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return ConcurrencyLimiterStats{
+		Limit:     l.capacity(),
+		InFlight:  l.inFlight,
+		Queued:    l.waiters.Len(),
+		Decreases: l.decreases,
+	}
+}
+
+// limitConcurrency wraps fn such that each attempt is admitted by l, reporting its outcome once complete.  fn is
+// returned unchanged if l is nil.
+func limitConcurrency[T any](l *ConcurrencyLimiter, ctx context.Context, fn func(T) error) func(T) error {
+	if l == nil {
+		return fn
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return func(target T) (err error) {
+		release, err := l.Acquire(ctx)
+		if err != nil {
+			return err
+		}
+		defer func() { release(err) }()
+		return fn(target)
+	}
+}
+
+// ConcurrencyLimiter returns the limiter applied to operations, if any.
+func (c *commonRetryable) ConcurrencyLimiter() *ConcurrencyLimiter {
+	return c.concurrency
+}
+
+// WithConcurrencyLimiter returns a copy of the cluster whose operations, and those of everything subsequently derived
+// from it, must be admitted by l before each attempt.  A nil l removes any limiter.
+func (c *Cluster) WithConcurrencyLimiter(l *ConcurrencyLimiter) *Cluster {
+	out := *c
+	out.concurrency = l
+	return &out
+}
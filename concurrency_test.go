@@ -0,0 +1,129 @@
+package pail_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/myENA/pail/v2"
+	"github.com/myENA/pail/v2/pailtest"
+)
+
+var errOverloaded = errors.New("overloaded")
+
+func overloaded(err error) bool { return errors.Is(err, errOverloaded) }
+
+// acquire admits a single operation, failing the test should it not be admitted at once
+func acquire(t *testing.T, l *pail.ConcurrencyLimiter) func(error) {
+	t.Helper()
+	release, err := l.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected acquire error: %v", err)
+	}
+	return release
+}
+
+func TestConcurrencyLimiterGrowth(t *testing.T) {
+	l := pail.NewConcurrencyLimiter(pail.ConcurrencyLimit{Initial: 1, Max: 3})
+	var limits []int
+	for i := 0; i < 6; i++ {
+		acquire(t, l)(nil)
+		limits = append(limits, l.Stats().Limit)
+	}
+	// each success adds 1/limit, so the limit grows by one per limit's worth of successes until it reaches Max
+	if want := []int{2, 2, 2, 3, 3, 3}; !reflect.DeepEqual(limits, want) {
+		t.Fatalf("expected limits %v, got %v", want, limits)
+	}
+}
+
+func TestConcurrencyLimiterShrink(t *testing.T) {
+	l := pail.NewConcurrencyLimiter(pail.ConcurrencyLimit{Initial: 8, Min: 3, Overloaded: overloaded})
+	first, second := acquire(t, l), acquire(t, l)
+	first(errOverloaded)
+	second(errOverloaded)
+	if stats := l.Stats(); stats.Limit != 4 || stats.Decreases != 1 {
+		t.Fatalf("expected overloads from the same window to halve the limit once, got %+v", stats)
+	}
+
+	acquire(t, l)(errors.New("not overloaded"))
+	if stats := l.Stats(); stats.Limit != 4 {
+		t.Fatalf("expected other errors to leave the limit alone, got %+v", stats)
+	}
+	acquire(t, l)(errOverloaded)
+	if stats := l.Stats(); stats.Limit != 3 || stats.Decreases != 2 {
+		t.Fatalf("expected an overload in a later window to shrink the limit to Min, got %+v", stats)
+	}
+}
+
+func TestConcurrencyLimiterQueue(t *testing.T) {
+	var (
+		clock = pailtest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+		l     = pail.NewConcurrencyLimiter(pail.ConcurrencyLimit{Initial: 1, Max: 1})
+		mu    sync.Mutex
+		order []int
+		wg    sync.WaitGroup
+	)
+	// each operation holds its slot until the clock passes its own sleep
+	run := func(i int) {
+		defer wg.Done()
+		release, err := l.Acquire(context.Background())
+		if err != nil {
+			t.Errorf("unexpected acquire error: %v", err)
+			return
+		}
+		mu.Lock()
+		order = append(order, i)
+		mu.Unlock()
+		err = clock.Sleep(context.Background(), time.Second)
+		release(err)
+	}
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go run(i)
+		// wait for each caller to hold the slot or queue before the next arrives
+		for stats := l.Stats(); stats.InFlight+stats.Queued < i+1; stats = l.Stats() {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		clock.BlockUntil(1)
+		if stats := l.Stats(); stats.InFlight != 1 || stats.Queued != 2-i {
+			t.Fatalf("expected 1 operation in flight and %d queued, got %+v", 2-i, stats)
+		}
+		clock.Advance(time.Second)
+	}
+	wg.Wait()
+	if want := []int{0, 1, 2}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("expected callers to be admitted in order of arrival, got %v", order)
+	}
+}
+
+func TestConcurrencyLimiterAcquireCancel(t *testing.T) {
+	l := pail.NewConcurrencyLimiter(pail.ConcurrencyLimit{Initial: 1, Max: 1})
+	release := acquire(t, l)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := l.Acquire(ctx)
+		done <- err
+	}()
+	for l.Stats().Queued == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the acquire to end with its context, got %v", err)
+	}
+	if stats := l.Stats(); stats.Queued != 0 || stats.InFlight != 1 {
+		t.Fatalf("expected the cancelled caller to leave the queue, got %+v", stats)
+	}
+	release(nil)
+	release(nil)
+	if stats := l.Stats(); stats.InFlight != 0 {
+		t.Fatalf("expected the slot to be released exactly once, got %+v", stats)
+	}
+}
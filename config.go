@@ -0,0 +1,430 @@
+package pail
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultConfigRetries    = 3
+	defaultConfigRetryDelay = 50 * time.Millisecond
+
+	BackoffTypeConstant    = "constant"
+	BackoffTypeExponential = "exponential"
+)
+
+type connectConfig struct {
+	policy      RetryPolicy
+	policies    map[OperationKind]RetryPolicy
+	clusterOpts []func(*gocb.ClusterOptions)
+	waitTimeout time.Duration
+	waitOpts    *gocb.WaitUntilReadyOptions
+	adaptive    *AdaptiveTimeouts
+	limiter     *RateLimiter
+	concurrency *ConcurrencyLimiter
+	clock       Clock
+	reasons     ReasonPolicies
+}
+
+func newConnectConfig(policy RetryPolicy) *connectConfig {
+	return &connectConfig{policy: policy}
+}
+
+func (cc *connectConfig) connect(connStr string, opts gocb.ClusterOptions) (*Cluster, error) {
+	for _, fn := range cc.clusterOpts {
+		fn(&opts)
+	}
+	cluster, err := gocb.Connect(connStr, opts)
+	if err != nil {
+		return nil, err
+	}
+	c := NewCluster(cluster, cc.policy.Retries, cc.policy.Delay)
+	c.setPolicy(cc.policy)
+	for kind, p := range cc.policies {
+		c.SetOperationPolicy(kind, p)
+	}
+	if cc.adaptive != nil {
+		c.adaptive = newAdaptiveTimeouts(*cc.adaptive)
+	}
+	c.limiter = cc.limiter
+	c.concurrency = cc.concurrency
+	c.clock = cc.clock
+	c.reasons = cc.reasons
+	if cc.waitOpts != nil {
+		if err = c.TryWaitUntilReady(cc.waitTimeout, cc.waitOpts); err != nil {
+			_ = cluster.Close(nil)
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+type ConnectOption func(*connectConfig)
+
+// WithWaitUntilReady causes Connect to block for up to timeout until the cluster reports the given services, or gocb's
+// default set if none are given, as ready.  The wait is not retried should it time out.  Connect only waits on the
+// cluster, as it opens no buckets; use Pail.TryWaitUntilReady to wait on a bucket once opened.
+func WithWaitUntilReady(timeout time.Duration, services ...gocb.ServiceType) ConnectOption {
+	return func(cc *connectConfig) {
+		cc.waitTimeout = timeout
+		cc.waitOpts = &gocb.WaitUntilReadyOptions{ServiceTypes: services}
+	}
+}
+
+// WithRetryPolicy replaces the retry policy in its entirety.
+func WithRetryPolicy(policy RetryPolicy) ConnectOption {
+	return func(cc *connectConfig) { cc.policy = policy }
+}
+
+func WithBackoff(backoff Backoff) ConnectOption {
+	return func(cc *connectConfig) { cc.policy.Backoff = backoff }
+}
+
+func WithClassifier(classifier ErrorClassifier) ConnectOption {
+	return func(cc *connectConfig) { cc.policy.Classifier = classifier }
+}
+
+func WithRetryHooks(hooks RetryHooks) ConnectOption {
+	return func(cc *connectConfig) { cc.policy.Hooks = hooks }
+}
+
+// WithRetryMaxElapsed bounds the time over which any one call may be retried, as described by RetryPolicy.
+func WithRetryMaxElapsed(d time.Duration) ConnectOption {
+	return func(cc *connectConfig) { cc.policy.MaxElapsed = d }
+}
+
+// WithOperationPolicy sets the retry policy used by the cluster, and everything derived from it, for operations of
+// the given kind.
+func WithOperationPolicy(kind OperationKind, policy RetryPolicy) ConnectOption {
+	return func(cc *connectConfig) {
+		if cc.policies == nil {
+			cc.policies = make(map[OperationKind]RetryPolicy)
+		}
+		cc.policies[kind] = policy
+	}
+}
+
+// WithAdaptiveTimeouts causes the cluster, and collections derived from it, to derive per-attempt timeouts from
+// observed latencies.
+func WithAdaptiveTimeouts(cfg AdaptiveTimeouts) ConnectOption {
+	return func(cc *connectConfig) { cc.adaptive = &cfg }
+}
+
+// WithRateLimiter causes the cluster, and everything derived from it, to wait on l before each attempt of an operation.
+func WithRateLimiter(l *RateLimiter) ConnectOption {
+	return func(cc *connectConfig) { cc.limiter = l }
+}
+
+// WithConcurrencyLimiter causes the cluster, and everything derived from it, to be admitted by l before each attempt
+// of an operation.
+func WithConcurrencyLimiter(l *ConcurrencyLimiter) ConnectOption {
+	return func(cc *connectConfig) { cc.concurrency = l }
+}
+
+// WithClock sets the clock used by the cluster, and everything derived from it, when waiting between attempts.
+func WithClock(clock Clock) ConnectOption {
+	return func(cc *connectConfig) { cc.clock = clock }
+}
+
+// WithReasonPolicy sets how the cluster, and everything derived from it, handles retries gocb asks for for reason.
+func WithReasonPolicy(reason gocb.RetryReason, policy ReasonPolicy) ConnectOption {
+	return func(cc *connectConfig) {
+		if cc.reasons == nil {
+			cc.reasons = make(ReasonPolicies)
+		}
+		cc.reasons[reason] = policy
+	}
+}
+
+// WithClusterOptions allows modification of the gocb options immediately prior to connecting.
+func WithClusterOptions(fn func(*gocb.ClusterOptions)) ConnectOption {
+	return func(cc *connectConfig) { cc.clusterOpts = append(cc.clusterOpts, fn) }
+}
+
+type TimeoutsConfig struct {
+	Connect    time.Duration `yaml:"connect"`
+	KV         time.Duration `yaml:"kv"`
+	KVDurable  time.Duration `yaml:"kv_durable"`
+	View       time.Duration `yaml:"view"`
+	Query      time.Duration `yaml:"query"`
+	Analytics  time.Duration `yaml:"analytics"`
+	Search     time.Duration `yaml:"search"`
+	Management time.Duration `yaml:"management"`
+}
+
+type BackoffConfig struct {
+	// Type is either "constant" or "exponential"
+	Type       string        `yaml:"type"`
+	Max        time.Duration `yaml:"max"`
+	Multiplier float64       `yaml:"multiplier"`
+}
+
+// Config describes a connection in a form that may be loaded from a YAML or JSON file, or from environment variables.
+// Zero timeouts leave gocb's defaults in place.  Authentication is by either Username and Password, or by
+// ClientCertPath and ClientKeyPath.
+type Config struct {
+	ConnectionString string         `yaml:"connection_string"`
+	Username         string         `yaml:"username"`
+	Password         string         `yaml:"password"`
+	ClientCertPath   string         `yaml:"client_cert_path"`
+	ClientKeyPath    string         `yaml:"client_key_path"`
+	TLSRootCAPath    string         `yaml:"tls_root_ca_path"`
+	TLSSkipVerify    bool           `yaml:"tls_skip_verify"`
+	Timeouts         TimeoutsConfig `yaml:"timeouts"`
+	Retries          int            `yaml:"retries"`
+	RetryDelay       time.Duration  `yaml:"retry_delay"`
+	RetryMaxElapsed  time.Duration  `yaml:"retry_max_elapsed"`
+	Backoff          BackoffConfig  `yaml:"backoff"`
+	WaitUntilReady   time.Duration  `yaml:"wait_until_ready"`
+	WaitServices     []string       `yaml:"wait_services"`
+}
+
+// DefaultConfig returns a Config with 3 retries 50ms apart.
+func DefaultConfig() Config {
+	return Config{
+		Retries:    defaultConfigRetries,
+		RetryDelay: defaultConfigRetryDelay,
+		Backoff:    BackoffConfig{Type: BackoffTypeConstant},
+	}
+}
+
+// LoadConfigFile reads a YAML or JSON config file, with any fields it omits taking their values from DefaultConfig.
+func LoadConfigFile(path string) (Config, error) {
+	cfg := DefaultConfig()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err = dec.Decode(&cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// ConfigFromEnv returns DefaultConfig overridden by any environment variables set with the given prefix.
+func ConfigFromEnv(prefix string) (Config, error) {
+	cfg := DefaultConfig()
+	return cfg, cfg.LoadEnv(prefix)
+}
+
+// LoadEnv overrides fields of the config from environment variables named for the config's YAML keys, upper cased
+// and prefixed, e.g. with a prefix of "COUCHBASE_", COUCHBASE_CONNECTION_STRING and COUCHBASE_TIMEOUTS_KV.  List
+// values are comma separated.
+func (cfg *Config) LoadEnv(prefix string) error {
+	var errs []error
+	str := func(name string, dst *string) {
+		if v, ok := os.LookupEnv(prefix + name); ok {
+			*dst = v
+		}
+	}
+	dur := func(name string, dst *time.Duration) {
+		if v, ok := os.LookupEnv(prefix + name); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s%s: %w", prefix, name, err))
+				return
+			}
+			*dst = d
+		}
+	}
+	str("CONNECTION_STRING", &cfg.ConnectionString)
+	str("USERNAME", &cfg.Username)
+	str("PASSWORD", &cfg.Password)
+	str("CLIENT_CERT_PATH", &cfg.ClientCertPath)
+	str("CLIENT_KEY_PATH", &cfg.ClientKeyPath)
+	str("TLS_ROOT_CA_PATH", &cfg.TLSRootCAPath)
+	if v, ok := os.LookupEnv(prefix + "TLS_SKIP_VERIFY"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%sTLS_SKIP_VERIFY: %w", prefix, err))
+		} else {
+			cfg.TLSSkipVerify = b
+		}
+	}
+	dur("TIMEOUTS_CONNECT", &cfg.Timeouts.Connect)
+	dur("TIMEOUTS_KV", &cfg.Timeouts.KV)
+	dur("TIMEOUTS_KV_DURABLE", &cfg.Timeouts.KVDurable)
+	dur("TIMEOUTS_VIEW", &cfg.Timeouts.View)
+	dur("TIMEOUTS_QUERY", &cfg.Timeouts.Query)
+	dur("TIMEOUTS_ANALYTICS", &cfg.Timeouts.Analytics)
+	dur("TIMEOUTS_SEARCH", &cfg.Timeouts.Search)
+	dur("TIMEOUTS_MANAGEMENT", &cfg.Timeouts.Management)
+	if v, ok := os.LookupEnv(prefix + "RETRIES"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%sRETRIES: %w", prefix, err))
+		} else {
+			cfg.Retries = n
+		}
+	}
+	dur("RETRY_DELAY", &cfg.RetryDelay)
+	dur("RETRY_MAX_ELAPSED", &cfg.RetryMaxElapsed)
+	str("BACKOFF_TYPE", &cfg.Backoff.Type)
+	dur("BACKOFF_MAX", &cfg.Backoff.Max)
+	if v, ok := os.LookupEnv(prefix + "BACKOFF_MULTIPLIER"); ok {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%sBACKOFF_MULTIPLIER: %w", prefix, err))
+		} else {
+			cfg.Backoff.Multiplier = f
+		}
+	}
+	dur("WAIT_UNTIL_READY", &cfg.WaitUntilReady)
+	if v, ok := os.LookupEnv(prefix + "WAIT_SERVICES"); ok {
+		cfg.WaitServices = nil
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				cfg.WaitServices = append(cfg.WaitServices, s)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Validate returns every problem found with the config.
+func (cfg Config) Validate() error {
+	var errs []error
+	if cfg.ConnectionString == "" {
+		errs = append(errs, errors.New("connection_string is required"))
+	}
+	certAuth := cfg.ClientCertPath != "" || cfg.ClientKeyPath != ""
+	switch {
+	case certAuth && (cfg.ClientCertPath == "" || cfg.ClientKeyPath == ""):
+		errs = append(errs, errors.New("client_cert_path and client_key_path must be provided together"))
+	case certAuth && (cfg.Username != "" || cfg.Password != ""):
+		errs = append(errs, errors.New("username and password may not be combined with certificate authentication"))
+	case !certAuth && cfg.Username == "":
+		errs = append(errs, errors.New("either username or client_cert_path and client_key_path are required"))
+	}
+	if cfg.Retries < 0 {
+		errs = append(errs, errors.New("retries may not be negative"))
+	}
+	if cfg.RetryDelay < 0 {
+		errs = append(errs, errors.New("retry_delay may not be negative"))
+	}
+	if cfg.RetryMaxElapsed < 0 {
+		errs = append(errs, errors.New("retry_max_elapsed may not be negative"))
+	}
+	switch cfg.Backoff.Type {
+	case "", BackoffTypeConstant:
+	case BackoffTypeExponential:
+		if cfg.Backoff.Multiplier != 0 && cfg.Backoff.Multiplier < 1 {
+			errs = append(errs, errors.New("backoff.multiplier must be at least 1"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("backoff.type %q is not one of %q or %q", cfg.Backoff.Type, BackoffTypeConstant, BackoffTypeExponential))
+	}
+	for _, name := range cfg.WaitServices {
+		if _, ok := serviceTypeFromName(name); !ok {
+			errs = append(errs, fmt.Errorf("wait_services: unknown service %q", name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RetryPolicy returns the retry policy described by the config.
+func (cfg Config) RetryPolicy() RetryPolicy {
+	p := RetryPolicy{Retries: cfg.Retries, Delay: cfg.RetryDelay, MaxElapsed: cfg.RetryMaxElapsed}
+	if cfg.Backoff.Type == BackoffTypeExponential {
+		multiplier := cfg.Backoff.Multiplier
+		if multiplier == 0 {
+			multiplier = 2
+		}
+		p.Backoff = ExponentialBackoff(cfg.RetryDelay, cfg.Backoff.Max, multiplier)
+	}
+	return p
+}
+
+// ClusterOptions builds gocb options from the config, loading any certificates it refers to.
+func (cfg Config) ClusterOptions() (gocb.ClusterOptions, error) {
+	opts := gocb.ClusterOptions{
+		TimeoutsConfig: gocb.TimeoutsConfig{
+			ConnectTimeout:    cfg.Timeouts.Connect,
+			KVTimeout:         cfg.Timeouts.KV,
+			KVDurableTimeout:  cfg.Timeouts.KVDurable,
+			ViewTimeout:       cfg.Timeouts.View,
+			QueryTimeout:      cfg.Timeouts.Query,
+			AnalyticsTimeout:  cfg.Timeouts.Analytics,
+			SearchTimeout:     cfg.Timeouts.Search,
+			ManagementTimeout: cfg.Timeouts.Management,
+		},
+		SecurityConfig: gocb.SecurityConfig{
+			TLSSkipVerify: cfg.TLSSkipVerify,
+		},
+	}
+	if cfg.ClientCertPath != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCertPath, cfg.ClientKeyPath)
+		if err != nil {
+			return opts, fmt.Errorf("error loading client certificate: %w", err)
+		}
+		opts.Authenticator = gocb.CertificateAuthenticator{ClientCertificate: &cert}
+	} else {
+		opts.Authenticator = gocb.PasswordAuthenticator{Username: cfg.Username, Password: cfg.Password}
+	}
+	if cfg.TLSRootCAPath != "" {
+		b, err := os.ReadFile(cfg.TLSRootCAPath)
+		if err != nil {
+			return opts, fmt.Errorf("error loading tls root ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return opts, fmt.Errorf("no certificates found in %s", cfg.TLSRootCAPath)
+		}
+		opts.SecurityConfig.TLSRootCAs = pool
+	}
+	return opts, nil
+}
+
+// ConnectWithConfig validates cfg and connects using it.  connectOpts are applied after the config, and so may
+// override anything it specifies.
+func ConnectWithConfig(cfg Config, connectOpts ...ConnectOption) (*Cluster, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	opts, err := cfg.ClusterOptions()
+	if err != nil {
+		return nil, err
+	}
+	cc := newConnectConfig(cfg.RetryPolicy())
+	if cfg.WaitUntilReady > 0 {
+		services := make([]gocb.ServiceType, 0, len(cfg.WaitServices))
+		for _, name := range cfg.WaitServices {
+			st, _ := serviceTypeFromName(name)
+			services = append(services, st)
+		}
+		WithWaitUntilReady(cfg.WaitUntilReady, services...)(cc)
+	}
+	for _, fn := range connectOpts {
+		fn(cc)
+	}
+	return cc.connect(cfg.ConnectionString, opts)
+}
+
+func serviceTypeFromName(name string) (gocb.ServiceType, bool) {
+	for _, st := range []gocb.ServiceType{
+		gocb.ServiceTypeManagement,
+		gocb.ServiceTypeKeyValue,
+		gocb.ServiceTypeViews,
+		gocb.ServiceTypeQuery,
+		gocb.ServiceTypeSearch,
+		gocb.ServiceTypeAnalytics,
+		gocb.ServiceTypeEventing,
+	} {
+		if ServiceName(st) == name {
+			return st, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,289 @@
+package pail_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/myENA/pail/v2"
+)
+
+func TestLoadEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want func(*pail.Config)
+		errs []string
+	}{
+		{
+			name: "unset",
+			want: func(*pail.Config) {},
+		},
+		{
+			name: "valid",
+			env: map[string]string{
+				"CONNECTION_STRING":  "couchbases://db",
+				"USERNAME":           "app",
+				"PASSWORD":           "secret",
+				"TLS_SKIP_VERIFY":    "true",
+				"TIMEOUTS_KV":        "2s",
+				"TIMEOUTS_QUERY":     "1m",
+				"RETRIES":            "7",
+				"RETRY_DELAY":        "10ms",
+				"RETRY_MAX_ELAPSED":  "5s",
+				"BACKOFF_TYPE":       pail.BackoffTypeExponential,
+				"BACKOFF_MAX":        "1s",
+				"BACKOFF_MULTIPLIER": "1.5",
+				"WAIT_UNTIL_READY":   "30s",
+				"WAIT_SERVICES":      "kv, n1ql,,",
+			},
+			want: func(cfg *pail.Config) {
+				cfg.ConnectionString = "couchbases://db"
+				cfg.Username = "app"
+				cfg.Password = "secret"
+				cfg.TLSSkipVerify = true
+				cfg.Timeouts.KV = 2 * time.Second
+				cfg.Timeouts.Query = time.Minute
+				cfg.Retries = 7
+				cfg.RetryDelay = 10 * time.Millisecond
+				cfg.RetryMaxElapsed = 5 * time.Second
+				cfg.Backoff = pail.BackoffConfig{Type: pail.BackoffTypeExponential, Max: time.Second, Multiplier: 1.5}
+				cfg.WaitUntilReady = 30 * time.Second
+				cfg.WaitServices = []string{"kv", "n1ql"}
+			},
+		},
+		{
+			name: "invalid",
+			env: map[string]string{
+				"TLS_SKIP_VERIFY":    "perhaps",
+				"TIMEOUTS_KV":        "2",
+				"RETRIES":            "many",
+				"BACKOFF_MULTIPLIER": "double",
+				"USERNAME":           "app",
+			},
+			// fields which fail to parse keep their previous values
+			want: func(cfg *pail.Config) { cfg.Username = "app" },
+			errs: []string{"TEST_TLS_SKIP_VERIFY", "TEST_TIMEOUTS_KV", "TEST_RETRIES", "TEST_BACKOFF_MULTIPLIER"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv("TEST_"+k, v)
+			}
+			cfg := pail.DefaultConfig()
+			cfg.Backoff.Multiplier = 3
+			want := cfg
+			tt.want(&want)
+
+			err := cfg.LoadEnv("TEST_")
+			if len(tt.errs) == 0 && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, name := range tt.errs {
+				if err == nil || !strings.Contains(err.Error(), name+":") {
+					t.Fatalf("expected an error naming %s, got %v", name, err)
+				}
+			}
+			if !reflect.DeepEqual(cfg, want) {
+				t.Fatalf("expected %+v, got %+v", want, cfg)
+			}
+		})
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	valid := func() pail.Config {
+		cfg := pail.DefaultConfig()
+		cfg.ConnectionString = "couchbase://db"
+		cfg.Username = "app"
+		return cfg
+	}
+	tests := []struct {
+		name   string
+		modify func(*pail.Config)
+		errs   []string
+	}{
+		{name: "valid", modify: func(*pail.Config) {}},
+		{
+			name: "certificate auth",
+			modify: func(cfg *pail.Config) {
+				cfg.Username = ""
+				cfg.ClientCertPath, cfg.ClientKeyPath = "cert.pem", "key.pem"
+			},
+		},
+		{
+			name:   "missing connection string and auth",
+			modify: func(cfg *pail.Config) { cfg.ConnectionString, cfg.Username = "", "" },
+			errs:   []string{"connection_string is required", "either username or client_cert_path"},
+		},
+		{
+			name:   "partial certificate",
+			modify: func(cfg *pail.Config) { cfg.ClientCertPath = "cert.pem" },
+			errs:   []string{"must be provided together"},
+		},
+		{
+			name: "mixed auth",
+			modify: func(cfg *pail.Config) {
+				cfg.ClientCertPath, cfg.ClientKeyPath = "cert.pem", "key.pem"
+			},
+			errs: []string{"may not be combined"},
+		},
+		{
+			name: "negative retries",
+			modify: func(cfg *pail.Config) {
+				cfg.Retries, cfg.RetryDelay, cfg.RetryMaxElapsed = -1, -1, -1
+			},
+			errs: []string{"retries may not be negative", "retry_delay may not be negative", "retry_max_elapsed may not be negative"},
+		},
+		{
+			name: "backoff multiplier",
+			modify: func(cfg *pail.Config) {
+				cfg.Backoff = pail.BackoffConfig{Type: pail.BackoffTypeExponential, Multiplier: 0.5}
+			},
+			errs: []string{"backoff.multiplier must be at least 1"},
+		},
+		{
+			name:   "backoff type",
+			modify: func(cfg *pail.Config) { cfg.Backoff.Type = "linear" },
+			errs:   []string{`backoff.type "linear"`},
+		},
+		{
+			name:   "wait services",
+			modify: func(cfg *pail.Config) { cfg.WaitServices = []string{"kv", "nope"} },
+			errs:   []string{`unknown service "nope"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid()
+			tt.modify(&cfg)
+			err := cfg.Validate()
+			if len(tt.errs) == 0 && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := len(unjoin(err)); err != nil && got != len(tt.errs) {
+				t.Fatalf("expected %d problems, got %d: %v", len(tt.errs), got, err)
+			}
+			for _, msg := range tt.errs {
+				if err == nil || !strings.Contains(err.Error(), msg) {
+					t.Fatalf("expected an error containing %q, got %v", msg, err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	want := pail.DefaultConfig()
+	want.ConnectionString = "couchbase://db"
+	want.Username = "app"
+	want.Timeouts.KV = 2 * time.Second
+	want.RetryMaxElapsed = time.Second
+	want.WaitServices = []string{"kv"}
+
+	tests := []struct {
+		name string
+		doc  string
+		err  string
+	}{
+		{
+			name: "config.yaml",
+			doc:  "connection_string: couchbase://db\nusername: app\ntimeouts:\n  kv: 2s\nretry_max_elapsed: 1s\nwait_services: [kv]\n",
+		},
+		{
+			name: "config.json",
+			doc:  `{"connection_string": "couchbase://db", "username": "app", "timeouts": {"kv": "2s"}, "retry_max_elapsed": "1s", "wait_services": ["kv"]}`,
+		},
+		{
+			name: "unknown.yaml",
+			doc:  "connection_string: couchbase://db\nusr: app\n",
+			err:  "field usr not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.name)
+			if err := os.WriteFile(path, []byte(tt.doc), 0o644); err != nil {
+				t.Fatalf("error writing config: %v", err)
+			}
+			cfg, err := pail.LoadConfigFile(path)
+			if tt.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), path+": ") || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q prefixed with the path, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(cfg, want) {
+				t.Fatalf("expected %+v, got %+v", want, cfg)
+			}
+		})
+	}
+
+	if _, err := pail.LoadConfigFile(filepath.Join(t.TempDir(), "missing.yaml")); !os.IsNotExist(err) {
+		t.Fatalf("expected a missing file to be reported, got %v", err)
+	}
+}
+
+func TestConfigDefaults(t *testing.T) {
+	cfg := pail.DefaultConfig()
+	policy := cfg.RetryPolicy()
+	if policy.Retries != 3 || policy.Delay != 50*time.Millisecond || policy.Backoff != nil {
+		t.Fatalf("expected 3 constant retries 50ms apart, got %+v", policy)
+	}
+
+	cfg.Backoff = pail.BackoffConfig{Type: pail.BackoffTypeExponential, Max: 300 * time.Millisecond}
+	policy = cfg.RetryPolicy()
+	var got []time.Duration
+	for retry := uint32(1); retry <= 4; retry++ {
+		got = append(got, policy.Backoff(retry))
+	}
+	if want := []time.Duration{50 * time.Millisecond, 100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected an exponential backoff doubling by default to %v, got %v", want, got)
+	}
+}
+
+func TestConnectWithConfig(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name   string
+		modify func(*pail.Config)
+		err    string
+	}{
+		{
+			name:   "invalid",
+			modify: func(cfg *pail.Config) { cfg.ConnectionString = "" },
+			err:    "connection_string is required",
+		},
+		{
+			name: "missing certificate",
+			modify: func(cfg *pail.Config) {
+				cfg.Username = ""
+				cfg.ClientCertPath, cfg.ClientKeyPath = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+			},
+			err: "error loading client certificate",
+		},
+		{
+			name:   "missing root ca",
+			modify: func(cfg *pail.Config) { cfg.TLSRootCAPath = filepath.Join(dir, "ca.pem") },
+			err:    "error loading tls root ca",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := pail.DefaultConfig()
+			cfg.ConnectionString = "couchbase://db"
+			cfg.Username = "app"
+			tt.modify(&cfg)
+			if c, err := pail.ConnectWithConfig(cfg); c != nil || err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected no cluster and an error containing %q, got %v, %v", tt.err, c, err)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package pail
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync/atomic"
@@ -11,8 +12,12 @@ import (
 
 type (
 	ClusterRetryFunc           func(*gocb.Cluster) error
+	BucketRetryFunc            func(*gocb.Bucket) error
 	CollectionRetryFunc        func(*gocb.Collection) error
 	QueryIndexManagerRetryFunc func(*gocb.QueryIndexManager) error
+
+	CollectionQueryIndexManagerRetryFunc func(*gocb.CollectionQueryIndexManager) error
+	UserManagerRetryFunc                 func(*gocb.UserManager) error
 )
 
 // this is a list of errors deemed to probably be related to a connection issue.
@@ -39,11 +44,37 @@ type ConnectionErrorRetryAction time.Duration
 
 func (a ConnectionErrorRetryAction) Duration() time.Duration { return time.Duration(a) }
 
+// RetryStats describes the attempts made for a single call.
+type RetryStats struct {
+	// Attempts is the total number of attempts made, whether by pail's outer Try loop or by gocb.
+	Attempts uint32
+	// SDKRetries is the number of those attempts which were retries made by gocb.
+	SDKRetries uint32
+	// Elapsed is the time from the first attempt beginning until the Try loop returned.
+	Elapsed time.Duration
+}
+
+// baseRetryContext is the retry engine shared by every retry context.  It enforces the contract described by
+// RetryPolicy, with a single budget of retries drawn upon both by the outer Try loop and by gocb through RetryAfter.
+//
+// The context must not be copied once in use, hence the constructors returning pointers.
 type baseRetryContext struct {
 	tries        uint32
 	limit        uint32
 	action       ConnectionErrorRetryAction
 	baseStrategy gocb.RetryStrategy
+
+	attempts   uint32
+	sdkRetries uint32
+	backoff    Backoff
+	classifier ErrorClassifier
+	hooks      RetryHooks
+	clock      Clock
+	maxElapsed time.Duration
+	reasons    ReasonPolicies
+	ctx        context.Context
+	start      time.Time
+	elapsed    time.Duration
 }
 
 func newBaseRetryContext(retries uint32, delay time.Duration, baseStrategy gocb.RetryStrategy) baseRetryContext {
@@ -54,31 +85,180 @@ func newBaseRetryContext(retries uint32, delay time.Duration, baseStrategy gocb.
 	}
 }
 
-func (bc baseRetryContext) RetryAfter(req gocb.RetryRequest, reason gocb.RetryReason) gocb.RetryAction {
-	// if base strategy provided, defer to it
-	if bc.baseStrategy != nil {
-		// increment counter only if this is not an "always retry" reason
-		if !reason.AlwaysRetry() {
-			atomic.AddUint32(&bc.tries, 1)
+// configure applies the backoff, classifier, hooks, time limit and reason policies of the policy the context is being
+// built for, along with the context of the call, which bounds the waits between attempts
+func (bc *baseRetryContext) configure(p RetryPolicy, ctx context.Context) {
+	bc.ctx = ctx
+	bc.backoff = p.Backoff
+	bc.classifier = p.Classifier
+	bc.hooks = p.Hooks
+	bc.clock = p.clock
+	bc.maxElapsed = p.MaxElapsed
+	bc.reasons = p.reasons
+}
+
+func (bc *baseRetryContext) delayFor(retry uint32) time.Duration {
+	if bc.backoff != nil {
+		return bc.backoff(retry)
+	}
+	return time.Duration(bc.action)
+}
+
+func (bc *baseRetryContext) retryable(err error) bool {
+	if bc.classifier != nil {
+		return bc.classifier(err)
+	}
+	return isConnectErr(err)
+}
+
+// take draws a retry from the budget, returning its number, where the first retry is 1, and whether the budget
+// permitted it
+func (bc *baseRetryContext) take() (uint32, bool) {
+	t := atomic.AddUint32(&bc.tries, 1)
+	return t, t <= bc.limit
+}
+
+// within returns true if a retry after waiting d would begin within the policy's MaxElapsed
+func (bc *baseRetryContext) within(d time.Duration) bool {
+	if bc.maxElapsed <= 0 || bc.start.IsZero() {
+		return true
+	}
+	return clockOrSystem(bc.clock).Now().Sub(bc.start)+d <= bc.maxElapsed
+}
+
+// wait sleeps ahead of retry, the number take returned for it, returning false without sleeping should the retry
+// not begin within the policy's MaxElapsed.  Should the context the loop runs under end while sleeping, its error is
+// returned.
+func (bc *baseRetryContext) wait(retry uint32, err error) (bool, error) {
+	d := bc.delayFor(retry)
+	if !bc.within(d) {
+		return false, nil
+	}
+	if bc.hooks.OnRetry != nil {
+		bc.hooks.OnRetry(retry, d, err)
+	}
+	ctx := bc.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := clockOrSystem(bc.clock).Sleep(ctx, d); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (bc *baseRetryContext) giveUp(err error) error {
+	err = fmt.Errorf("retry limit breached after %d attempts (last error: %w)", bc.Stats().Attempts, err)
+	if bc.hooks.OnGiveUp != nil {
+		bc.hooks.OnGiveUp(err)
+	}
+	return err
+}
+
+// run is the outer Try loop.  attempt is called until it succeeds, fails with an error not worth retrying, or the
+// policy's budget or time limit is exhausted.
+func (bc *baseRetryContext) run(attempt func() error) error {
+	clock := clockOrSystem(bc.clock)
+	bc.start = clock.Now()
+	defer func() {
+		bc.elapsed = clock.Now().Sub(bc.start)
+		if bc.hooks.OnDone != nil {
+			bc.hooks.OnDone(bc.Stats())
+		}
+	}()
+	for {
+		atomic.AddUint32(&bc.attempts, 1)
+		err := attempt()
+		if err == nil {
+			return nil
+		}
+		if !bc.retryable(err) {
+			return err
+		}
+		retry, ok := bc.take()
+		if !ok {
+			return bc.giveUp(err)
+		}
+		if ok, waitErr := bc.wait(retry, err); waitErr != nil {
+			return waitErr
+		} else if !ok {
+			return bc.giveUp(err)
 		}
-		return bc.baseStrategy.RetryAfter(req, reason)
 	}
+}
+
+// Attempts returns the total number of attempts made so far, whether by the outer Try loop or by gocb.
+func (bc *baseRetryContext) Attempts() uint32 {
+	return atomic.LoadUint32(&bc.attempts) + atomic.LoadUint32(&bc.sdkRetries)
+}
+
+// Stats returns the attempts made so far.  Once Try has returned they are final.
+func (bc *baseRetryContext) Stats() RetryStats {
+	stats := RetryStats{
+		Attempts:   bc.Attempts(),
+		SDKRetries: atomic.LoadUint32(&bc.sdkRetries),
+		Elapsed:    bc.elapsed,
+	}
+	if stats.Elapsed == 0 && !bc.start.IsZero() {
+		stats.Elapsed = clockOrSystem(bc.clock).Now().Sub(bc.start)
+	}
+	return stats
+}
+
+// retryAfter returns action, having counted it as a retry by gocb if it is one, and reports it
+func (bc *baseRetryContext) retryAfter(reason gocb.RetryReason, action gocb.RetryAction) gocb.RetryAction {
+	var wait time.Duration
+	if action != nil {
+		wait = action.Duration()
+	}
+	if wait > 0 {
+		if bc.within(wait) {
+			atomic.AddUint32(&bc.sdkRetries, 1)
+		} else {
+			action, wait = ConnectionErrorRetryAction(0), 0
+		}
+	}
+	if bc.hooks.OnSDKRetry != nil {
+		bc.hooks.OnSDKRetry(reason, wait)
+	}
+	return action
+}
+
+func (bc *baseRetryContext) RetryAfter(req gocb.RetryRequest, reason gocb.RetryReason) gocb.RetryAction {
+	rp, _ := bc.reasons.lookup(reason)
 	// if the source reason stems from an "always retry" error i.e., incorrect node queried for a particular vbucket,
 	// always attempt again
 	if reason.AlwaysRetry() {
-		return bc.action
+		return bc.retryAfter(reason, rp.action(0, func() gocb.RetryAction {
+			if bc.baseStrategy != nil {
+				return bc.baseStrategy.RetryAfter(req, reason)
+			}
+			return bc.action
+		}))
+	}
+	if rp.GiveUp {
+		return bc.retryAfter(reason, ConnectionErrorRetryAction(0))
 	}
 	// test for breach of retry limit
-	if t := atomic.AddUint32(&bc.tries, 1); t > bc.limit {
-		return ConnectionErrorRetryAction(0)
+	t, ok := bc.take()
+	if !ok {
+		return bc.retryAfter(reason, ConnectionErrorRetryAction(0))
 	}
-	// try again, plz.
-	return bc.action
+	return bc.retryAfter(reason, rp.action(t, func() gocb.RetryAction {
+		// if base strategy provided, defer to it
+		if bc.baseStrategy != nil {
+			return bc.baseStrategy.RetryAfter(req, reason)
+		}
+		// try again, plz.
+		return ConnectionErrorRetryAction(bc.delayFor(t))
+	}))
 }
 
 type ClusterRetryContext interface {
 	gocb.RetryStrategy
 	Try(*gocb.Cluster) error
+	// Stats returns the attempts made so far.  Once Try has returned they are final.
+	Stats() RetryStats
 }
 
 type DefaultClusterRetryContext struct {
@@ -86,34 +266,47 @@ type DefaultClusterRetryContext struct {
 	retryFunc ClusterRetryFunc
 }
 
-func NewSimpleClusterRetryContext(retries uint32, delay time.Duration, baseStrategy gocb.RetryStrategy, fn ClusterRetryFunc) DefaultClusterRetryContext {
-	rc := DefaultClusterRetryContext{
+func NewSimpleClusterRetryContext(retries uint32, delay time.Duration, baseStrategy gocb.RetryStrategy, fn ClusterRetryFunc) *DefaultClusterRetryContext {
+	rc := &DefaultClusterRetryContext{
 		baseRetryContext: newBaseRetryContext(retries, delay, baseStrategy),
 		retryFunc:        fn,
 	}
 	return rc
 }
 
-func (rc DefaultClusterRetryContext) Try(c *gocb.Cluster) error {
-	var (
-		t   uint32
-		err error
-	)
-	for t = atomic.AddUint32(&rc.tries, 1); t <= rc.limit; {
-		if err = rc.retryFunc(c); err == nil {
-			return nil
-		} else if isConnectErr(err) {
-			time.Sleep(time.Duration(rc.action))
-		} else {
-			return err
-		}
+func (rc *DefaultClusterRetryContext) Try(c *gocb.Cluster) error {
+	return rc.run(func() error { return rc.retryFunc(c) })
+}
+
+type BucketRetryContext interface {
+	gocb.RetryStrategy
+	Try(*gocb.Bucket) error
+	// Stats returns the attempts made so far.  Once Try has returned they are final.
+	Stats() RetryStats
+}
+
+type SimpleBucketRetryContext struct {
+	baseRetryContext
+	retryFunc BucketRetryFunc
+}
+
+func NewSimpleBucketRetryContext(retries uint32, delay time.Duration, baseStrategy gocb.RetryStrategy, fn BucketRetryFunc) *SimpleBucketRetryContext {
+	rc := &SimpleBucketRetryContext{
+		baseRetryContext: newBaseRetryContext(retries, delay, baseStrategy),
+		retryFunc:        fn,
 	}
-	return fmt.Errorf("retry limit breached (last error: %w)", err)
+	return rc
+}
+
+func (rc *SimpleBucketRetryContext) Try(b *gocb.Bucket) error {
+	return rc.run(func() error { return rc.retryFunc(b) })
 }
 
 type CollectionRetryContext interface {
 	gocb.RetryStrategy
 	Try(*gocb.Collection) error
+	// Stats returns the attempts made so far.  Once Try has returned they are final.
+	Stats() RetryStats
 }
 
 type SimpleCollectionRetryContext struct {
@@ -121,34 +314,23 @@ type SimpleCollectionRetryContext struct {
 	retryFunc CollectionRetryFunc
 }
 
-func NewSimpleCollectionRetryContext(retries uint32, delay time.Duration, baseStrategy gocb.RetryStrategy, fn CollectionRetryFunc) SimpleCollectionRetryContext {
-	rc := SimpleCollectionRetryContext{
+func NewSimpleCollectionRetryContext(retries uint32, delay time.Duration, baseStrategy gocb.RetryStrategy, fn CollectionRetryFunc) *SimpleCollectionRetryContext {
+	rc := &SimpleCollectionRetryContext{
 		baseRetryContext: newBaseRetryContext(retries, delay, baseStrategy),
 		retryFunc:        fn,
 	}
 	return rc
 }
 
-func (rc SimpleCollectionRetryContext) Try(c *gocb.Collection) error {
-	var (
-		t   uint32
-		err error
-	)
-	for t = atomic.AddUint32(&rc.tries, 1); t <= rc.limit; {
-		if err = rc.retryFunc(c); err == nil {
-			return nil
-		} else if isConnectErr(err) {
-			time.Sleep(time.Duration(rc.action))
-		} else {
-			return err
-		}
-	}
-	return fmt.Errorf("retry limit breached (last error: %w)", err)
+func (rc *SimpleCollectionRetryContext) Try(c *gocb.Collection) error {
+	return rc.run(func() error { return rc.retryFunc(c) })
 }
 
 type QueryIndexManagerRetryContext interface {
 	gocb.RetryStrategy
 	Try(*gocb.QueryIndexManager) error
+	// Stats returns the attempts made so far.  Once Try has returned they are final.
+	Stats() RetryStats
 }
 
 type SimpleQueryIndexManagerRetryContext struct {
@@ -156,27 +338,62 @@ type SimpleQueryIndexManagerRetryContext struct {
 	retryFunc QueryIndexManagerRetryFunc
 }
 
-func NewSimpleQueryIndexManagerRetryContext(retries uint32, delay time.Duration, baseStrategy gocb.RetryStrategy, fn QueryIndexManagerRetryFunc) SimpleQueryIndexManagerRetryContext {
-	rc := SimpleQueryIndexManagerRetryContext{
+func NewSimpleQueryIndexManagerRetryContext(retries uint32, delay time.Duration, baseStrategy gocb.RetryStrategy, fn QueryIndexManagerRetryFunc) *SimpleQueryIndexManagerRetryContext {
+	rc := &SimpleQueryIndexManagerRetryContext{
 		baseRetryContext: newBaseRetryContext(retries, delay, baseStrategy),
 		retryFunc:        fn,
 	}
 	return rc
 }
 
-func (rc SimpleQueryIndexManagerRetryContext) Try(qm *gocb.QueryIndexManager) error {
-	var (
-		t   uint32
-		err error
-	)
-	for t = atomic.AddUint32(&rc.tries, 1); t <= rc.limit; {
-		if err = rc.retryFunc(qm); err == nil {
-			return nil
-		} else if isConnectErr(err) {
-			time.Sleep(time.Duration(rc.action))
-		} else {
-			return err
-		}
+func (rc *SimpleQueryIndexManagerRetryContext) Try(qm *gocb.QueryIndexManager) error {
+	return rc.run(func() error { return rc.retryFunc(qm) })
+}
+
+type CollectionQueryIndexManagerRetryContext interface {
+	gocb.RetryStrategy
+	Try(*gocb.CollectionQueryIndexManager) error
+	// Stats returns the attempts made so far.  Once Try has returned they are final.
+	Stats() RetryStats
+}
+
+type SimpleCollectionQueryIndexManagerRetryContext struct {
+	baseRetryContext
+	retryFunc CollectionQueryIndexManagerRetryFunc
+}
+
+func NewSimpleCollectionQueryIndexManagerRetryContext(retries uint32, delay time.Duration, baseStrategy gocb.RetryStrategy, fn CollectionQueryIndexManagerRetryFunc) *SimpleCollectionQueryIndexManagerRetryContext {
+	rc := &SimpleCollectionQueryIndexManagerRetryContext{
+		baseRetryContext: newBaseRetryContext(retries, delay, baseStrategy),
+		retryFunc:        fn,
 	}
-	return fmt.Errorf("retry limit breached (last error: %w)", err)
+	return rc
+}
+
+func (rc *SimpleCollectionQueryIndexManagerRetryContext) Try(qm *gocb.CollectionQueryIndexManager) error {
+	return rc.run(func() error { return rc.retryFunc(qm) })
+}
+
+type UserManagerRetryContext interface {
+	gocb.RetryStrategy
+	Try(*gocb.UserManager) error
+	// Stats returns the attempts made so far.  Once Try has returned they are final.
+	Stats() RetryStats
+}
+
+type SimpleUserManagerRetryContext struct {
+	baseRetryContext
+	retryFunc UserManagerRetryFunc
+}
+
+func NewSimpleUserManagerRetryContext(retries uint32, delay time.Duration, baseStrategy gocb.RetryStrategy, fn UserManagerRetryFunc) *SimpleUserManagerRetryContext {
+	rc := &SimpleUserManagerRetryContext{
+		baseRetryContext: newBaseRetryContext(retries, delay, baseStrategy),
+		retryFunc:        fn,
+	}
+	return rc
+}
+
+func (rc *SimpleUserManagerRetryContext) Try(um *gocb.UserManager) error {
+	return rc.run(func() error { return rc.retryFunc(um) })
 }
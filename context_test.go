@@ -0,0 +1,304 @@
+package pail_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/myENA/pail/v2"
+	"github.com/myENA/pail/v2/pailtest"
+)
+
+type fakeRetryRequest struct{}
+
+func (fakeRetryRequest) RetryAttempts() uint32            { return 0 }
+func (fakeRetryRequest) Identifier() string               { return "fake" }
+func (fakeRetryRequest) Idempotent() bool                 { return true }
+func (fakeRetryRequest) RetryReasons() []gocb.RetryReason { return nil }
+
+// hookCounts records every hook invocation made during a single call
+type hookCounts struct {
+	retries   []uint32
+	sdkWaits  []time.Duration
+	giveUps   int
+	dones     int
+	doneStats pail.RetryStats
+}
+
+func (h *hookCounts) hooks() pail.RetryHooks {
+	return pail.RetryHooks{
+		OnRetry:  func(retry uint32, _ time.Duration, _ error) { h.retries = append(h.retries, retry) },
+		OnGiveUp: func(error) { h.giveUps++ },
+		OnDone: func(stats pail.RetryStats) {
+			h.dones++
+			h.doneStats = stats
+		},
+		OnSDKRetry: func(_ gocb.RetryReason, wait time.Duration) { h.sdkWaits = append(h.sdkWaits, wait) },
+	}
+}
+
+func testPolicy(clock pail.Clock, policy pail.RetryPolicy) pail.RetryPolicy {
+	return pail.NewCluster(nil, 0, 0).WithClock(clock).WithPolicy(policy).OperationPolicy(pail.OperationGet)
+}
+
+func TestRetryBudgetSharedWithSDK(t *testing.T) {
+	var (
+		clock = pailtest.NewFakeClock(time.Unix(0, 0))
+		hooks hookCounts
+		rc    *pail.RetryContext
+	)
+	clock.SetAutoAdvance(true)
+	policy := testPolicy(clock, pail.RetryPolicy{
+		Retries: 4,
+		Backoff: func(retry uint32) time.Duration { return time.Duration(retry) * time.Millisecond },
+		Hooks:   hooks.hooks(),
+	})
+
+	_, err := pail.Do(policy, func(ctx *pail.RetryContext) (struct{}, error) {
+		rc = ctx
+		// gocb retrying once within the attempt before it times out
+		ctx.RetryAfter(fakeRetryRequest{}, gocb.KVTemporaryFailureRetryReason)
+		return struct{}{}, gocb.ErrTimeout
+	})
+	if !errors.Is(err, gocb.ErrTimeout) {
+		t.Fatalf("expected the last error to be wrapped, got %v", err)
+	}
+
+	// retries are numbered across both loops: gocb draws 1, 3 and 5, the outer loop 2 and 4, and the fifth is refused
+	if want := []time.Duration{time.Millisecond, 3 * time.Millisecond, 0}; !reflect.DeepEqual(hooks.sdkWaits, want) {
+		t.Fatalf("expected gocb retry waits %v, got %v", want, hooks.sdkWaits)
+	}
+	if want := []uint32{2, 4}; !reflect.DeepEqual(hooks.retries, want) {
+		t.Fatalf("expected outer retries %v, got %v", want, hooks.retries)
+	}
+	if want := []time.Duration{2 * time.Millisecond, 4 * time.Millisecond}; !reflect.DeepEqual(clock.Sleeps(), want) {
+		t.Fatalf("expected outer loop sleeps %v, got %v", want, clock.Sleeps())
+	}
+
+	stats := rc.Stats()
+	if stats.Attempts != 5 || stats.SDKRetries != 2 {
+		t.Fatalf("expected 5 attempts of which 2 were gocb retries, got %+v", stats)
+	}
+	if stats.Elapsed != 6*time.Millisecond {
+		t.Fatalf("expected 6ms elapsed, got %s", stats.Elapsed)
+	}
+	if hooks.giveUps != 1 || hooks.dones != 1 {
+		t.Fatalf("expected one give up and one done, got %d and %d", hooks.giveUps, hooks.dones)
+	}
+	if hooks.doneStats != stats {
+		t.Fatalf("expected OnDone to report %+v, got %+v", stats, hooks.doneStats)
+	}
+}
+
+func TestRetryAfterRefusesOnceBudgetExhausted(t *testing.T) {
+	clock := pailtest.NewFakeClock(time.Unix(0, 0))
+	policy := testPolicy(clock, pail.RetryPolicy{Retries: 2, Delay: time.Millisecond})
+
+	var waits []time.Duration
+	_, err := pail.Do(policy, func(ctx *pail.RetryContext) (struct{}, error) {
+		for i := 0; i < 3; i++ {
+			waits = append(waits, ctx.RetryAfter(fakeRetryRequest{}, gocb.KVTemporaryFailureRetryReason).Duration())
+		}
+		return struct{}{}, gocb.ErrDocumentNotFound
+	})
+	if !errors.Is(err, gocb.ErrDocumentNotFound) {
+		t.Fatalf("expected the unretryable error to be returned, got %v", err)
+	}
+	if want := []time.Duration{time.Millisecond, time.Millisecond, 0}; !reflect.DeepEqual(waits, want) {
+		t.Fatalf("expected waits %v, got %v", want, waits)
+	}
+	if len(clock.Sleeps()) != 0 {
+		t.Fatalf("expected the outer loop not to retry an unretryable error, slept %v", clock.Sleeps())
+	}
+}
+
+func TestRetryMaxElapsed(t *testing.T) {
+	var (
+		clock = pailtest.NewFakeClock(time.Unix(0, 0))
+		hooks hookCounts
+		rc    *pail.RetryContext
+	)
+	clock.SetAutoAdvance(true)
+	policy := testPolicy(clock, pail.RetryPolicy{
+		Retries:    10,
+		Delay:      10 * time.Millisecond,
+		MaxElapsed: 25 * time.Millisecond,
+		Hooks:      hooks.hooks(),
+	})
+
+	_, err := pail.Do(policy, func(ctx *pail.RetryContext) (struct{}, error) {
+		rc = ctx
+		// gocb sleeps for whatever wait it is granted before retrying
+		clock.Advance(ctx.RetryAfter(fakeRetryRequest{}, gocb.KVTemporaryFailureRetryReason).Duration())
+		return struct{}{}, gocb.ErrTimeout
+	})
+	if !errors.Is(err, gocb.ErrTimeout) {
+		t.Fatalf("expected the last error to be wrapped, got %v", err)
+	}
+
+	// at 0ms gocb is granted 10ms, at 10ms the outer loop sleeps 10ms, and at 20ms neither may wait another 10ms
+	if want := []time.Duration{10 * time.Millisecond, 0}; !reflect.DeepEqual(hooks.sdkWaits, want) {
+		t.Fatalf("expected gocb retry waits %v, got %v", want, hooks.sdkWaits)
+	}
+	if want := []time.Duration{10 * time.Millisecond}; !reflect.DeepEqual(clock.Sleeps(), want) {
+		t.Fatalf("expected outer loop sleeps %v, got %v", want, clock.Sleeps())
+	}
+	stats := rc.Stats()
+	if want := (pail.RetryStats{Attempts: 3, SDKRetries: 1, Elapsed: 20 * time.Millisecond}); stats != want {
+		t.Fatalf("expected stats %+v, got %+v", want, stats)
+	}
+	if len(hooks.retries) != 1 || hooks.giveUps != 1 || hooks.dones != 1 {
+		t.Fatalf("expected one retry, give up and done, got %d, %d and %d", len(hooks.retries), hooks.giveUps, hooks.dones)
+	}
+}
+
+func TestRetryHooksOnSuccess(t *testing.T) {
+	var (
+		clock = pailtest.NewFakeClock(time.Unix(0, 0))
+		hooks hookCounts
+		calls int
+	)
+	clock.SetAutoAdvance(true)
+	policy := testPolicy(clock, pail.RetryPolicy{Retries: 3, Delay: time.Millisecond, Hooks: hooks.hooks()})
+
+	res, err := pail.Do(policy, func(*pail.RetryContext) (int, error) {
+		if calls++; calls < 2 {
+			return 0, gocb.ErrOverload
+		}
+		return 42, nil
+	})
+	if err != nil || res != 42 {
+		t.Fatalf("expected 42, got %d, %v", res, err)
+	}
+	if want := []uint32{1}; !reflect.DeepEqual(hooks.retries, want) {
+		t.Fatalf("expected retries %v, got %v", want, hooks.retries)
+	}
+	if hooks.giveUps != 0 || hooks.dones != 1 || len(hooks.sdkWaits) != 0 {
+		t.Fatalf("expected only one done, got %d give ups, %d dones, %d gocb retries", hooks.giveUps, hooks.dones, len(hooks.sdkWaits))
+	}
+	if want := (pail.RetryStats{Attempts: 2, Elapsed: time.Millisecond}); hooks.doneStats != want {
+		t.Fatalf("expected stats %+v, got %+v", want, hooks.doneStats)
+	}
+}
+
+func TestOptionsContextCancelsRetries(t *testing.T) {
+	clock := pailtest.NewFakeClock(time.Unix(0, 0))
+	c := pail.NewCluster(nil, 0, 0).WithClock(clock).WithPolicy(pail.RetryPolicy{
+		Retries:    5,
+		Delay:      time.Second,
+		Classifier: func(error) bool { return true },
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var attempts int
+	rc, _ := c.QueryOptions(&gocb.QueryOptions{Context: ctx}, func(*gocb.Cluster) error {
+		attempts++
+		return gocb.ErrTemporaryFailure
+	})
+	done := make(chan error, 1)
+	go func() { done <- c.Try(rc) }()
+	clock.BlockUntil(1)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) || attempts != 1 {
+		t.Fatalf("expected cancelling the options' context to stop retrying, got %v after %d attempts", err, attempts)
+	}
+}
+
+func TestRetryContextStats(t *testing.T) {
+	clock := pailtest.NewFakeClock(time.Unix(0, 0))
+	clock.SetAutoAdvance(true)
+	c := pail.NewCluster(nil, 0, 0).WithClock(clock).WithPolicy(pail.RetryPolicy{
+		Retries:    5,
+		Delay:      time.Second,
+		Classifier: func(error) bool { return true },
+	})
+	var attempts int
+	rc, _ := c.QueryOptions(nil, func(*gocb.Cluster) error {
+		if attempts++; attempts < 3 {
+			return gocb.ErrTemporaryFailure
+		}
+		return nil
+	})
+	if err := c.Try(rc); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats := rc.Stats(); stats.Attempts != 3 || stats.Elapsed != 2*time.Second {
+		t.Fatalf("expected 3 attempts over 2s from the retry context, got %+v", stats)
+	}
+}
+
+func TestReasonPolicies(t *testing.T) {
+	clock := pailtest.NewFakeClock(time.Unix(0, 0))
+	policy := pail.NewCluster(nil, 0, 0).WithClock(clock).WithPolicy(pail.RetryPolicy{
+		Retries: 3,
+		Delay:   50 * time.Millisecond,
+	}).WithReasonPolicies(pail.ReasonPolicies{
+		gocb.KVTemporaryFailureRetryReason:    {GiveUp: true},
+		gocb.KVLockedRetryReason:              {Immediate: true},
+		gocb.KVSyncWriteInProgressRetryReason: {Backoff: func(retry uint32) time.Duration { return time.Duration(retry) * 10 * time.Millisecond }},
+		gocb.KVNotMyVBucketRetryReason:        {Immediate: true},
+	}).OperationPolicy(pail.OperationGet)
+
+	var waits []time.Duration
+	_, err := pail.Do(policy, func(ctx *pail.RetryContext) (struct{}, error) {
+		for _, reason := range []gocb.RetryReason{
+			gocb.KVTemporaryFailureRetryReason,    // refused without drawing on the budget
+			gocb.KVLockedRetryReason,              // retry 1, at once
+			gocb.KVNotMyVBucketRetryReason,        // always retried, at once and without drawing on the budget
+			gocb.KVSyncWriteInProgressRetryReason, // retry 2, on the reason's backoff
+			gocb.CircuitBreakerOpenRetryReason,    // retry 3, on the policy's delay
+			gocb.KVLockedRetryReason,              // refused, the budget being spent
+		} {
+			waits = append(waits, ctx.RetryAfter(fakeRetryRequest{}, reason).Duration())
+		}
+		return struct{}{}, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []time.Duration{0, time.Microsecond, time.Microsecond, 20 * time.Millisecond, 50 * time.Millisecond, 0}
+	if !reflect.DeepEqual(waits, want) {
+		t.Fatalf("expected waits %v, got %v", want, waits)
+	}
+}
+
+func TestWithReasonPolicies(t *testing.T) {
+	policies := pail.ReasonPolicies{gocb.KVLockedRetryReason: {GiveUp: true}}
+	type wrapper interface {
+		ReasonPolicy(gocb.RetryReason) (pail.ReasonPolicy, bool)
+	}
+	var (
+		c  = pail.NewCluster(nil, 0, 0)
+		p  = pail.NewPail(nil, 0, 0)
+		s  = new(pail.Scope)
+		cl = new(pail.Collection)
+		qm = pail.NewQueryIndexManager(nil, 0, 0)
+	)
+	tests := []struct {
+		name          string
+		orig, derived wrapper
+	}{
+		{name: "cluster", orig: c, derived: c.WithReasonPolicies(policies)},
+		{name: "pail", orig: p, derived: p.WithReasonPolicies(policies)},
+		{name: "scope", orig: s, derived: s.WithReasonPolicies(policies)},
+		{name: "collection", orig: cl, derived: cl.WithReasonPolicies(policies)},
+		{name: "query index manager", orig: qm, derived: qm.WithReasonPolicies(policies)},
+	}
+	// the policies are copied, so later changes to the map are not seen
+	policies[gocb.KVLockedRetryReason] = pail.ReasonPolicy{}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if p, ok := tt.derived.ReasonPolicy(gocb.KVLockedRetryReason); !ok || !p.GiveUp {
+				t.Fatalf("expected the copy to give up on locked documents, got %+v, %t", p, ok)
+			}
+			if _, ok := tt.orig.ReasonPolicy(gocb.KVLockedRetryReason); ok {
+				t.Fatal("expected the original to be unaffected")
+			}
+		})
+	}
+}
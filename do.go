@@ -0,0 +1,86 @@
+package pail
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// RetryContext is handed to each attempt made by Do.  It is a gocb.RetryStrategy, and setting it as the RetryStrategy
+// of the options of the gocb call being attempted, e.g. via WithRetryStrategy, gives the call the same retry
+// semantics as the calls pail wraps itself, retries made by gocb drawing on the same budget as those made by Do.
+type RetryContext struct {
+	baseRetryContext
+}
+
+// Do calls fn, retrying it according to policy, and returns the result of the first successful attempt.  An error
+// which policy's classifier deems not worth retrying is returned immediately.  Once the policy's retries are
+// exhausted, the last error is returned wrapped as with the Try methods.
+//
+// Do allows gocb calls pail has no wrapper for to be protected all the same:
+//
+//	policy := collection.OperationPolicy(pail.OperationGet)
+//	res, err := pail.Do(policy, func(ctx *pail.RetryContext) (*gocb.ExistsResult, error) {
+//		return collection.Collection.Exists(id, pail.WithRetryStrategy(opts, ctx))
+//	})
+func Do[T any](policy RetryPolicy, fn func(ctx *RetryContext) (T, error)) (T, error) {
+	return DoContext(context.Background(), policy, fn)
+}
+
+// DoContext is Do, with ctx bounding the waits between attempts.  Should ctx end while waiting, no further attempt is
+// made and ctx's error is returned.  ctx is available to fn from the RetryContext's Context method, so that it may be
+// handed on to the gocb call.
+func DoContext[T any](ctx context.Context, policy RetryPolicy, fn func(ctx *RetryContext) (T, error)) (T, error) {
+	rc := &RetryContext{baseRetryContext: newBaseRetryContext(retryLimit(policy.Retries), policy.Delay, nil)}
+	rc.configure(policy, ctx)
+	var res T
+	err := rc.run(func() error {
+		var err error
+		res, err = fn(rc)
+		return err
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return res, nil
+}
+
+// Context returns the context handed to DoContext, or context.Background for Do.
+func (rc *RetryContext) Context() context.Context {
+	return rc.ctx
+}
+
+var retryStrategyType = reflect.TypeOf((*gocb.RetryStrategy)(nil)).Elem()
+
+// WithRetryStrategy returns a copy of opts with its RetryStrategy field set to strategy.  opts may be any gocb options
+// struct, or a pointer to one in which case a nil pointer is treated as the zero options.  It panics should opts have
+// no RetryStrategy field.
+func WithRetryStrategy[O any](opts O, strategy gocb.RetryStrategy) O {
+	v := reflect.ValueOf(&opts).Elem()
+	target := v
+	if v.Kind() == reflect.Ptr {
+		target = reflect.New(v.Type().Elem()).Elem()
+		if !v.IsNil() {
+			target.Set(v.Elem())
+		}
+	}
+	if target.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("pail: WithRetryStrategy given %s, not an options struct", v.Type()))
+	}
+	f := target.FieldByName("RetryStrategy")
+	if !f.IsValid() || f.Type() != retryStrategyType || !f.CanSet() {
+		panic(fmt.Sprintf("pail: %s has no RetryStrategy field", target.Type()))
+	}
+	if strategy == nil {
+		f.Set(reflect.Zero(retryStrategyType))
+	} else {
+		f.Set(reflect.ValueOf(strategy))
+	}
+	if v.Kind() == reflect.Ptr {
+		v.Set(target.Addr())
+	}
+	return opts
+}
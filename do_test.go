@@ -0,0 +1,140 @@
+package pail_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/myENA/pail/v2"
+	"github.com/myENA/pail/v2/pailtest"
+)
+
+func TestWithRetryStrategy(t *testing.T) {
+	strategy := gocb.NewBestEffortRetryStrategy(nil)
+
+	in := gocb.GetOptions{Timeout: time.Second}
+	out := pail.WithRetryStrategy(in, strategy)
+	if out.RetryStrategy != strategy || out.Timeout != time.Second || in.RetryStrategy != nil {
+		t.Fatalf("expected a copy of the options with the strategy set, got %+v from %+v", out, in)
+	}
+
+	inPtr := &gocb.GetOptions{Timeout: time.Second}
+	outPtr := pail.WithRetryStrategy(inPtr, strategy)
+	if outPtr == inPtr || outPtr.RetryStrategy != strategy || outPtr.Timeout != time.Second || inPtr.RetryStrategy != nil {
+		t.Fatalf("expected a pointer to a copy of the options with the strategy set, got %+v from %+v", outPtr, inPtr)
+	}
+
+	if outNil := pail.WithRetryStrategy((*gocb.UpsertOptions)(nil), strategy); outNil == nil || outNil.RetryStrategy != strategy {
+		t.Fatalf("expected a nil pointer to be treated as the zero options, got %+v", outNil)
+	}
+
+	if cleared := pail.WithRetryStrategy(out, nil); cleared.RetryStrategy != nil {
+		t.Fatalf("expected a nil strategy to clear the field, got %+v", cleared)
+	}
+}
+
+func TestWithRetryStrategyPanics(t *testing.T) {
+	tests := []struct {
+		name string
+		call func()
+		want string
+	}{
+		{
+			name: "no field",
+			call: func() { pail.WithRetryStrategy(struct{ Timeout time.Duration }{}, nil) },
+			want: "has no RetryStrategy field",
+		},
+		{
+			name: "wrong type",
+			call: func() { pail.WithRetryStrategy(&struct{ RetryStrategy string }{}, nil) },
+			want: "has no RetryStrategy field",
+		},
+		{
+			name: "unexported",
+			call: func() { pail.WithRetryStrategy(struct{ retryStrategy gocb.RetryStrategy }{}, nil) },
+			want: "has no RetryStrategy field",
+		},
+		{
+			name: "not a struct",
+			call: func() { pail.WithRetryStrategy(3, nil) },
+			want: "not an options struct",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				msg, _ := recover().(string)
+				if !strings.Contains(msg, tt.want) {
+					t.Fatalf("expected a panic containing %q, got %q", tt.want, msg)
+				}
+			}()
+			tt.call()
+		})
+	}
+}
+
+func TestDo(t *testing.T) {
+	clock := pailtest.NewFakeClock(time.Unix(0, 0))
+	clock.SetAutoAdvance(true)
+	policy := testPolicy(clock, pail.RetryPolicy{
+		Retries:    3,
+		Delay:      time.Millisecond,
+		Classifier: func(err error) bool { return errors.Is(err, gocb.ErrTemporaryFailure) },
+	})
+
+	var attempts int
+	res, err := pail.Do(policy, func(ctx *pail.RetryContext) (int, error) {
+		attempts++
+		if opts := pail.WithRetryStrategy(&gocb.GetOptions{}, ctx); opts.RetryStrategy != ctx {
+			t.Fatalf("expected the retry context to be usable as the call's strategy")
+		}
+		if attempts < 3 {
+			return 0, gocb.ErrTemporaryFailure
+		}
+		return attempts, nil
+	})
+	if err != nil || res != 3 {
+		t.Fatalf("expected success on the third attempt, got %d, %v", res, err)
+	}
+
+	attempts = 0
+	_, err = pail.Do(policy, func(*pail.RetryContext) (int, error) {
+		attempts++
+		return 0, gocb.ErrDocumentNotFound
+	})
+	if !errors.Is(err, gocb.ErrDocumentNotFound) || attempts != 1 {
+		t.Fatalf("expected an error not worth retrying to be returned at once, got %v after %d attempts", err, attempts)
+	}
+}
+
+func TestDoContext(t *testing.T) {
+	clock := pailtest.NewFakeClock(time.Unix(0, 0))
+	policy := testPolicy(clock, pail.RetryPolicy{
+		Retries:    5,
+		Delay:      time.Second,
+		Classifier: func(error) bool { return true },
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var attempts int
+	done := make(chan error, 1)
+	go func() {
+		_, err := pail.DoContext(ctx, policy, func(rc *pail.RetryContext) (struct{}, error) {
+			if rc.Context() != ctx {
+				t.Errorf("expected the attempt to be handed the call's context")
+			}
+			attempts++
+			return struct{}{}, gocb.ErrTemporaryFailure
+		})
+		done <- err
+	}()
+	clock.BlockUntil(1)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) || attempts != 1 {
+		t.Fatalf("expected cancelling the context to stop retrying, got %v after %d attempts", err, attempts)
+	}
+}
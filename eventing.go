@@ -0,0 +1,280 @@
+package pail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+const (
+	defaultEventingPollInterval = time.Second
+)
+
+// ErrEventingFunctionStatusTimeout is returned by WaitForFunctionStatus when the function does not reach the desired
+// status in time.
+var ErrEventingFunctionStatusTimeout = errors.New("timed out waiting for eventing function status")
+
+// EventingFunctionManager wraps gocb's EventingFunctionManager, executing each call through a ClusterRetryContext
+// against the parent cluster.
+type EventingFunctionManager struct {
+	*gocb.EventingFunctionManager
+	commonRetryable
+
+	cluster *Cluster
+}
+
+func (c *Cluster) TryEventingFunctions() *EventingFunctionManager {
+	em := new(EventingFunctionManager)
+	em.EventingFunctionManager = c.Cluster.EventingFunctions()
+	em.commonRetryable = c.commonRetryable
+	em.cluster = c
+	return em
+}
+
+func (em *EventingFunctionManager) Try(ctx ClusterRetryContext) error {
+	return em.cluster.Try(ctx)
+}
+
+func (em *EventingFunctionManager) UpsertEventingFunctionOptions(in *gocb.UpsertEventingFunctionOptions, fn ClusterRetryFunc) (ClusterRetryContext, *gocb.UpsertEventingFunctionOptions) {
+	out := new(gocb.UpsertEventingFunctionOptions)
+	if in != nil {
+		*out = *in
+	}
+	policy, base := em.resolvePolicy(OperationEventingManagement, out.RetryStrategy)
+	fn = limitConcurrency(em.concurrency, out.Context, fn)
+	fn = throttle(em.limiter, out.Context, fn)
+	ctx := NewSimpleClusterRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	out.RetryStrategy = ctx
+	return ctx, out
+}
+
+func (em *EventingFunctionManager) DropEventingFunctionOptions(in *gocb.DropEventingFunctionOptions, fn ClusterRetryFunc) (ClusterRetryContext, *gocb.DropEventingFunctionOptions) {
+	out := new(gocb.DropEventingFunctionOptions)
+	if in != nil {
+		*out = *in
+	}
+	policy, base := em.resolvePolicy(OperationEventingManagement, out.RetryStrategy)
+	fn = limitConcurrency(em.concurrency, out.Context, fn)
+	fn = throttle(em.limiter, out.Context, fn)
+	ctx := NewSimpleClusterRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	out.RetryStrategy = ctx
+	return ctx, out
+}
+
+func (em *EventingFunctionManager) DeployEventingFunctionOptions(in *gocb.DeployEventingFunctionOptions, fn ClusterRetryFunc) (ClusterRetryContext, *gocb.DeployEventingFunctionOptions) {
+	out := new(gocb.DeployEventingFunctionOptions)
+	if in != nil {
+		*out = *in
+	}
+	policy, base := em.resolvePolicy(OperationEventingManagement, out.RetryStrategy)
+	fn = limitConcurrency(em.concurrency, out.Context, fn)
+	fn = throttle(em.limiter, out.Context, fn)
+	ctx := NewSimpleClusterRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	out.RetryStrategy = ctx
+	return ctx, out
+}
+
+func (em *EventingFunctionManager) UndeployEventingFunctionOptions(in *gocb.UndeployEventingFunctionOptions, fn ClusterRetryFunc) (ClusterRetryContext, *gocb.UndeployEventingFunctionOptions) {
+	out := new(gocb.UndeployEventingFunctionOptions)
+	if in != nil {
+		*out = *in
+	}
+	policy, base := em.resolvePolicy(OperationEventingManagement, out.RetryStrategy)
+	fn = limitConcurrency(em.concurrency, out.Context, fn)
+	fn = throttle(em.limiter, out.Context, fn)
+	ctx := NewSimpleClusterRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	out.RetryStrategy = ctx
+	return ctx, out
+}
+
+func (em *EventingFunctionManager) GetAllEventingFunctionsOptions(in *gocb.GetAllEventingFunctionsOptions, fn ClusterRetryFunc) (ClusterRetryContext, *gocb.GetAllEventingFunctionsOptions) {
+	out := new(gocb.GetAllEventingFunctionsOptions)
+	if in != nil {
+		*out = *in
+	}
+	policy, base := em.resolvePolicy(OperationEventingManagement, out.RetryStrategy)
+	fn = limitConcurrency(em.concurrency, out.Context, fn)
+	fn = throttle(em.limiter, out.Context, fn)
+	ctx := NewSimpleClusterRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	out.RetryStrategy = ctx
+	return ctx, out
+}
+
+func (em *EventingFunctionManager) GetEventingFunctionOptions(in *gocb.GetEventingFunctionOptions, fn ClusterRetryFunc) (ClusterRetryContext, *gocb.GetEventingFunctionOptions) {
+	out := new(gocb.GetEventingFunctionOptions)
+	if in != nil {
+		*out = *in
+	}
+	policy, base := em.resolvePolicy(OperationEventingManagement, out.RetryStrategy)
+	fn = limitConcurrency(em.concurrency, out.Context, fn)
+	fn = throttle(em.limiter, out.Context, fn)
+	ctx := NewSimpleClusterRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	out.RetryStrategy = ctx
+	return ctx, out
+}
+
+func (em *EventingFunctionManager) PauseEventingFunctionOptions(in *gocb.PauseEventingFunctionOptions, fn ClusterRetryFunc) (ClusterRetryContext, *gocb.PauseEventingFunctionOptions) {
+	out := new(gocb.PauseEventingFunctionOptions)
+	if in != nil {
+		*out = *in
+	}
+	policy, base := em.resolvePolicy(OperationEventingManagement, out.RetryStrategy)
+	fn = limitConcurrency(em.concurrency, out.Context, fn)
+	fn = throttle(em.limiter, out.Context, fn)
+	ctx := NewSimpleClusterRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	out.RetryStrategy = ctx
+	return ctx, out
+}
+
+func (em *EventingFunctionManager) ResumeEventingFunctionOptions(in *gocb.ResumeEventingFunctionOptions, fn ClusterRetryFunc) (ClusterRetryContext, *gocb.ResumeEventingFunctionOptions) {
+	out := new(gocb.ResumeEventingFunctionOptions)
+	if in != nil {
+		*out = *in
+	}
+	policy, base := em.resolvePolicy(OperationEventingManagement, out.RetryStrategy)
+	fn = limitConcurrency(em.concurrency, out.Context, fn)
+	fn = throttle(em.limiter, out.Context, fn)
+	ctx := NewSimpleClusterRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	out.RetryStrategy = ctx
+	return ctx, out
+}
+
+func (em *EventingFunctionManager) EventingFunctionsStatusOptions(in *gocb.EventingFunctionsStatusOptions, fn ClusterRetryFunc) (ClusterRetryContext, *gocb.EventingFunctionsStatusOptions) {
+	out := new(gocb.EventingFunctionsStatusOptions)
+	if in != nil {
+		*out = *in
+	}
+	policy, base := em.resolvePolicy(OperationEventingManagement, out.RetryStrategy)
+	fn = limitConcurrency(em.concurrency, out.Context, fn)
+	fn = throttle(em.limiter, out.Context, fn)
+	ctx := NewSimpleClusterRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	out.RetryStrategy = ctx
+	return ctx, out
+}
+
+func (em *EventingFunctionManager) TryUpsertFunction(function gocb.EventingFunction, opts *gocb.UpsertEventingFunctionOptions) error {
+	var ctx ClusterRetryContext
+	ctx, opts = em.UpsertEventingFunctionOptions(opts, func(c *gocb.Cluster) error { return c.EventingFunctions().UpsertFunction(function, opts) })
+	return em.Try(ctx)
+}
+
+func (em *EventingFunctionManager) TryDropFunction(name string, opts *gocb.DropEventingFunctionOptions) error {
+	var ctx ClusterRetryContext
+	ctx, opts = em.DropEventingFunctionOptions(opts, func(c *gocb.Cluster) error { return c.EventingFunctions().DropFunction(name, opts) })
+	return em.Try(ctx)
+}
+
+func (em *EventingFunctionManager) TryDeployFunction(name string, opts *gocb.DeployEventingFunctionOptions) error {
+	var ctx ClusterRetryContext
+	ctx, opts = em.DeployEventingFunctionOptions(opts, func(c *gocb.Cluster) error { return c.EventingFunctions().DeployFunction(name, opts) })
+	return em.Try(ctx)
+}
+
+func (em *EventingFunctionManager) TryUndeployFunction(name string, opts *gocb.UndeployEventingFunctionOptions) error {
+	var ctx ClusterRetryContext
+	ctx, opts = em.UndeployEventingFunctionOptions(opts, func(c *gocb.Cluster) error { return c.EventingFunctions().UndeployFunction(name, opts) })
+	return em.Try(ctx)
+}
+
+func (em *EventingFunctionManager) TryPauseFunction(name string, opts *gocb.PauseEventingFunctionOptions) error {
+	var ctx ClusterRetryContext
+	ctx, opts = em.PauseEventingFunctionOptions(opts, func(c *gocb.Cluster) error { return c.EventingFunctions().PauseFunction(name, opts) })
+	return em.Try(ctx)
+}
+
+func (em *EventingFunctionManager) TryResumeFunction(name string, opts *gocb.ResumeEventingFunctionOptions) error {
+	var ctx ClusterRetryContext
+	ctx, opts = em.ResumeEventingFunctionOptions(opts, func(c *gocb.Cluster) error { return c.EventingFunctions().ResumeFunction(name, opts) })
+	return em.Try(ctx)
+}
+
+func (em *EventingFunctionManager) TryGetFunction(name string, opts *gocb.GetEventingFunctionOptions) (*gocb.EventingFunction, error) {
+	var (
+		res *gocb.EventingFunction
+		ctx ClusterRetryContext
+		err error
+	)
+	ctx, opts = em.GetEventingFunctionOptions(opts, func(c *gocb.Cluster) error { res, err = c.EventingFunctions().GetFunction(name, opts); return err })
+	if tryErr := em.Try(ctx); tryErr != nil {
+		return nil, tryErr
+	}
+	return res, err
+}
+
+func (em *EventingFunctionManager) TryGetAllFunctions(opts *gocb.GetAllEventingFunctionsOptions) ([]gocb.EventingFunction, error) {
+	var (
+		res []gocb.EventingFunction
+		ctx ClusterRetryContext
+		err error
+	)
+	ctx, opts = em.GetAllEventingFunctionsOptions(opts, func(c *gocb.Cluster) error { res, err = c.EventingFunctions().GetAllFunctions(opts); return err })
+	if tryErr := em.Try(ctx); tryErr != nil {
+		return nil, tryErr
+	}
+	return res, err
+}
+
+func (em *EventingFunctionManager) TryFunctionsStatus(opts *gocb.EventingFunctionsStatusOptions) (*gocb.EventingStatus, error) {
+	var (
+		res *gocb.EventingStatus
+		ctx ClusterRetryContext
+		err error
+	)
+	ctx, opts = em.EventingFunctionsStatusOptions(opts, func(c *gocb.Cluster) error { res, err = c.EventingFunctions().FunctionsStatus(opts); return err })
+	if tryErr := em.Try(ctx); tryErr != nil {
+		return nil, tryErr
+	}
+	return res, err
+}
+
+// WaitForFunctionStatus polls FunctionsStatus until the named function reports the desired status, or timeout
+// elapses.  A function missing from the status report, as can briefly happen after an upsert, is treated as not yet
+// having reached the desired status.  Polling stops early should opts.Context end, returning its error.
+func (em *EventingFunctionManager) WaitForFunctionStatus(name string, status gocb.EventingFunctionStatus, timeout time.Duration, opts *gocb.EventingFunctionsStatusOptions) (*gocb.EventingFunctionState, error) {
+	var (
+		last     = "not found"
+		ctx      = context.Background()
+		clock    = em.Clock()
+		deadline = clock.Now().Add(timeout)
+	)
+	if opts != nil && opts.Context != nil {
+		ctx = opts.Context
+	}
+	for {
+		res, err := em.TryFunctionsStatus(opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, fn := range res.Functions {
+			if fn.Name != name {
+				continue
+			}
+			if fn.Status == status {
+				return &fn, nil
+			}
+			last = fmt.Sprintf("%q", fn.Status)
+			break
+		}
+		wait := deadline.Sub(clock.Now())
+		if wait <= 0 {
+			return nil, fmt.Errorf("%w: %q is %s, wanted %q", ErrEventingFunctionStatusTimeout, name, last, status)
+		} else if wait > defaultEventingPollInterval {
+			wait = defaultEventingPollInterval
+		}
+		if err := clock.Sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -2,7 +2,10 @@ module github.com/myENA/pail/v2
 
 go 1.25.1
 
-require github.com/couchbase/gocb/v2 v2.11.1
+require (
+	github.com/couchbase/gocb/v2 v2.11.1
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/couchbase/gocbcore/v10 v10.8.1 // indirect
@@ -0,0 +1,277 @@
+package pail
+
+import (
+	"sort"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+type HealthState string
+
+const (
+	HealthStateHealthy  HealthState = "healthy"
+	HealthStateDegraded HealthState = "degraded"
+	HealthStateDown     HealthState = "down"
+)
+
+// ServiceName returns the name used for the service within health reports.
+func ServiceName(service gocb.ServiceType) string {
+	switch service {
+	case gocb.ServiceTypeManagement:
+		return "mgmt"
+	case gocb.ServiceTypeKeyValue:
+		return "kv"
+	case gocb.ServiceTypeViews:
+		return "views"
+	case gocb.ServiceTypeQuery:
+		return "query"
+	case gocb.ServiceTypeSearch:
+		return "search"
+	case gocb.ServiceTypeAnalytics:
+		return "analytics"
+	case gocb.ServiceTypeEventing:
+		return "eventing"
+	}
+	return "unknown"
+}
+
+type EndpointHealth struct {
+	ID        string        `json:"id,omitempty"`
+	Remote    string        `json:"remote,omitempty"`
+	Namespace string        `json:"namespace,omitempty"`
+	State     HealthState   `json:"state"`
+	Latency   time.Duration `json:"latency,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+type ServiceHealth struct {
+	Service   string           `json:"service"`
+	State     HealthState      `json:"state"`
+	Endpoints []EndpointHealth `json:"endpoints"`
+}
+
+// HealthReport is a simplified view of a gocb ping or diagnostics result.  A service is healthy if every one of its
+// endpoints is, down if none are, and degraded otherwise.  The report's overall state is derived from its services in
+// the same way.
+type HealthReport struct {
+	ID       string          `json:"id,omitempty"`
+	State    HealthState     `json:"state"`
+	Services []ServiceHealth `json:"services"`
+}
+
+// Service returns the health of the named service, if it was present in the report.
+func (r *HealthReport) Service(name string) (ServiceHealth, bool) {
+	for _, s := range r.Services {
+		if s.Service == name {
+			return s, true
+		}
+	}
+	return ServiceHealth{}, false
+}
+
+func summarizeHealth(states []HealthState) HealthState {
+	var healthy, down int
+	for _, st := range states {
+		switch st {
+		case HealthStateHealthy:
+			healthy++
+		case HealthStateDown:
+			down++
+		}
+	}
+	switch {
+	case len(states) == 0 || down == len(states):
+		return HealthStateDown
+	case healthy == len(states):
+		return HealthStateHealthy
+	}
+	return HealthStateDegraded
+}
+
+func newHealthReport(id string, services map[string][]EndpointHealth) *HealthReport {
+	report := &HealthReport{ID: id}
+	serviceStates := make([]HealthState, 0, len(services))
+	for name, endpoints := range services {
+		states := make([]HealthState, len(endpoints))
+		for i, ep := range endpoints {
+			states[i] = ep.State
+		}
+		sh := ServiceHealth{Service: name, State: summarizeHealth(states), Endpoints: endpoints}
+		report.Services = append(report.Services, sh)
+		serviceStates = append(serviceStates, sh.State)
+	}
+	sort.Slice(report.Services, func(i, j int) bool { return report.Services[i].Service < report.Services[j].Service })
+	report.State = summarizeHealth(serviceStates)
+	return report
+}
+
+// NewPingHealthReport summarizes a gocb ping result.
+func NewPingHealthReport(res *gocb.PingResult) *HealthReport {
+	services := make(map[string][]EndpointHealth, len(res.Services))
+	for service, reports := range res.Services {
+		endpoints := make([]EndpointHealth, len(reports))
+		for i, r := range reports {
+			endpoints[i] = EndpointHealth{
+				ID:        r.ID,
+				Remote:    r.Remote,
+				Namespace: r.Namespace,
+				State:     HealthStateDown,
+				Latency:   r.Latency,
+				Error:     r.Error,
+			}
+			if r.State == gocb.PingStateOk {
+				endpoints[i].State = HealthStateHealthy
+			}
+		}
+		services[ServiceName(service)] = endpoints
+	}
+	return newHealthReport(res.ID, services)
+}
+
+// NewDiagnosticsHealthReport summarizes a gocb diagnostics result.  Connected endpoints are healthy, connecting or
+// disconnecting endpoints are degraded, and disconnected endpoints are down.
+func NewDiagnosticsHealthReport(res *gocb.DiagnosticsResult) *HealthReport {
+	services := make(map[string][]EndpointHealth, len(res.Services))
+	for service, diags := range res.Services {
+		endpoints := make([]EndpointHealth, len(diags))
+		for i, d := range diags {
+			endpoints[i] = EndpointHealth{
+				ID:        d.ID,
+				Remote:    d.Remote,
+				Namespace: d.Namespace,
+				State:     HealthStateDown,
+			}
+			switch d.State {
+			case gocb.EndpointStateConnected:
+				endpoints[i].State = HealthStateHealthy
+			case gocb.EndpointStateConnecting, gocb.EndpointStateDisconnecting:
+				endpoints[i].State = HealthStateDegraded
+			}
+		}
+		services[service] = endpoints
+	}
+	return newHealthReport(res.ID, services)
+}
+
+func (c *Cluster) PingOptions(in *gocb.PingOptions, fn ClusterRetryFunc) (ClusterRetryContext, *gocb.PingOptions) {
+	out := new(gocb.PingOptions)
+	if in != nil {
+		*out = *in
+	}
+	// ping does not accept a retry strategy, so only the outer loop applies
+	policy, base := c.resolvePolicy(OperationDiagnostics, nil)
+	fn = limitConcurrency(c.concurrency, out.Context, fn)
+	fn = throttle(c.limiter, out.Context, fn)
+	ctx := NewSimpleClusterRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	return ctx, out
+}
+
+// WaitUntilReadyOptions builds the options and retry context for a wait.  A wait timing out is not retried, the wait
+// having already lasted as long as it was asked to.
+func (c *Cluster) WaitUntilReadyOptions(in *gocb.WaitUntilReadyOptions, fn ClusterRetryFunc) (ClusterRetryContext, *gocb.WaitUntilReadyOptions) {
+	out := new(gocb.WaitUntilReadyOptions)
+	if in != nil {
+		*out = *in
+	}
+	policy, base := c.resolvePolicy(OperationDiagnostics, out.RetryStrategy)
+	policy = policy.withoutTimeoutRetries()
+	fn = limitConcurrency(c.concurrency, out.Context, fn)
+	fn = throttle(c.limiter, out.Context, fn)
+	ctx := NewSimpleClusterRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	out.RetryStrategy = ctx
+	return ctx, out
+}
+
+// TryPing pings every endpoint of the requested services, returning a summarized health report.  The raw ping result
+// is available from gocb's Ping should more detail be needed.
+func (c *Cluster) TryPing(opts *gocb.PingOptions) (*HealthReport, error) {
+	var (
+		res *gocb.PingResult
+		ctx ClusterRetryContext
+		err error
+	)
+	ctx, opts = c.PingOptions(opts, func(c *gocb.Cluster) error { res, err = c.Ping(opts); return err })
+	if tryErr := c.Try(ctx); tryErr != nil {
+		return nil, tryErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewPingHealthReport(res), nil
+}
+
+func (c *Cluster) TryWaitUntilReady(timeout time.Duration, opts *gocb.WaitUntilReadyOptions) error {
+	var ctx ClusterRetryContext
+	ctx, opts = c.WaitUntilReadyOptions(opts, func(c *gocb.Cluster) error { return c.WaitUntilReady(timeout, opts) })
+	return c.Try(ctx)
+}
+
+// DiagnosticsReport summarizes the SDK's current view of its connections without performing any network requests.
+func (c *Cluster) DiagnosticsReport(opts *gocb.DiagnosticsOptions) (*HealthReport, error) {
+	res, err := c.Cluster.Diagnostics(opts)
+	if err != nil {
+		return nil, err
+	}
+	return NewDiagnosticsHealthReport(res), nil
+}
+
+func (p *Pail) Try(ctx BucketRetryContext) error {
+	return ctx.Try(p.Bucket)
+}
+
+func (p *Pail) PingOptions(in *gocb.PingOptions, fn BucketRetryFunc) (BucketRetryContext, *gocb.PingOptions) {
+	out := new(gocb.PingOptions)
+	if in != nil {
+		*out = *in
+	}
+	// ping does not accept a retry strategy, so only the outer loop applies
+	policy, base := p.resolvePolicy(OperationDiagnostics, nil)
+	fn = limitConcurrency(p.concurrency, out.Context, fn)
+	fn = throttle(p.limiter, out.Context, fn)
+	ctx := NewSimpleBucketRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	return ctx, out
+}
+
+// WaitUntilReadyOptions builds the options and retry context for a wait.  A wait timing out is not retried, the wait
+// having already lasted as long as it was asked to.
+func (p *Pail) WaitUntilReadyOptions(in *gocb.WaitUntilReadyOptions, fn BucketRetryFunc) (BucketRetryContext, *gocb.WaitUntilReadyOptions) {
+	out := new(gocb.WaitUntilReadyOptions)
+	if in != nil {
+		*out = *in
+	}
+	policy, base := p.resolvePolicy(OperationDiagnostics, out.RetryStrategy)
+	policy = policy.withoutTimeoutRetries()
+	fn = limitConcurrency(p.concurrency, out.Context, fn)
+	fn = throttle(p.limiter, out.Context, fn)
+	ctx := NewSimpleBucketRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	out.RetryStrategy = ctx
+	return ctx, out
+}
+
+// TryPing pings every endpoint of the requested services for this bucket, returning a summarized health report.
+func (p *Pail) TryPing(opts *gocb.PingOptions) (*HealthReport, error) {
+	var (
+		res *gocb.PingResult
+		ctx BucketRetryContext
+		err error
+	)
+	ctx, opts = p.PingOptions(opts, func(b *gocb.Bucket) error { res, err = b.Ping(opts); return err })
+	if tryErr := p.Try(ctx); tryErr != nil {
+		return nil, tryErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewPingHealthReport(res), nil
+}
+
+func (p *Pail) TryWaitUntilReady(timeout time.Duration, opts *gocb.WaitUntilReadyOptions) error {
+	var ctx BucketRetryContext
+	ctx, opts = p.WaitUntilReadyOptions(opts, func(b *gocb.Bucket) error { return b.WaitUntilReady(timeout, opts) })
+	return p.Try(ctx)
+}
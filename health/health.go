@@ -0,0 +1,194 @@
+// Package health provides HTTP liveness and readiness handlers backed by pail's Ping support.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/myENA/pail/v2"
+)
+
+const (
+	defaultCacheTTL = 5 * time.Second
+	defaultTimeout  = 2 * time.Second
+)
+
+// Pinger is satisfied by both *pail.Cluster and *pail.Pail.
+type Pinger interface {
+	TryPing(opts *gocb.PingOptions) (*pail.HealthReport, error)
+}
+
+type Option func(*Handler)
+
+// WithCacheTTL sets how long a ping result is reused before pinging again.  Defaults to 5 seconds, zero disables
+// caching.
+func WithCacheTTL(ttl time.Duration) Option {
+	return func(h *Handler) { h.cacheTTL = ttl }
+}
+
+// WithTimeout bounds how long a single ping may take.  Defaults to 2 seconds.
+func WithTimeout(timeout time.Duration) Option {
+	return func(h *Handler) { h.timeout = timeout }
+}
+
+// WithServices limits the ping to the given services.
+func WithServices(services ...gocb.ServiceType) Option {
+	return func(h *Handler) { h.services = services }
+}
+
+// WithAllowDegraded reports ready when the cluster is degraded rather than fully healthy.
+func WithAllowDegraded() Option {
+	return func(h *Handler) { h.allowDegraded = true }
+}
+
+// Clock is the time source used for caching, satisfied by any pail.Clock, pailtest.FakeClock included.
+type Clock interface {
+	Now() time.Time
+}
+
+// WithClock overrides the time source used for caching.
+func WithClock(clock Clock) Option {
+	return func(h *Handler) { h.clock = clock }
+}
+
+// Response is the JSON body written by the readiness handler.
+type Response struct {
+	Ready     bool               `json:"ready"`
+	CheckedAt time.Time          `json:"checked_at"`
+	Error     string             `json:"error,omitempty"`
+	Report    *pail.HealthReport `json:"report,omitempty"`
+}
+
+// Handler serves readiness by pinging Couchbase, caching the result for a short while so that frequent probes do not
+// themselves load the cluster.
+type Handler struct {
+	pinger        Pinger
+	cacheTTL      time.Duration
+	timeout       time.Duration
+	services      []gocb.ServiceType
+	allowDegraded bool
+	clock         Clock
+
+	mu       sync.Mutex
+	last     Response
+	lastTime time.Time
+	inflight *ping
+}
+
+// ping is a readiness check in progress, shared by every caller arriving while it runs
+type ping struct {
+	done chan struct{}
+	resp Response
+}
+
+// LivenessResponse is the JSON body written by the liveness handler.
+type LivenessResponse struct {
+	Alive         bool      `json:"alive"`
+	LastReadiness *Response `json:"last_readiness,omitempty"`
+}
+
+func NewHandler(pinger Pinger, opts ...Option) *Handler {
+	h := &Handler{
+		pinger:   pinger,
+		cacheTTL: defaultCacheTTL,
+		timeout:  defaultTimeout,
+		clock:    pail.SystemClock,
+	}
+	for _, fn := range opts {
+		fn(h)
+	}
+	return h
+}
+
+// Check returns the current readiness, pinging only if the cached result has expired.  Callers arriving while a ping
+// is in progress wait on and share its result rather than pinging again.
+func (h *Handler) Check() Response {
+	h.mu.Lock()
+	now := h.clock.Now()
+	if !h.lastTime.IsZero() && now.Sub(h.lastTime) < h.cacheTTL {
+		resp := h.last
+		h.mu.Unlock()
+		return resp
+	}
+	if p := h.inflight; p != nil {
+		h.mu.Unlock()
+		<-p.done
+		return p.resp
+	}
+	p := &ping{done: make(chan struct{})}
+	h.inflight = p
+	h.mu.Unlock()
+
+	defer func() {
+		h.mu.Lock()
+		h.inflight = nil
+		h.mu.Unlock()
+		close(p.done)
+	}()
+	p.resp = h.ping(now)
+
+	h.mu.Lock()
+	h.last, h.lastTime = p.resp, now
+	h.mu.Unlock()
+	return p.resp
+}
+
+func (h *Handler) ping(now time.Time) Response {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+	report, err := h.pinger.TryPing(&gocb.PingOptions{
+		ServiceTypes: h.services,
+		Timeout:      h.timeout,
+		Context:      ctx,
+	})
+
+	resp := Response{CheckedAt: now, Report: report}
+	if err != nil {
+		resp.Error = err.Error()
+	} else {
+		resp.Ready = report.State == pail.HealthStateHealthy ||
+			(h.allowDegraded && report.State == pail.HealthStateDegraded)
+	}
+	return resp
+}
+
+// ServeHTTP serves readiness, responding 200 when ready and 503 otherwise.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	resp := h.Check()
+	status := http.StatusOK
+	if !resp.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, resp)
+}
+
+// Readiness returns the handler itself, for symmetry with Liveness.
+func (h *Handler) Readiness() http.Handler {
+	return h
+}
+
+// Liveness returns a handler which always responds 200 without contacting Couchbase, as restarting a process will not
+// fix an unreachable cluster.  The most recent readiness result, if any, is included for information.
+func (h *Handler) Liveness() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		resp := LivenessResponse{Alive: true}
+		h.mu.Lock()
+		if !h.lastTime.IsZero() {
+			last := h.last
+			resp.LastReadiness = &last
+		}
+		h.mu.Unlock()
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
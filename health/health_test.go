@@ -0,0 +1,162 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/myENA/pail/v2"
+	"github.com/myENA/pail/v2/pailtest"
+)
+
+type fakePinger struct {
+	state pail.HealthState
+	err   error
+	block chan struct{}
+	calls int32
+}
+
+func (p *fakePinger) TryPing(*gocb.PingOptions) (*pail.HealthReport, error) {
+	atomic.AddInt32(&p.calls, 1)
+	if p.block != nil {
+		<-p.block
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &pail.HealthReport{State: p.state}, nil
+}
+
+func (p *fakePinger) Calls() int {
+	return int(atomic.LoadInt32(&p.calls))
+}
+
+func serve(t *testing.T, h http.Handler) (int, Response) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	var resp Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("error decoding response %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, resp
+}
+
+func TestHandlerStatus(t *testing.T) {
+	tests := []struct {
+		name          string
+		state         pail.HealthState
+		err           error
+		allowDegraded bool
+		want          int
+	}{
+		{name: "healthy", state: pail.HealthStateHealthy, want: http.StatusOK},
+		{name: "down", state: pail.HealthStateDown, want: http.StatusServiceUnavailable},
+		{name: "degraded", state: pail.HealthStateDegraded, want: http.StatusServiceUnavailable},
+		{name: "degraded allowed", state: pail.HealthStateDegraded, allowDegraded: true, want: http.StatusOK},
+		{name: "down allowed degraded", state: pail.HealthStateDown, allowDegraded: true, want: http.StatusServiceUnavailable},
+		{name: "error", err: errors.New("unreachable"), want: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []Option{WithCacheTTL(0)}
+			if tt.allowDegraded {
+				opts = append(opts, WithAllowDegraded())
+			}
+			code, resp := serve(t, NewHandler(&fakePinger{state: tt.state, err: tt.err}, opts...))
+			if code != tt.want {
+				t.Fatalf("expected status %d, got %d", tt.want, code)
+			}
+			if resp.Ready != (tt.want == http.StatusOK) {
+				t.Fatalf("expected ready %t, got %t", tt.want == http.StatusOK, resp.Ready)
+			}
+			if tt.err != nil && resp.Error != tt.err.Error() {
+				t.Fatalf("expected error %q, got %q", tt.err, resp.Error)
+			}
+		})
+	}
+}
+
+func TestHandlerCacheTTL(t *testing.T) {
+	var (
+		clock  = pailtest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+		pinger = &fakePinger{state: pail.HealthStateHealthy}
+		h      = NewHandler(pinger, WithCacheTTL(5*time.Second), WithClock(clock))
+	)
+	serve(t, h)
+	clock.Advance(4 * time.Second)
+	_, resp := serve(t, h)
+	if pinger.Calls() != 1 {
+		t.Fatalf("expected the cached result within the ttl, got %d pings", pinger.Calls())
+	}
+	if !resp.CheckedAt.Equal(clock.Now().Add(-4 * time.Second)) {
+		t.Fatalf("expected the cached result to be checked at the first ping, got %s", resp.CheckedAt)
+	}
+
+	pinger.state = pail.HealthStateDown
+	clock.Advance(time.Second)
+	if code, _ := serve(t, h); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected the expired result to be replaced, got status %d", code)
+	}
+	if pinger.Calls() != 2 {
+		t.Fatalf("expected a second ping once the ttl elapsed, got %d pings", pinger.Calls())
+	}
+}
+
+func TestHandlerCollapsesConcurrentChecks(t *testing.T) {
+	pinger := &fakePinger{state: pail.HealthStateHealthy, block: make(chan struct{})}
+	h := NewHandler(pinger)
+
+	const callers = 5
+	var (
+		wg    sync.WaitGroup
+		ready int32
+	)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if h.Check().Ready {
+				atomic.AddInt32(&ready, 1)
+			}
+		}()
+	}
+	for pinger.Calls() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// liveness must answer while the ping is outstanding
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		h.Liveness().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/live", nil))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("liveness blocked behind an outstanding ping")
+	}
+	var live LivenessResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &live); err != nil {
+		t.Fatalf("error decoding liveness response: %v", err)
+	}
+	if rec.Code != http.StatusOK || !live.Alive || live.LastReadiness != nil {
+		t.Fatalf("expected alive without a prior readiness, got %d %+v", rec.Code, live)
+	}
+
+	close(pinger.block)
+	wg.Wait()
+	if pinger.Calls() != 1 {
+		t.Fatalf("expected concurrent checks to share one ping, got %d", pinger.Calls())
+	}
+	if ready != callers {
+		t.Fatalf("expected every caller to see the shared result, %d of %d did", ready, callers)
+	}
+}
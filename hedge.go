@@ -0,0 +1,195 @@
+package pail
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// HedgedGetResult is the result of a hedged get.  FromReplica is true if the document was read from a replica, in
+// which case it may be stale.
+type HedgedGetResult struct {
+	*gocb.GetResult
+	FromReplica bool
+}
+
+// HedgedLookupInResult is the result of a hedged sub-document lookup.  FromReplica is true if the lookup was served
+// by a replica, in which case it may be stale.
+type HedgedLookupInResult struct {
+	*gocb.LookupInResult
+	FromReplica bool
+}
+
+// WithHedging returns a copy of the collection with hedged reads enabled.  Should the active read of a TryGet or
+// TryLookupIn not have returned within delay, an any-replica read is issued alongside it and whichever succeeds first
+// is used.  Should the active read fail with anything other than a timeout or an error the operation's policy deems
+// transient, such as the document not being found, that error is returned at once rather than the replica's result.
+// A delay of zero or less disables hedging.
+func (c *Collection) WithHedging(delay time.Duration) *Collection {
+	out := *c
+	out.hedgeDelay = delay
+	return &out
+}
+
+// HedgeDelay returns the delay after which reads are hedged, or zero if hedging is disabled.
+func (c *Collection) HedgeDelay() time.Duration {
+	return c.hedgeDelay
+}
+
+type hedgeOutcome[T any] struct {
+	res     T
+	replica bool
+	err     error
+}
+
+// hedgeable returns a func reporting whether an active read failing with err may still be answered by a replica:
+// only timeouts and failures the policy for kind deems transient may.  Any other error, such as the document not
+// existing, is authoritative.
+func (c *Collection) hedgeable(kind OperationKind, strategy gocb.RetryStrategy) func(error) bool {
+	policy, _ := c.resolvePolicy(kind, strategy)
+	classifier := policy.Classifier
+	if classifier == nil {
+		classifier = DefaultErrorClassifier
+	}
+	return func(err error) bool {
+		return errors.Is(err, gocb.ErrTimeout) || classifier(err)
+	}
+}
+
+// hedge runs active, additionally running replica should active not have returned within delay.  The first success
+// is returned.  An active error for which fallback returns false is returned at once, without waiting on the replica;
+// should both fail the active error is returned, it being authoritative.  Once a result has been chosen ctx is
+// cancelled, abandoning whichever read is still in flight.
+func hedge[T any](parent context.Context, clock Clock, delay time.Duration, fallback func(error) bool, active func(context.Context) (T, error), replica func(context.Context) (T, bool, error)) (T, bool, error) {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	activeCh := make(chan hedgeOutcome[T], 1)
+	go func() {
+		res, err := active(ctx)
+		activeCh <- hedgeOutcome[T]{res: res, err: err}
+	}()
+
+	select {
+	case out := <-activeCh:
+		return out.res, false, out.err
+	case <-clock.After(delay):
+	}
+
+	replicaCh := make(chan hedgeOutcome[T], 1)
+	go func() {
+		res, isReplica, err := replica(ctx)
+		replicaCh <- hedgeOutcome[T]{res: res, replica: isReplica, err: err}
+	}()
+
+	var (
+		activeErr               error
+		activeDone, replicaDone bool
+	)
+	for !activeDone || !replicaDone {
+		select {
+		case out := <-activeCh:
+			if out.err == nil || !fallback(out.err) {
+				return out.res, false, out.err
+			}
+			activeErr, activeDone = out.err, true
+		case out := <-replicaCh:
+			if out.err == nil {
+				return out.res, out.replica, nil
+			}
+			replicaDone = true
+		}
+	}
+	var zero T
+	return zero, false, activeErr
+}
+
+// TryGetHedged fetches the document identified by id, hedging the read with an any-replica read as described by
+// WithHedging.  Gets using WithExpiry or Project cannot be served by a replica and are never hedged.
+func (c *Collection) TryGetHedged(id string, opts *gocb.GetOptions) (*HedgedGetResult, error) {
+	if opts == nil {
+		opts = new(gocb.GetOptions)
+	}
+	if c.hedgeDelay <= 0 || opts.WithExpiry || len(opts.Project) > 0 {
+		res, err := c.tryGetActive(id, opts)
+		if err != nil {
+			return nil, err
+		}
+		return &HedgedGetResult{GetResult: res}, nil
+	}
+	parent := opts.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	res, fromReplica, err := hedge(parent, c.Clock(), c.hedgeDelay, c.hedgeable(OperationGet, opts.RetryStrategy),
+		func(ctx context.Context) (*gocb.GetResult, error) {
+			activeOpts := *opts
+			activeOpts.Context = ctx
+			return c.tryGetActive(id, &activeOpts)
+		},
+		func(ctx context.Context) (*gocb.GetResult, bool, error) {
+			res, err := c.TryGetAnyReplica(id, &gocb.GetAnyReplicaOptions{
+				Transcoder:    opts.Transcoder,
+				Timeout:       opts.Timeout,
+				RetryStrategy: opts.RetryStrategy,
+				ParentSpan:    opts.ParentSpan,
+				Context:       ctx,
+			})
+			if err != nil {
+				return nil, false, err
+			}
+			return &res.GetResult, res.IsReplica(), nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &HedgedGetResult{GetResult: res, FromReplica: fromReplica}, nil
+}
+
+// TryLookupInHedged performs the sub-document lookups in ops against the document identified by id, hedging the
+// lookup with an any-replica lookup as described by WithHedging.
+func (c *Collection) TryLookupInHedged(id string, ops []gocb.LookupInSpec, opts *gocb.LookupInOptions) (*HedgedLookupInResult, error) {
+	if opts == nil {
+		opts = new(gocb.LookupInOptions)
+	}
+	if c.hedgeDelay <= 0 {
+		res, err := c.tryLookupInActive(id, ops, opts)
+		if err != nil {
+			return nil, err
+		}
+		return &HedgedLookupInResult{LookupInResult: res}, nil
+	}
+	parent := opts.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	res, fromReplica, err := hedge(parent, c.Clock(), c.hedgeDelay, c.hedgeable(OperationLookupIn, opts.RetryStrategy),
+		func(ctx context.Context) (*gocb.LookupInResult, error) {
+			activeOpts := *opts
+			activeOpts.Context = ctx
+			return c.tryLookupInActive(id, ops, &activeOpts)
+		},
+		func(ctx context.Context) (*gocb.LookupInResult, bool, error) {
+			replicaOpts := &gocb.LookupInAnyReplicaOptions{
+				Timeout:       opts.Timeout,
+				RetryStrategy: opts.RetryStrategy,
+				ParentSpan:    opts.ParentSpan,
+				Context:       ctx,
+			}
+			replicaOpts.Internal.DocFlags = opts.Internal.DocFlags
+			replicaOpts.Internal.User = opts.Internal.User
+			res, err := c.TryLookupInAnyReplica(id, ops, replicaOpts)
+			if err != nil {
+				return nil, false, err
+			}
+			return res.LookupInResult, res.IsReplica(), nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &HedgedLookupInResult{LookupInResult: res, FromReplica: fromReplica}, nil
+}
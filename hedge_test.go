@@ -0,0 +1,157 @@
+package pail
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// hedgeClock fires the hedge only when the test sends on after
+type hedgeClock struct {
+	systemClock
+	after chan time.Time
+}
+
+func (c hedgeClock) After(time.Duration) <-chan time.Time {
+	return c.after
+}
+
+// hedgeRead is one side of a hedged read, returning whatever the test sends on result, or ctx's error should the
+// read be abandoned first
+type hedgeRead struct {
+	started   chan struct{}
+	result    chan hedgeOutcome[string]
+	abandoned chan struct{}
+}
+
+func newHedgeRead() *hedgeRead {
+	return &hedgeRead{started: make(chan struct{}), result: make(chan hedgeOutcome[string], 1), abandoned: make(chan struct{})}
+}
+
+func (r *hedgeRead) run(ctx context.Context) (string, bool, error) {
+	close(r.started)
+	select {
+	case out := <-r.result:
+		return out.res, out.replica, out.err
+	case <-ctx.Done():
+		close(r.abandoned)
+		return "", false, ctx.Err()
+	}
+}
+
+func TestHedge(t *testing.T) {
+	transient := func(err error) bool { return errors.Is(err, gocb.ErrTimeout) }
+	notFound := gocb.ErrDocumentNotFound
+
+	tests := []struct {
+		name string
+		// drive feeds the reads once the replica has started
+		drive       func(active, replica *hedgeRead)
+		hedged      bool
+		want        string
+		fromReplica bool
+		err         error
+		abandoned   func(active, replica *hedgeRead) chan struct{}
+	}{
+		{
+			name: "active before delay",
+			want: "active",
+		},
+		{
+			name:   "active wins",
+			hedged: true,
+			drive: func(active, _ *hedgeRead) {
+				active.result <- hedgeOutcome[string]{res: "active"}
+			},
+			want:      "active",
+			abandoned: func(_, replica *hedgeRead) chan struct{} { return replica.abandoned },
+		},
+		{
+			name:   "replica wins",
+			hedged: true,
+			drive: func(_, replica *hedgeRead) {
+				replica.result <- hedgeOutcome[string]{res: "replica", replica: true}
+			},
+			want:        "replica",
+			fromReplica: true,
+			abandoned:   func(active, _ *hedgeRead) chan struct{} { return active.abandoned },
+		},
+		{
+			name:   "replica after transient active error",
+			hedged: true,
+			drive: func(active, replica *hedgeRead) {
+				active.result <- hedgeOutcome[string]{err: gocb.ErrTimeout}
+				replica.result <- hedgeOutcome[string]{res: "replica", replica: true}
+			},
+			want:        "replica",
+			fromReplica: true,
+		},
+		{
+			name:   "authoritative active error",
+			hedged: true,
+			drive: func(active, _ *hedgeRead) {
+				active.result <- hedgeOutcome[string]{err: notFound}
+			},
+			err:       notFound,
+			abandoned: func(_, replica *hedgeRead) chan struct{} { return replica.abandoned },
+		},
+		{
+			name:   "both fail",
+			hedged: true,
+			drive: func(active, replica *hedgeRead) {
+				replica.result <- hedgeOutcome[string]{err: errors.New("replica failed")}
+				active.result <- hedgeOutcome[string]{err: gocb.ErrTimeout}
+			},
+			err: gocb.ErrTimeout,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				clock   = hedgeClock{after: make(chan time.Time)}
+				active  = newHedgeRead()
+				replica = newHedgeRead()
+			)
+			if !tt.hedged {
+				active.result <- hedgeOutcome[string]{res: "active"}
+			}
+			go func() {
+				if !tt.hedged {
+					return
+				}
+				<-active.started
+				clock.after <- time.Time{}
+				<-replica.started
+				tt.drive(active, replica)
+			}()
+
+			res, fromReplica, err := hedge(context.Background(), clock, time.Millisecond, transient,
+				func(ctx context.Context) (string, error) {
+					res, _, err := active.run(ctx)
+					return res, err
+				},
+				replica.run,
+			)
+			if !errors.Is(err, tt.err) || res != tt.want || fromReplica != tt.fromReplica {
+				t.Fatalf("expected %q, replica %t, error %v, got %q, replica %t, error %v", tt.want, tt.fromReplica, tt.err, res, fromReplica, err)
+			}
+			select {
+			case <-replica.started:
+				if !tt.hedged {
+					t.Fatal("expected no replica read when the active read returned within the delay")
+				}
+			default:
+			}
+			if tt.abandoned != nil {
+				select {
+				case <-tt.abandoned(active, replica):
+				case <-time.After(time.Second):
+					t.Fatal("expected the losing read to be abandoned")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,366 @@
+package pail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// IndexDefinitionsVersion is the only index definitions file version currently understood.
+const IndexDefinitionsVersion = 1
+
+// IndexDefinitions is the typed form of an index definitions file, as parsed by LoadIndexDefinitions.  A file looks
+// like the following, and may equally be written as JSON:
+//
+//	version: 1
+//	buckets:
+//	  - name: app
+//	    scopes:
+//	      - name: tenant
+//	        collections:
+//	          - name: users
+//	            indexes:
+//	              - primary: true
+//	              - name: idx_users_email
+//	                fields: [email]
+//	                where: "active = true"
+//	                num_replica: 1
+//	                deferred: false
+type IndexDefinitions struct {
+	Version int
+	Buckets []BucketIndexDefinitions
+}
+
+// BucketIndexDefinitions holds every index spec defined for a single bucket, across all of its scopes and collections.
+type BucketIndexDefinitions struct {
+	Name    string
+	Indexes []IndexSpec
+}
+
+// IndexDefinitionError describes a single problem within an index definitions file.
+type IndexDefinitionError struct {
+	Line   int
+	Column int
+	Field  string
+	Err    error
+}
+
+func (e *IndexDefinitionError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("line %d, column %d: %v", e.Line, e.Column, e.Err)
+	}
+	return fmt.Sprintf("line %d, column %d: %s: %v", e.Line, e.Column, e.Field, e.Err)
+}
+
+func (e *IndexDefinitionError) Unwrap() error {
+	return e.Err
+}
+
+type rawIndexDefinitions struct {
+	Version int              `yaml:"version"`
+	Buckets []rawIndexBucket `yaml:"buckets"`
+}
+
+type rawIndexBucket struct {
+	Name   string          `yaml:"name"`
+	Scopes []rawIndexScope `yaml:"scopes"`
+}
+
+type rawIndexScope struct {
+	Name        string               `yaml:"name"`
+	Collections []rawIndexCollection `yaml:"collections"`
+}
+
+type rawIndexCollection struct {
+	Name    string     `yaml:"name"`
+	Indexes []rawIndex `yaml:"indexes"`
+}
+
+type rawIndex struct {
+	Name       string   `yaml:"name"`
+	Primary    bool     `yaml:"primary"`
+	Fields     []string `yaml:"fields"`
+	Where      string   `yaml:"where"`
+	Partition  string   `yaml:"partition"`
+	NumReplica int      `yaml:"num_replica"`
+	Deferred   bool     `yaml:"deferred"`
+}
+
+// LoadIndexDefinitionsFile reads and validates the YAML or JSON index definitions file at path.
+func LoadIndexDefinitionsFile(path string) (*IndexDefinitions, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	defs, err := LoadIndexDefinitions(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return defs, nil
+}
+
+// LoadIndexDefinitions reads and validates a YAML or JSON index definitions document.  Problems are returned
+// together, each as an *IndexDefinitionError locating the offending field, other than syntax errors which are
+// located by line alone.
+func LoadIndexDefinitions(r io.Reader) (*IndexDefinitions, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var root yaml.Node
+	if err = yaml.Unmarshal(b, &root); err != nil {
+		return nil, syntaxError(err)
+	}
+	if len(root.Content) == 0 {
+		return nil, errors.New("index definitions document is empty")
+	}
+
+	v := indexDefinitionsValidator{root: root.Content[0]}
+	var raw rawIndexDefinitions
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err = dec.Decode(&raw); err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return nil, syntaxError(err)
+		}
+		for _, msg := range typeErr.Errors {
+			v.decodeFailure(msg)
+		}
+		return nil, errors.Join(v.errs...)
+	}
+
+	defs := v.validate(raw)
+	if len(v.errs) > 0 {
+		return nil, errors.Join(v.errs...)
+	}
+	return defs, nil
+}
+
+var (
+	yamlLineMessage  = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+	yamlUnknownField = regexp.MustCompile(`^field (\S+) not found in type \S+$`)
+	yamlBadValue     = regexp.MustCompile("^cannot unmarshal (!!\\w+)(?: `(.*)`)? into (\\S+)$")
+)
+
+// syntaxError returns err, a yaml syntax error, as an *IndexDefinitionError where it gives a line
+func syntaxError(err error) error {
+	m := yamlLineMessage.FindStringSubmatch(err.Error())
+	if m == nil {
+		return err
+	}
+	line, _ := strconv.Atoi(m[1])
+	return &IndexDefinitionError{Line: line, Err: errors.New(m[2])}
+}
+
+type indexDefinitionsValidator struct {
+	root *yaml.Node
+	errs []error
+}
+
+// decodeFailure records msg, one of the messages of a yaml.TypeError, against the field it concerns.  yaml reports
+// only the line at fault, so the field is taken to be the first on that line whose key, for an unknown field, or
+// value, for a value of the wrong type, matches that in the message.
+func (v *indexDefinitionsValidator) decodeFailure(msg string) {
+	m := yamlLineMessage.FindStringSubmatch(msg)
+	if m == nil {
+		v.errs = append(v.errs, errors.New(msg))
+		return
+	}
+	line, _ := strconv.Atoi(m[1])
+	msg = m[2]
+	match := func(key, value *yaml.Node) bool { return key.Line == line || value.Line == line }
+	if m := yamlUnknownField.FindStringSubmatch(msg); m != nil {
+		msg = "unknown field"
+		match = func(key, _ *yaml.Node) bool { return key.Line == line && key.Value == m[1] }
+	} else if m := yamlBadValue.FindStringSubmatch(msg); m != nil {
+		tag, value, target := m[1], m[2], m[3]
+		switch {
+		case strings.HasPrefix(target, "[]"):
+			target = "a list"
+		case strings.Contains(target, "."):
+			target = "a mapping"
+		}
+		msg = fmt.Sprintf("cannot unmarshal %s into %s", strings.TrimSpace(tag+" "+value), target)
+		// yaml truncates long values
+		value = strings.TrimSuffix(value, "...")
+		match = func(_, n *yaml.Node) bool {
+			return n.Line == line && n.ShortTag() == tag && strings.HasPrefix(n.Value, value)
+		}
+	}
+	path, node := findField(v.root, nil, match)
+	if node == nil {
+		v.errs = append(v.errs, &IndexDefinitionError{Line: line, Err: errors.New(msg)})
+		return
+	}
+	v.errs = append(v.errs, &IndexDefinitionError{Line: node.Line, Column: node.Column, Field: fieldPath(path), Err: errors.New(msg)})
+}
+
+// findField searches node depth first for a field for which match is true, returning its path along with its value, if
+// a scalar on the same line, or else its key
+func findField(node *yaml.Node, path []interface{}, match func(key, value *yaml.Node) bool) ([]interface{}, *yaml.Node) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			p := append(path[:len(path):len(path)], key.Value)
+			if found, n := findField(value, p, match); n != nil {
+				return found, n
+			}
+			if match(key, value) {
+				if value.Kind == yaml.ScalarNode && value.Line == key.Line {
+					return p, value
+				}
+				return p, key
+			}
+		}
+	case yaml.SequenceNode:
+		for i, elem := range node.Content {
+			if found, n := findField(elem, append(path[:len(path):len(path)], i), match); n != nil {
+				return found, n
+			}
+		}
+	}
+	return nil, nil
+}
+
+// fieldPath renders path as a dotted field name, e.g. buckets[0].name
+func fieldPath(path []interface{}) string {
+	var field strings.Builder
+	for _, elem := range path {
+		switch elem := elem.(type) {
+		case string:
+			if field.Len() > 0 {
+				field.WriteString(".")
+			}
+			field.WriteString(elem)
+		case int:
+			fmt.Fprintf(&field, "[%d]", elem)
+		}
+	}
+	return field.String()
+}
+
+// fail records err against the node found at path, or the closest ancestor of it that exists
+func (v *indexDefinitionsValidator) fail(err error, path ...interface{}) {
+	node := v.root
+	for _, elem := range path {
+		switch elem := elem.(type) {
+		case string:
+			if node.Kind == yaml.MappingNode {
+				for i := 0; i+1 < len(node.Content); i += 2 {
+					if node.Content[i].Value == elem {
+						node = node.Content[i+1]
+						break
+					}
+				}
+			}
+		case int:
+			if node.Kind == yaml.SequenceNode && elem < len(node.Content) {
+				node = node.Content[elem]
+			}
+		}
+	}
+	v.errs = append(v.errs, &IndexDefinitionError{Line: node.Line, Column: node.Column, Field: fieldPath(path), Err: err})
+}
+
+func (v *indexDefinitionsValidator) validate(raw rawIndexDefinitions) *IndexDefinitions {
+	defs := &IndexDefinitions{Version: raw.Version}
+	if raw.Version != IndexDefinitionsVersion {
+		v.fail(fmt.Errorf("unsupported version %d, expected %d", raw.Version, IndexDefinitionsVersion), "version")
+	}
+
+	buckets := make(map[string]struct{}, len(raw.Buckets))
+	for bi, rb := range raw.Buckets {
+		if rb.Name == "" {
+			v.fail(errors.New("bucket name is required"), "buckets", bi, "name")
+		} else if _, ok := buckets[rb.Name]; ok {
+			v.fail(fmt.Errorf("bucket %q defined more than once", rb.Name), "buckets", bi, "name")
+		}
+		buckets[rb.Name] = struct{}{}
+
+		bucket := BucketIndexDefinitions{Name: rb.Name}
+		scopes := make(map[string]struct{}, len(rb.Scopes))
+		for si, rs := range rb.Scopes {
+			if rs.Name == "" {
+				v.fail(errors.New("scope name is required"), "buckets", bi, "scopes", si, "name")
+			} else if _, ok := scopes[rs.Name]; ok {
+				v.fail(fmt.Errorf("scope %q defined more than once", rs.Name), "buckets", bi, "scopes", si, "name")
+			}
+			scopes[rs.Name] = struct{}{}
+
+			collections := make(map[string]struct{}, len(rs.Collections))
+			for ci, rc := range rs.Collections {
+				if rc.Name == "" {
+					v.fail(errors.New("collection name is required"), "buckets", bi, "scopes", si, "collections", ci, "name")
+				} else if _, ok := collections[rc.Name]; ok {
+					v.fail(fmt.Errorf("collection %q defined more than once", rc.Name), "buckets", bi, "scopes", si, "collections", ci, "name")
+				}
+				collections[rc.Name] = struct{}{}
+
+				names := make(map[string]struct{}, len(rc.Indexes))
+				for ii, ri := range rc.Indexes {
+					spec := IndexSpec{
+						Name:           ri.Name,
+						ScopeName:      rs.Name,
+						CollectionName: rc.Name,
+						Primary:        ri.Primary,
+						Keys:           ri.Fields,
+						Condition:      ri.Where,
+						Partition:      ri.Partition,
+						NumReplicas:    ri.NumReplica,
+						Deferred:       ri.Deferred,
+					}
+					path := []interface{}{"buckets", bi, "scopes", si, "collections", ci, "indexes", ii}
+					v.validateIndex(spec, path)
+					if _, ok := names[spec.indexName()]; ok {
+						v.fail(fmt.Errorf("index %q defined more than once", spec.indexName()), append(path, "name")...)
+					}
+					names[spec.indexName()] = struct{}{}
+					bucket.Indexes = append(bucket.Indexes, spec)
+				}
+			}
+		}
+		defs.Buckets = append(defs.Buckets, bucket)
+	}
+	return defs
+}
+
+// indexDefinitionFields maps the fields of IndexSpec to the keys of an index definition
+var indexDefinitionFields = map[string]string{
+	"Name":        "name",
+	"Keys":        "fields",
+	"Condition":   "where",
+	"NumReplicas": "num_replica",
+}
+
+func (v *indexDefinitionsValidator) validateIndex(spec IndexSpec, path []interface{}) {
+	if field, err := spec.validate(); err != nil {
+		v.fail(err, append(path[:len(path):len(path)], indexDefinitionFields[field])...)
+	}
+}
+
+// ReconcileIndexDefinitions reconciles the indexes of every bucket within defs, returning one plan per bucket.
+func (c *Cluster) ReconcileIndexDefinitions(defs *IndexDefinitions, opts *ReconcileOptions) ([]*IndexPlan, error) {
+	qm := c.TryQueryIndexes()
+	plans := make([]*IndexPlan, 0, len(defs.Buckets))
+	for _, bucket := range defs.Buckets {
+		plan, err := qm.Reconcile(bucket.Name, bucket.Indexes, opts)
+		if plan != nil {
+			plans = append(plans, plan)
+		}
+		if err != nil {
+			return plans, fmt.Errorf("error reconciling indexes for bucket %q: %w", bucket.Name, err)
+		}
+	}
+	return plans, nil
+}
@@ -0,0 +1,246 @@
+package pail_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/myENA/pail/v2"
+)
+
+const indexDefinitionsYAML = `version: 1
+buckets:
+  - name: app
+    scopes:
+      - name: tenant
+        collections:
+          - name: users
+            indexes:
+              - primary: true
+              - name: idx_users_email
+                fields: [email]
+                where: "active = true"
+                num_replica: 1
+              - name: idx_users_name
+                fields:
+                  - last
+                  - first
+                deferred: true
+  - name: audit
+    scopes:
+      - name: _default
+        collections:
+          - name: _default
+            indexes:
+              - name: idx_at
+                fields: [at]
+                partition: HASH(meta().id)
+`
+
+const indexDefinitionsJSON = `{
+  "version": 1,
+  "buckets": [
+    {
+      "name": "app",
+      "scopes": [{
+        "name": "tenant",
+        "collections": [{
+          "name": "users",
+          "indexes": [
+            {"primary": true},
+            {"name": "idx_users_email", "fields": ["email"], "where": "active = true", "num_replica": 1},
+            {"name": "idx_users_name", "fields": ["last", "first"], "deferred": true}
+          ]
+        }]
+      }]
+    },
+    {
+      "name": "audit",
+      "scopes": [{
+        "name": "_default",
+        "collections": [{
+          "name": "_default",
+          "indexes": [{"name": "idx_at", "fields": ["at"], "partition": "HASH(meta().id)"}]
+        }]
+      }]
+    }
+  ]
+}`
+
+func TestLoadIndexDefinitions(t *testing.T) {
+	want := &pail.IndexDefinitions{
+		Version: 1,
+		Buckets: []pail.BucketIndexDefinitions{
+			{
+				Name: "app",
+				Indexes: []pail.IndexSpec{
+					{ScopeName: "tenant", CollectionName: "users", Primary: true},
+					{Name: "idx_users_email", ScopeName: "tenant", CollectionName: "users", Keys: []string{"email"}, Condition: "active = true", NumReplicas: 1},
+					{Name: "idx_users_name", ScopeName: "tenant", CollectionName: "users", Keys: []string{"last", "first"}, Deferred: true},
+				},
+			},
+			{
+				Name: "audit",
+				Indexes: []pail.IndexSpec{
+					{Name: "idx_at", ScopeName: "_default", CollectionName: "_default", Keys: []string{"at"}, Partition: "HASH(meta().id)"},
+				},
+			},
+		},
+	}
+	for name, doc := range map[string]string{"yaml": indexDefinitionsYAML, "json": indexDefinitionsJSON} {
+		t.Run(name, func(t *testing.T) {
+			defs, err := pail.LoadIndexDefinitions(strings.NewReader(doc))
+			if err != nil {
+				t.Fatalf("unexpected load error: %v", err)
+			}
+			if !reflect.DeepEqual(defs, want) {
+				t.Fatalf("expected %+v, got %+v", want, defs)
+			}
+		})
+	}
+}
+
+func TestLoadIndexDefinitionsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "indexes.yaml")
+	if err := os.WriteFile(path, []byte("version: 2\nbuckets: []\n"), 0o644); err != nil {
+		t.Fatalf("error writing definitions: %v", err)
+	}
+	_, err := pail.LoadIndexDefinitionsFile(path)
+	var defErr *pail.IndexDefinitionError
+	if !errors.As(err, &defErr) || !strings.HasPrefix(err.Error(), path+": ") {
+		t.Fatalf("expected an IndexDefinitionError prefixed with the path, got %v", err)
+	}
+	if defErr.Field != "version" || defErr.Line != 1 {
+		t.Fatalf("expected the version on line 1 to be at fault, got %+v", defErr)
+	}
+}
+
+// definitionError locates a single expected problem
+type definitionError struct {
+	line   int
+	column int
+	field  string
+	msg    string
+}
+
+func TestLoadIndexDefinitionsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want []definitionError
+	}{
+		{
+			name: "unsupported version",
+			doc:  "version: 3\nbuckets: []\n",
+			want: []definitionError{{1, 10, "version", "unsupported version 3, expected 1"}},
+		},
+		{
+			name: "validation",
+			doc: `version: 1
+buckets:
+  - name: app
+    scopes:
+      - name: s
+        collections:
+          - name: c
+            indexes:
+              - name: idx_a
+              - primary: true
+                fields: [a]
+              - name: idx_b
+                fields: [b]
+                num_replica: -1
+          - name: c
+  - scopes: []
+`,
+			want: []definitionError{
+				{9, 17, "buckets[0].scopes[0].collections[0].indexes[0].fields", `index "idx_a" must specify at least one key`},
+				{11, 25, "buckets[0].scopes[0].collections[0].indexes[1].fields", `primary index "#primary" may not specify keys`},
+				{14, 30, "buckets[0].scopes[0].collections[0].indexes[2].num_replica", `index "idx_b" may not specify a negative replica count`},
+				{15, 19, "buckets[0].scopes[0].collections[1].name", `collection "c" defined more than once`},
+				{16, 5, "buckets[1].name", "bucket name is required"},
+			},
+		},
+		{
+			name: "duplicate index",
+			doc:  "version: 1\nbuckets:\n  - name: app\n    scopes:\n      - name: s\n        collections:\n          - name: c\n            indexes:\n              - primary: true\n              - primary: true\n",
+			want: []definitionError{{10, 17, "buckets[0].scopes[0].collections[0].indexes[1].name", `index "#primary" defined more than once`}},
+		},
+		{
+			name: "yaml type mismatch",
+			doc: `version: 1
+buckets:
+  - name: app
+    scopes:
+      - name: s
+        collections:
+          - name: c
+            indexes:
+              - name: idx_a
+                fields: [a]
+                num_replica: many
+                deferred: sometimes
+`,
+			want: []definitionError{
+				{11, 30, "buckets[0].scopes[0].collections[0].indexes[0].num_replica", "cannot unmarshal !!str many into int"},
+				{12, 27, "buckets[0].scopes[0].collections[0].indexes[0].deferred", "cannot unmarshal !!str sometimes into bool"},
+			},
+		},
+		{
+			name: "yaml unknown field",
+			doc:  "version: 1\nbuckets:\n  - name: app\n    scope: []\n",
+			want: []definitionError{{4, 5, "buckets[0].scope", "unknown field"}},
+		},
+		{
+			name: "json type mismatch",
+			doc:  "{\n  \"version\": 1,\n  \"buckets\": [\n    {\"name\": \"app\", \"scopes\": {\"name\": \"s\"}}\n  ]\n}\n",
+			want: []definitionError{{4, 21, "buckets[0].scopes", "cannot unmarshal !!map into a list"}},
+		},
+		{
+			name: "yaml syntax",
+			doc:  "version: 1\nbuckets:\n  - name: app\n   scopes: []\n",
+			want: []definitionError{{2, 0, "", "did not find expected '-' indicator"}},
+		},
+		{
+			name: "json syntax",
+			doc:  "{\n  \"version\": 1,\n  \"buckets\": [\n}\n",
+			want: []definitionError{{3, 0, "", "did not find expected node content"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := pail.LoadIndexDefinitions(strings.NewReader(tt.doc))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			var got []definitionError
+			for _, e := range unjoin(err) {
+				var defErr *pail.IndexDefinitionError
+				if !errors.As(e, &defErr) {
+					t.Fatalf("expected every error to be an IndexDefinitionError, got %T: %v", e, e)
+				}
+				got = append(got, definitionError{defErr.Line, defErr.Column, defErr.Field, defErr.Err.Error()})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected errors:\n%+v\ngot:\n%+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestLoadIndexDefinitionsEmpty(t *testing.T) {
+	if _, err := pail.LoadIndexDefinitions(strings.NewReader("")); err == nil {
+		t.Fatal("expected an empty document to be refused")
+	}
+}
+
+// unjoin returns the errors joined within err, or err alone
+func unjoin(err error) []error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+	return []error{err}
+}
@@ -0,0 +1,519 @@
+package pail
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+const (
+	primaryIndexName = "#primary"
+
+	defaultReconcileWatchTimeout = 5 * time.Minute
+)
+
+// ErrReconcileRequiresCluster is returned by QueryIndexManager.Reconcile when the manager was not obtained from
+// Cluster.TryQueryIndexes and the reconciliation needs N1QL gocb's index manager has no equivalent for: creating
+// indexes with conditions, partitions or key expressions, or building some of a collection's deferred indexes while
+// leaving others unbuilt.
+var ErrReconcileRequiresCluster = errors.New("index reconciliation requires a QueryIndexManager obtained from Cluster.TryQueryIndexes")
+
+// IndexSpec describes a desired N1QL index.  Keys, Condition and Partition are N1QL expressions and are compared
+// against existing index definitions ignoring backticks, whitespace and case.  Keys which are plain field names or
+// dotted paths are escaped automatically.  Deferred indexes are created but left for the caller to build.
+type IndexSpec struct {
+	Name           string
+	ScopeName      string
+	CollectionName string
+	Primary        bool
+	Keys           []string
+	Condition      string
+	Partition      string
+	NumReplicas    int
+	Deferred       bool
+}
+
+func (s IndexSpec) scopeName() string {
+	if s.ScopeName == "" {
+		return defaultThingName
+	}
+	return s.ScopeName
+}
+
+func (s IndexSpec) collectionName() string {
+	if s.CollectionName == "" {
+		return defaultThingName
+	}
+	return s.CollectionName
+}
+
+func (s IndexSpec) indexName() string {
+	if s.Primary && s.Name == "" {
+		return primaryIndexName
+	}
+	return s.Name
+}
+
+// Validate returns an error if the spec is not complete enough to be created.
+func (s IndexSpec) Validate() error {
+	_, err := s.validate()
+	return err
+}
+
+// validate is Validate, also returning the name of the field found to be invalid
+func (s IndexSpec) validate() (string, error) {
+	if s.Primary {
+		if len(s.Keys) > 0 {
+			return "Keys", fmt.Errorf("primary index %q may not specify keys", s.indexName())
+		}
+		if s.Condition != "" {
+			return "Condition", fmt.Errorf("primary index %q may not specify a condition", s.indexName())
+		}
+	} else {
+		if s.Name == "" {
+			return "Name", errors.New("index name is required")
+		}
+		if len(s.Keys) == 0 {
+			return "Keys", fmt.Errorf("index %q must specify at least one key", s.Name)
+		}
+		for _, key := range s.Keys {
+			if strings.TrimSpace(key) == "" {
+				return "Keys", fmt.Errorf("index %q may not specify an empty key", s.Name)
+			}
+		}
+	}
+	if s.NumReplicas < 0 {
+		return "NumReplicas", fmt.Errorf("index %q may not specify a negative replica count", s.indexName())
+	}
+	return "", nil
+}
+
+// CreateStatement returns the N1QL statement used to create this index, deferring its build if deferred is true.
+func (s IndexSpec) CreateStatement(bucketName string, deferred bool) string {
+	var b strings.Builder
+	if s.Primary {
+		b.WriteString("CREATE PRIMARY INDEX")
+		if name := s.indexName(); name != primaryIndexName {
+			b.WriteString(" `" + name + "`")
+		}
+		b.WriteString(" ON " + s.keyspace(bucketName))
+	} else {
+		b.WriteString("CREATE INDEX `" + s.Name + "` ON " + s.keyspace(bucketName))
+		keys := make([]string, len(s.Keys))
+		for i, key := range s.Keys {
+			keys[i] = quoteIndexKey(key)
+		}
+		b.WriteString("(" + strings.Join(keys, ", ") + ")")
+	}
+	if s.Partition != "" {
+		b.WriteString(" PARTITION BY " + s.Partition)
+	}
+	if s.Condition != "" {
+		b.WriteString(" WHERE " + s.Condition)
+	}
+	var with []string
+	if deferred {
+		with = append(with, `"defer_build":true`)
+	}
+	if s.NumReplicas > 0 {
+		with = append(with, `"num_replica":`+strconv.Itoa(s.NumReplicas))
+	}
+	if len(with) > 0 {
+		b.WriteString(" WITH {" + strings.Join(with, ",") + "}")
+	}
+	return b.String()
+}
+
+func (s IndexSpec) keyspace(bucketName string) string {
+	return fmt.Sprintf("`%s`.`%s`.`%s`", bucketName, s.scopeName(), s.collectionName())
+}
+
+// matches returns true if the existing index has the same definition as this spec
+func (s IndexSpec) matches(idx gocb.QueryIndex) bool {
+	if s.Primary != idx.IsPrimary || len(s.Keys) != len(idx.IndexKey) {
+		return false
+	}
+	for i := range s.Keys {
+		if !sameIndexExpr(s.Keys[i], idx.IndexKey[i]) {
+			return false
+		}
+	}
+	return sameIndexExpr(s.Condition, idx.Condition) && sameIndexExpr(s.Partition, idx.Partition)
+}
+
+// quoteIndexKey escapes key if it is a plain field name or dotted path, leaving any other expression untouched
+func quoteIndexKey(key string) string {
+	parts := strings.Split(key, ".")
+	for _, part := range parts {
+		if !isIdentifier(part) {
+			return key
+		}
+	}
+	return "`" + strings.Join(parts, "`.`") + "`"
+}
+
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case i > 0 && r >= '0' && r <= '9':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func normalizeIndexExpr(expr string) string {
+	expr = strings.Map(func(r rune) rune {
+		switch r {
+		case '`', ' ', '\t', '\r', '\n':
+			return -1
+		}
+		return r
+	}, expr)
+	// the query service wraps conditions in parentheses
+	for len(expr) > 1 && expr[0] == '(' && expr[len(expr)-1] == ')' {
+		expr = expr[1 : len(expr)-1]
+	}
+	return expr
+}
+
+func sameIndexExpr(a, b string) bool {
+	return strings.EqualFold(normalizeIndexExpr(a), normalizeIndexExpr(b))
+}
+
+type indexKey struct {
+	scope      string
+	collection string
+	name       string
+}
+
+func queryIndexKey(idx gocb.QueryIndex) indexKey {
+	k := indexKey{scope: idx.ScopeName, collection: idx.CollectionName, name: idx.Name}
+	if k.scope == "" {
+		k.scope = defaultThingName
+	}
+	if k.collection == "" {
+		k.collection = defaultThingName
+	}
+	return k
+}
+
+func (s IndexSpec) key() indexKey {
+	return indexKey{scope: s.scopeName(), collection: s.collectionName(), name: s.indexName()}
+}
+
+type IndexAction string
+
+const (
+	IndexActionKeep      IndexAction = "keep"
+	IndexActionCreate    IndexAction = "create"
+	IndexActionRecreate  IndexAction = "recreate"
+	IndexActionBuild     IndexAction = "build"
+	IndexActionDrop      IndexAction = "drop"
+	IndexActionUnmanaged IndexAction = "unmanaged"
+)
+
+// IndexPlanStep is a single action within an IndexPlan.  Spec is empty for drop and unmanaged steps, and Existing is
+// nil for create steps.
+type IndexPlanStep struct {
+	Action   IndexAction
+	Spec     IndexSpec
+	Existing *gocb.QueryIndex
+}
+
+func (st IndexPlanStep) scopeCollectionName() (string, string, string) {
+	if st.Existing != nil {
+		k := queryIndexKey(*st.Existing)
+		return k.scope, k.collection, k.name
+	}
+	return st.Spec.scopeName(), st.Spec.collectionName(), st.Spec.indexName()
+}
+
+// IndexPlan is the set of changes Reconcile will make, or has made, to bring a bucket's indexes in line with the
+// desired specs.
+type IndexPlan struct {
+	BucketName string
+	Steps      []IndexPlanStep
+}
+
+// Changed returns true if the plan contains any create, recreate, build or drop steps.
+func (p *IndexPlan) Changed() bool {
+	for _, st := range p.Steps {
+		switch st.Action {
+		case IndexActionCreate, IndexActionRecreate, IndexActionBuild, IndexActionDrop:
+			return true
+		}
+	}
+	return false
+}
+
+// String renders the plan one step per line, suitable for printing as a dry run.
+func (p *IndexPlan) String() string {
+	var b strings.Builder
+	for _, st := range p.Steps {
+		scope, collection, name := st.scopeCollectionName()
+		fmt.Fprintf(&b, "%-9s `%s`.`%s`.`%s`.`%s`", st.Action, p.BucketName, scope, collection, name)
+		switch st.Action {
+		case IndexActionCreate, IndexActionRecreate:
+			b.WriteString(": " + st.Spec.CreateStatement(p.BucketName, false))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+type ReconcileOptions struct {
+	// DropUnknown will drop every index not described by a spec from the collections the specs name.  Indexes of
+	// collections no spec names are left alone.
+	DropUnknown bool
+	// DryRun returns the plan without applying it.
+	DryRun bool
+	// WatchTimeout is how long to wait for created indexes to come online, defaulting to 5 minutes.
+	WatchTimeout time.Duration
+}
+
+// Plan diffs the desired specs against the indexes that currently exist within the bucket.  Existing indexes which
+// match a spec not marked Deferred but have yet to be built are planned to be built.  With dropUnknown, indexes not
+// described by a spec are planned to be dropped, though only from the collections the specs name.
+func (qm *QueryIndexManager) Plan(bucketName string, specs []IndexSpec, dropUnknown bool) (*IndexPlan, error) {
+	return qm.reconciler().plan(bucketName, specs, dropUnknown)
+}
+
+// Reconcile brings the bucket's indexes in line with the provided specs.  Missing indexes are created deferred,
+// indexes whose definitions differ are dropped and re-created, and, if requested, indexes of the specs' collections
+// not described by any spec are dropped.  Indexes not marked Deferred are then built, including any left unbuilt by
+// an earlier run, and waited on, so that a partially applied reconciliation may be completed by running it again.
+// The returned plan describes what was, or with DryRun would have been, done.
+func (qm *QueryIndexManager) Reconcile(bucketName string, specs []IndexSpec, opts *ReconcileOptions) (*IndexPlan, error) {
+	return qm.reconciler().reconcile(bucketName, specs, opts)
+}
+
+// indexReconciler plans and applies index changes through an IndexManager, passing the index statements gocb's index
+// manager has no equivalent for to query
+type indexReconciler struct {
+	im    IndexManager
+	query func(statement string) error
+}
+
+func (qm *QueryIndexManager) reconciler() indexReconciler {
+	return indexReconciler{im: qm, query: qm.query}
+}
+
+func (r indexReconciler) plan(bucketName string, specs []IndexSpec, dropUnknown bool) (*IndexPlan, error) {
+	type keyspace struct{ scope, collection string }
+	var (
+		seen      = make(map[indexKey]struct{}, len(specs))
+		keyspaces = make(map[keyspace]struct{})
+	)
+	for _, spec := range specs {
+		keyspaces[keyspace{spec.scopeName(), spec.collectionName()}] = struct{}{}
+		if err := spec.Validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := seen[spec.key()]; ok {
+			return nil, fmt.Errorf("index %q specified more than once for `%s`.`%s`", spec.indexName(), spec.scopeName(), spec.collectionName())
+		}
+		seen[spec.key()] = struct{}{}
+	}
+
+	existing, err := r.im.TryGetAllIndexes(bucketName, nil)
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[indexKey]gocb.QueryIndex, len(existing))
+	for _, idx := range existing {
+		current[queryIndexKey(idx)] = idx
+	}
+
+	plan := &IndexPlan{BucketName: bucketName}
+	for _, spec := range specs {
+		idx, ok := current[spec.key()]
+		if !ok {
+			plan.Steps = append(plan.Steps, IndexPlanStep{Action: IndexActionCreate, Spec: spec})
+			continue
+		}
+		step := IndexPlanStep{Action: IndexActionKeep, Spec: spec, Existing: &idx}
+		if !spec.matches(idx) {
+			step.Action = IndexActionRecreate
+		} else if !spec.Deferred && unbuilt(idx) {
+			step.Action = IndexActionBuild
+		}
+		plan.Steps = append(plan.Steps, step)
+	}
+	for _, idx := range existing {
+		if _, ok := seen[queryIndexKey(idx)]; ok {
+			continue
+		}
+		step := IndexPlanStep{Action: IndexActionUnmanaged, Existing: &idx}
+		k := queryIndexKey(idx)
+		if _, named := keyspaces[keyspace{k.scope, k.collection}]; dropUnknown && named {
+			step.Action = IndexActionDrop
+		}
+		plan.Steps = append(plan.Steps, step)
+	}
+	return plan, nil
+}
+
+func (r indexReconciler) reconcile(bucketName string, specs []IndexSpec, opts *ReconcileOptions) (*IndexPlan, error) {
+	if opts == nil {
+		opts = new(ReconcileOptions)
+	}
+	plan, err := r.plan(bucketName, specs, opts.DropUnknown)
+	if err != nil || opts.DryRun || !plan.Changed() {
+		return plan, err
+	}
+
+	type keyspace struct{ scope, collection string }
+	var (
+		order []keyspace
+		build = make(map[keyspace][]string)
+	)
+	for _, st := range plan.Steps {
+		scope, collection, name := st.scopeCollectionName()
+		ks := keyspace{scope, collection}
+		switch st.Action {
+		case IndexActionDrop, IndexActionRecreate:
+			if st.Existing.IsPrimary {
+				err = r.im.TryDropPrimaryIndex(bucketName, &gocb.DropPrimaryQueryIndexOptions{
+					CustomName:     dropPrimaryName(name),
+					ScopeName:      scope,
+					CollectionName: collection,
+				})
+			} else {
+				err = r.im.TryDropIndex(bucketName, name, &gocb.DropQueryIndexOptions{ScopeName: scope, CollectionName: collection})
+			}
+			if err != nil {
+				return plan, fmt.Errorf("error dropping index %q: %w", name, err)
+			}
+		}
+		switch st.Action {
+		case IndexActionCreate, IndexActionRecreate:
+			if err = r.createIndex(bucketName, st.Spec); err != nil {
+				return plan, fmt.Errorf("error creating index %q: %w", name, err)
+			}
+		}
+		switch st.Action {
+		case IndexActionCreate, IndexActionRecreate, IndexActionBuild:
+			if st.Spec.Deferred {
+				continue
+			}
+			if _, ok := build[ks]; !ok {
+				order = append(order, ks)
+			}
+			build[ks] = append(build[ks], name)
+		}
+	}
+
+	timeout := opts.WatchTimeout
+	if timeout <= 0 {
+		timeout = defaultReconcileWatchTimeout
+	}
+	for _, ks := range order {
+		names := build[ks]
+		if plan.leavesDeferred(ks.scope, ks.collection) {
+			err = r.query(buildStatement(bucketName, ks.scope, ks.collection, names))
+		} else {
+			_, err = r.im.TryBuildDeferredIndexes(bucketName, &gocb.BuildDeferredQueryIndexOptions{ScopeName: ks.scope, CollectionName: ks.collection})
+		}
+		if err != nil {
+			return plan, fmt.Errorf("error building indexes %v: %w", names, err)
+		}
+		err = r.im.TryWatchIndexes(bucketName, names, timeout, &gocb.WatchQueryIndexOptions{ScopeName: ks.scope, CollectionName: ks.collection})
+		if err != nil {
+			return plan, fmt.Errorf("error waiting on indexes %v: %w", names, err)
+		}
+	}
+	return plan, nil
+}
+
+// createIndex creates spec deferred, through gocb's index manager unless the spec uses a condition, partition or key
+// expression, which only N1QL can express
+func (r indexReconciler) createIndex(bucketName string, spec IndexSpec) error {
+	if spec.Condition != "" || spec.Partition != "" {
+		return r.query(spec.CreateStatement(bucketName, true))
+	}
+	if spec.Primary {
+		return r.im.TryCreatePrimaryIndex(bucketName, &gocb.CreatePrimaryQueryIndexOptions{
+			Deferred:       true,
+			CustomName:     dropPrimaryName(spec.indexName()),
+			NumReplicas:    spec.NumReplicas,
+			ScopeName:      spec.scopeName(),
+			CollectionName: spec.collectionName(),
+		})
+	}
+	for _, key := range spec.Keys {
+		// gocb escapes each field as a whole, so anything but a plain field name must go through N1QL
+		if !isIdentifier(key) {
+			return r.query(spec.CreateStatement(bucketName, true))
+		}
+	}
+	return r.im.TryCreateIndex(bucketName, spec.Name, spec.Keys, &gocb.CreateQueryIndexOptions{
+		Deferred:       true,
+		NumReplicas:    spec.NumReplicas,
+		ScopeName:      spec.scopeName(),
+		CollectionName: spec.collectionName(),
+	})
+}
+
+// query executes an index statement gocb's index manager has no equivalent for, under the index management policy
+func (qm *QueryIndexManager) query(statement string) error {
+	if qm.cluster == nil {
+		return ErrReconcileRequiresCluster
+	}
+	_, err := qm.cluster.TryQuery(statement, &gocb.QueryOptions{RetryStrategy: qm.OperationPolicy(OperationIndexManagement)})
+	return err
+}
+
+func buildStatement(bucketName, scope, collection string, names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = "`" + name + "`"
+	}
+	return fmt.Sprintf("BUILD INDEX ON `%s`.`%s`.`%s`(%s)", bucketName, scope, collection, strings.Join(quoted, ", "))
+}
+
+// leavesDeferred returns true if, once the plan's creates are done, the collection will hold deferred indexes that
+// are to remain unbuilt, in which case gocb's build of every deferred index in the collection may not be used
+func (p *IndexPlan) leavesDeferred(scope, collection string) bool {
+	for _, st := range p.Steps {
+		if s, c, _ := st.scopeCollectionName(); s != scope || c != collection {
+			continue
+		}
+		switch st.Action {
+		case IndexActionCreate, IndexActionRecreate:
+			if st.Spec.Deferred {
+				return true
+			}
+		case IndexActionKeep, IndexActionUnmanaged:
+			if st.Existing.State == "deferred" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unbuilt returns true if idx has been created but not yet built, as when created deferred
+func unbuilt(idx gocb.QueryIndex) bool {
+	switch idx.State {
+	case "deferred", "created":
+		return true
+	}
+	return false
+}
+
+func dropPrimaryName(name string) string {
+	if name == primaryIndexName {
+		return ""
+	}
+	return name
+}
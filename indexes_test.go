@@ -0,0 +1,303 @@
+package pail
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// fakeIndexManager keeps a bucket's indexes in memory, recording every change made to them
+type fakeIndexManager struct {
+	indexes []gocb.QueryIndex
+	calls   []string
+}
+
+func (m *fakeIndexManager) record(format string, args ...interface{}) {
+	m.calls = append(m.calls, fmt.Sprintf(format, args...))
+}
+
+func (m *fakeIndexManager) add(idx gocb.QueryIndex, deferred bool) {
+	idx.State = "online"
+	if deferred {
+		idx.State = "deferred"
+	}
+	m.indexes = append(m.indexes, idx)
+}
+
+func (m *fakeIndexManager) remove(scope, collection, name string) {
+	for i, idx := range m.indexes {
+		if k := queryIndexKey(idx); k == (indexKey{scope, collection, name}) {
+			m.indexes = append(m.indexes[:i], m.indexes[i+1:]...)
+			return
+		}
+	}
+}
+
+func (m *fakeIndexManager) TryCreateIndex(_, indexName string, fields []string, opts *gocb.CreateQueryIndexOptions) error {
+	m.record("create %s.%s.%s deferred=%t", opts.ScopeName, opts.CollectionName, indexName, opts.Deferred)
+	m.add(gocb.QueryIndex{Name: indexName, ScopeName: opts.ScopeName, CollectionName: opts.CollectionName, IndexKey: fields}, opts.Deferred)
+	return nil
+}
+
+func (m *fakeIndexManager) TryCreatePrimaryIndex(_ string, opts *gocb.CreatePrimaryQueryIndexOptions) error {
+	name := opts.CustomName
+	if name == "" {
+		name = primaryIndexName
+	}
+	m.record("create primary %s.%s.%s deferred=%t", opts.ScopeName, opts.CollectionName, name, opts.Deferred)
+	m.add(gocb.QueryIndex{Name: name, IsPrimary: true, ScopeName: opts.ScopeName, CollectionName: opts.CollectionName}, opts.Deferred)
+	return nil
+}
+
+func (m *fakeIndexManager) TryDropIndex(_, indexName string, opts *gocb.DropQueryIndexOptions) error {
+	m.record("drop %s.%s.%s", opts.ScopeName, opts.CollectionName, indexName)
+	m.remove(opts.ScopeName, opts.CollectionName, indexName)
+	return nil
+}
+
+func (m *fakeIndexManager) TryDropPrimaryIndex(_ string, opts *gocb.DropPrimaryQueryIndexOptions) error {
+	name := opts.CustomName
+	if name == "" {
+		name = primaryIndexName
+	}
+	m.record("drop primary %s.%s.%s", opts.ScopeName, opts.CollectionName, name)
+	m.remove(opts.ScopeName, opts.CollectionName, name)
+	return nil
+}
+
+func (m *fakeIndexManager) TryGetAllIndexes(string, *gocb.GetAllQueryIndexesOptions) ([]gocb.QueryIndex, error) {
+	return append([]gocb.QueryIndex(nil), m.indexes...), nil
+}
+
+func (m *fakeIndexManager) TryBuildDeferredIndexes(_ string, opts *gocb.BuildDeferredQueryIndexOptions) ([]string, error) {
+	m.record("build %s.%s", opts.ScopeName, opts.CollectionName)
+	var built []string
+	for i, idx := range m.indexes {
+		if k := queryIndexKey(idx); k.scope == opts.ScopeName && k.collection == opts.CollectionName && unbuilt(idx) {
+			m.indexes[i].State = "online"
+			built = append(built, idx.Name)
+		}
+	}
+	return built, nil
+}
+
+func (m *fakeIndexManager) TryWatchIndexes(_ string, indexNames []string, _ time.Duration, opts *gocb.WatchQueryIndexOptions) error {
+	m.record("watch %s.%s %s", opts.ScopeName, opts.CollectionName, strings.Join(indexNames, ","))
+	return nil
+}
+
+func (m *fakeIndexManager) TryBuildAndWaitDeferredIndexes(string, time.Duration, *gocb.BuildDeferredQueryIndexOptions, *gocb.WatchQueryIndexOptions) ([]string, error) {
+	return nil, errors.New("not used by reconciliation")
+}
+
+// reconciler returns a reconciler over m which records any N1QL it is asked to run
+func (m *fakeIndexManager) reconciler() indexReconciler {
+	return indexReconciler{im: m, query: func(statement string) error {
+		m.record("query %s", statement)
+		return nil
+	}}
+}
+
+func index(scope, collection, name, state string, keys ...string) gocb.QueryIndex {
+	return gocb.QueryIndex{Name: name, ScopeName: scope, CollectionName: collection, State: state, IndexKey: keys}
+}
+
+func planActions(plan *IndexPlan) map[string]IndexAction {
+	actions := make(map[string]IndexAction, len(plan.Steps))
+	for _, st := range plan.Steps {
+		scope, collection, name := st.scopeCollectionName()
+		actions[scope+"."+collection+"."+name] = st.Action
+	}
+	return actions
+}
+
+func TestIndexPlan(t *testing.T) {
+	m := &fakeIndexManager{indexes: []gocb.QueryIndex{
+		index("app", "users", "ix_name", "online", "`name`"),
+		index("app", "users", "ix_age", "online", "`age`"),
+		index("app", "users", "ix_email", "deferred", "`email`"),
+		index("app", "users", "ix_later", "deferred", "`later`"),
+		index("app", "users", "ix_stale", "online", "`stale`"),
+		index("app", "orders", "ix_total", "online", "`total`"),
+		{Name: primaryIndexName, IsPrimary: true, ScopeName: "app", CollectionName: "users", State: "online"},
+	}}
+	specs := []IndexSpec{
+		{Name: "ix_name", ScopeName: "app", CollectionName: "users", Keys: []string{"name"}},
+		{Name: "ix_age", ScopeName: "app", CollectionName: "users", Keys: []string{"age"}, Condition: "age > 0"},
+		{Name: "ix_email", ScopeName: "app", CollectionName: "users", Keys: []string{"email"}},
+		{Name: "ix_later", ScopeName: "app", CollectionName: "users", Keys: []string{"later"}, Deferred: true},
+		{Name: "ix_city", ScopeName: "app", CollectionName: "users", Keys: []string{"address.city"}},
+		{Primary: true, ScopeName: "app", CollectionName: "users"},
+	}
+
+	tests := []struct {
+		name        string
+		dropUnknown bool
+		want        map[string]IndexAction
+	}{
+		{
+			name: "keep unknown",
+			want: map[string]IndexAction{
+				"app.users.ix_name":   IndexActionKeep,
+				"app.users.ix_age":    IndexActionRecreate,
+				"app.users.ix_email":  IndexActionBuild,
+				"app.users.ix_later":  IndexActionKeep,
+				"app.users.ix_city":   IndexActionCreate,
+				"app.users.#primary":  IndexActionKeep,
+				"app.users.ix_stale":  IndexActionUnmanaged,
+				"app.orders.ix_total": IndexActionUnmanaged,
+			},
+		},
+		{
+			name:        "drop unknown",
+			dropUnknown: true,
+			want: map[string]IndexAction{
+				"app.users.ix_name":   IndexActionKeep,
+				"app.users.ix_age":    IndexActionRecreate,
+				"app.users.ix_email":  IndexActionBuild,
+				"app.users.ix_later":  IndexActionKeep,
+				"app.users.ix_city":   IndexActionCreate,
+				"app.users.#primary":  IndexActionKeep,
+				"app.users.ix_stale":  IndexActionDrop,
+				"app.orders.ix_total": IndexActionUnmanaged, // no spec names app.orders
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := m.reconciler().plan("bucket", specs, tt.dropUnknown)
+			if err != nil {
+				t.Fatalf("unexpected plan error: %v", err)
+			}
+			if got := planActions(plan); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected actions %v, got %v", tt.want, got)
+			}
+			if !plan.Changed() {
+				t.Fatal("expected the plan to report changes")
+			}
+		})
+	}
+	if len(m.calls) != 0 {
+		t.Fatalf("expected planning to make no changes, got %q", m.calls)
+	}
+}
+
+func TestIndexPlanInvalidSpecs(t *testing.T) {
+	tests := []struct {
+		name  string
+		specs []IndexSpec
+		want  string
+	}{
+		{name: "no keys", specs: []IndexSpec{{Name: "ix"}}, want: "at least one key"},
+		{name: "no name", specs: []IndexSpec{{Keys: []string{"a"}}}, want: "name is required"},
+		{
+			name:  "duplicate",
+			specs: []IndexSpec{{Name: "ix", Keys: []string{"a"}}, {Name: "ix", Keys: []string{"b"}}},
+			want:  "more than once",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := new(fakeIndexManager).reconciler().plan("bucket", tt.specs, false); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	m := &fakeIndexManager{indexes: []gocb.QueryIndex{
+		index("app", "users", "ix_age", "online", "`age`"),
+		index("app", "users", "ix_email", "deferred", "`email`"),
+		index("app", "users", "ix_stale", "online", "`stale`"),
+		index("app", "orders", "ix_total", "online", "`total`"),
+	}}
+	specs := []IndexSpec{
+		{Name: "ix_age", ScopeName: "app", CollectionName: "users", Keys: []string{"age", "name"}},
+		{Name: "ix_email", ScopeName: "app", CollectionName: "users", Keys: []string{"email"}},
+		{Name: "ix_name", ScopeName: "app", CollectionName: "users", Keys: []string{"name"}},
+		{Primary: true},
+	}
+
+	plan, err := m.reconciler().reconcile("bucket", specs, &ReconcileOptions{DropUnknown: true})
+	if err != nil {
+		t.Fatalf("unexpected reconcile error: %v", err)
+	}
+	want := []string{
+		"drop app.users.ix_age",
+		"create app.users.ix_age deferred=true",
+		"create app.users.ix_name deferred=true",
+		"create primary _default._default.#primary deferred=true",
+		"drop app.users.ix_stale",
+		// the index left unbuilt by an earlier run is built alongside those just created
+		"build app.users",
+		"watch app.users ix_age,ix_email,ix_name",
+		"build _default._default",
+		"watch _default._default #primary",
+	}
+	if !reflect.DeepEqual(m.calls, want) {
+		t.Fatalf("expected calls:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(m.calls, "\n"))
+	}
+	if got := planActions(plan)["app.orders.ix_total"]; got != IndexActionUnmanaged {
+		t.Fatalf("expected the index of an unnamed collection to be left alone, got %s", got)
+	}
+
+	// the indexes now match, so running again changes nothing
+	m.calls = nil
+	if plan, err = m.reconciler().reconcile("bucket", specs, &ReconcileOptions{DropUnknown: true}); err != nil {
+		t.Fatalf("unexpected reconcile error: %v", err)
+	}
+	if plan.Changed() || len(m.calls) != 0 {
+		t.Fatalf("expected a second reconcile to change nothing, got %q", m.calls)
+	}
+}
+
+func TestReconcileDryRun(t *testing.T) {
+	m := &fakeIndexManager{indexes: []gocb.QueryIndex{index("app", "users", "ix_stale", "online", "`stale`")}}
+	specs := []IndexSpec{{Name: "ix_name", ScopeName: "app", CollectionName: "users", Keys: []string{"name"}}}
+	plan, err := m.reconciler().reconcile("bucket", specs, &ReconcileOptions{DropUnknown: true, DryRun: true})
+	if err != nil {
+		t.Fatalf("unexpected reconcile error: %v", err)
+	}
+	if want := map[string]IndexAction{"app.users.ix_name": IndexActionCreate, "app.users.ix_stale": IndexActionDrop}; !reflect.DeepEqual(planActions(plan), want) {
+		t.Fatalf("expected actions %v, got %v", want, planActions(plan))
+	}
+	if len(m.calls) != 0 {
+		t.Fatalf("expected a dry run to make no changes, got %q", m.calls)
+	}
+}
+
+func TestReconcileN1QL(t *testing.T) {
+	m := &fakeIndexManager{indexes: []gocb.QueryIndex{index("app", "users", "ix_later", "deferred", "`later`")}}
+	specs := []IndexSpec{
+		{Name: "ix_later", ScopeName: "app", CollectionName: "users", Keys: []string{"later"}, Deferred: true},
+		{Name: "ix_adult", ScopeName: "app", CollectionName: "users", Keys: []string{"age"}, Condition: "age >= 18"},
+		{Name: "ix_lower", ScopeName: "app", CollectionName: "users", Keys: []string{"LOWER(name)"}},
+	}
+
+	if _, err := m.reconciler().reconcile("bucket", specs, nil); err != nil {
+		t.Fatalf("unexpected reconcile error: %v", err)
+	}
+	want := []string{
+		"query " + specs[1].CreateStatement("bucket", true),
+		"query " + specs[2].CreateStatement("bucket", true),
+		// ix_later must stay deferred, so only the new indexes may be built
+		"query BUILD INDEX ON `bucket`.`app`.`users`(`ix_adult`, `ix_lower`)",
+		"watch app.users ix_adult,ix_lower",
+	}
+	if !reflect.DeepEqual(m.calls, want) {
+		t.Fatalf("expected calls:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(m.calls, "\n"))
+	}
+
+	// without a cluster to run it on, N1QL is refused
+	r := (&fakeIndexManager{}).reconciler()
+	r.query = (&QueryIndexManager{}).query
+	if _, err := r.reconcile("bucket", specs[1:2], nil); !errors.Is(err, ErrReconcileRequiresCluster) {
+		t.Fatalf("expected ErrReconcileRequiresCluster, got %v", err)
+	}
+}
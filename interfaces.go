@@ -0,0 +1,69 @@
+package pail
+
+import (
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	cbsearch "github.com/couchbase/gocb/v2/search"
+)
+
+// KV is the key-value surface of a Collection.  Depending on KV rather than *Collection allows an alternative
+// implementation, such as an in-memory one, to be substituted in tests.
+type KV interface {
+	TryGet(id string, opts *gocb.GetOptions) (*gocb.GetResult, error)
+	TryGetContent(id string, ptr interface{}, opts *gocb.GetOptions) (*gocb.GetResult, error)
+	TryGetAndLock(id string, lockTime time.Duration, opts *gocb.GetAndLockOptions) (*gocb.GetResult, error)
+	TryUnlock(id string, cas gocb.Cas, opts *gocb.UnlockOptions) error
+	TryLookupIn(id string, ops []gocb.LookupInSpec, opts *gocb.LookupInOptions) (*gocb.LookupInResult, error)
+	TryTouch(id string, expiry time.Duration, opts *gocb.TouchOptions) (*gocb.MutationResult, error)
+	TryUpsert(id string, value interface{}, opts *gocb.UpsertOptions) (*gocb.MutationResult, error)
+	TryInsert(id string, value interface{}, opts *gocb.InsertOptions) (*gocb.MutationResult, error)
+	TryReplace(id string, value interface{}, opts *gocb.ReplaceOptions) (*gocb.MutationResult, error)
+	TryRemove(id string, opts *gocb.RemoveOptions) (*gocb.MutationResult, error)
+	TryIncrement(id string, opts *gocb.IncrementOptions) (*gocb.CounterResult, error)
+	TryDecrement(id string, opts *gocb.DecrementOptions) (*gocb.CounterResult, error)
+	TryAppend(id string, value []byte, opts *gocb.AppendOptions) (*gocb.MutationResult, error)
+	TryPrepend(id string, value []byte, opts *gocb.PrependOptions) (*gocb.MutationResult, error)
+}
+
+// Querier executes N1QL queries, as a Cluster does.
+type Querier interface {
+	TryQuery(statement string, opts *gocb.QueryOptions) (*gocb.QueryResult, error)
+}
+
+// Searcher executes full text search queries, as a Cluster does.
+type Searcher interface {
+	TrySearchQuery(indexName string, query cbsearch.Query, opts *gocb.SearchOptions) (*gocb.SearchResult, error)
+}
+
+// IndexManager is the surface of a QueryIndexManager.
+type IndexManager interface {
+	TryCreateIndex(bucketName, indexName string, fields []string, opts *gocb.CreateQueryIndexOptions) error
+	TryCreatePrimaryIndex(bucketName string, opts *gocb.CreatePrimaryQueryIndexOptions) error
+	TryDropIndex(bucketName, indexName string, opts *gocb.DropQueryIndexOptions) error
+	TryDropPrimaryIndex(bucketName string, opts *gocb.DropPrimaryQueryIndexOptions) error
+	TryGetAllIndexes(bucketName string, opts *gocb.GetAllQueryIndexesOptions) ([]gocb.QueryIndex, error)
+	TryBuildDeferredIndexes(bucketName string, opts *gocb.BuildDeferredQueryIndexOptions) ([]string, error)
+	TryWatchIndexes(bucketName string, indexNames []string, timeout time.Duration, opts *gocb.WatchQueryIndexOptions) error
+	TryBuildAndWaitDeferredIndexes(bucketName string, timeout time.Duration, buildOpts *gocb.BuildDeferredQueryIndexOptions, watchOpts *gocb.WatchQueryIndexOptions) ([]string, error)
+}
+
+// CollectionIndexManager is the surface of a CollectionQueryIndexManager.
+type CollectionIndexManager interface {
+	TryCreateIndex(indexName string, fields []string, opts *gocb.CreateQueryIndexOptions) error
+	TryCreatePrimaryIndex(opts *gocb.CreatePrimaryQueryIndexOptions) error
+	TryDropIndex(indexName string, opts *gocb.DropQueryIndexOptions) error
+	TryDropPrimaryIndex(opts *gocb.DropPrimaryQueryIndexOptions) error
+	TryGetAllIndexes(opts *gocb.GetAllQueryIndexesOptions) ([]gocb.QueryIndex, error)
+	TryBuildDeferredIndexes(opts *gocb.BuildDeferredQueryIndexOptions) ([]string, error)
+	TryWatchIndexes(indexNames []string, timeout time.Duration, opts *gocb.WatchQueryIndexOptions) error
+	TryBuildAndWaitDeferredIndexes(timeout time.Duration, buildOpts *gocb.BuildDeferredQueryIndexOptions, watchOpts *gocb.WatchQueryIndexOptions) ([]string, error)
+}
+
+var (
+	_ KV                     = (*Collection)(nil)
+	_ Querier                = (*Cluster)(nil)
+	_ Searcher               = (*Cluster)(nil)
+	_ IndexManager           = (*QueryIndexManager)(nil)
+	_ CollectionIndexManager = (*CollectionQueryIndexManager)(nil)
+)
@@ -1,6 +1,7 @@
 package pail
 
 import (
+	"sync/atomic"
 	"time"
 
 	"github.com/couchbase/gocb/v2"
@@ -12,16 +13,30 @@ const (
 )
 
 type commonRetryable struct {
-	retries uint32
-	delay   time.Duration
+	retries    uint32
+	delay      time.Duration
+	backoff    Backoff
+	classifier ErrorClassifier
+	hooks      RetryHooks
+	maxElapsed time.Duration
+	// policies holds a *map[OperationKind]RetryPolicy, replaced rather than modified so that it may be read while set
+	policies    atomic.Value
+	reasons     ReasonPolicies
+	adaptive    *adaptiveTimeouts
+	limiter     *RateLimiter
+	concurrency *ConcurrencyLimiter
+	clock       Clock
 }
 
-func Connect(connStr string, opts gocb.ClusterOptions, retries int, delay time.Duration) (*Cluster, error) {
-	cluster, err := gocb.Connect(connStr, opts)
-	if err != nil {
-		return nil, err
+// Connect connects to the cluster, retrying operations up to retries times with delay between each attempt.  Further
+// behaviour may be configured with connectOpts.  With WithWaitUntilReady, Connect waits on the cluster alone; buckets
+// are not waited on, so call Pail.TryWaitUntilReady on any bucket which must be ready before use.
+func Connect(connStr string, opts gocb.ClusterOptions, retries int, delay time.Duration, connectOpts ...ConnectOption) (*Cluster, error) {
+	cc := newConnectConfig(RetryPolicy{Retries: retries, Delay: delay})
+	for _, fn := range connectOpts {
+		fn(cc)
 	}
-	return NewCluster(cluster, retries, delay), nil
+	return cc.connect(connStr, opts)
 }
 
 type Cluster struct {
@@ -32,17 +47,28 @@ type Cluster struct {
 func NewCluster(cluster *gocb.Cluster, retries int, delay time.Duration) *Cluster {
 	c := new(Cluster)
 	c.Cluster = cluster
-	c.retries = uint32(retries)
+	c.retries = retryLimit(retries)
 	c.delay = delay
 	return c
 }
 
 func (c *Cluster) Bucket(bucketName string) *Pail {
-	return NewPail(c.Cluster.Bucket(bucketName), int(c.retries), c.delay)
+	p := NewPail(c.Cluster.Bucket(bucketName), int(c.retries), c.delay)
+	p.commonRetryable = c.commonRetryable
+	return p
 }
 
 func (c *Cluster) TryQueryIndexes() *QueryIndexManager {
-	return NewQueryIndexManager(c.Cluster.QueryIndexes(), int(c.retries), c.delay)
+	qm := NewQueryIndexManager(c.Cluster.QueryIndexes(), int(c.retries), c.delay)
+	qm.commonRetryable = c.commonRetryable
+	qm.cluster = c
+	return qm
+}
+
+func (c *Cluster) TryUsers() *UserManager {
+	um := NewUserManager(c.Cluster.Users(), int(c.retries), c.delay)
+	um.commonRetryable = c.commonRetryable
+	return um
 }
 
 func (c *Cluster) QueryOptions(in *gocb.QueryOptions, fn ClusterRetryFunc) (ClusterRetryContext, *gocb.QueryOptions) {
@@ -50,7 +76,12 @@ func (c *Cluster) QueryOptions(in *gocb.QueryOptions, fn ClusterRetryFunc) (Clus
 	if in != nil {
 		*out = *in
 	}
-	ctx := NewSimpleClusterRetryContext(c.retries, c.delay, out.RetryStrategy, fn)
+	policy, base := c.resolvePolicy(OperationQuery, out.RetryStrategy)
+	fn = adaptTimeout(c.adaptive, c.clock, OperationQuery, &out.Timeout, out.Context, fn)
+	fn = limitConcurrency(c.concurrency, out.Context, fn)
+	fn = throttle(c.limiter, out.Context, fn)
+	ctx := NewSimpleClusterRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
 	out.RetryStrategy = ctx
 	return ctx, out
 }
@@ -60,7 +91,12 @@ func (c *Cluster) SearchOptions(in *gocb.SearchOptions, fn ClusterRetryFunc) (Cl
 	if in != nil {
 		*out = *in
 	}
-	ctx := NewSimpleClusterRetryContext(c.retries, c.delay, out.RetryStrategy, fn)
+	policy, base := c.resolvePolicy(OperationSearch, out.RetryStrategy)
+	fn = adaptTimeout(c.adaptive, c.clock, OperationSearch, &out.Timeout, out.Context, fn)
+	fn = limitConcurrency(c.concurrency, out.Context, fn)
+	fn = throttle(c.limiter, out.Context, fn)
+	ctx := NewSimpleClusterRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
 	out.RetryStrategy = ctx
 	return ctx, out
 }
@@ -98,12 +134,16 @@ func (c *Cluster) TrySearchQuery(indexName string, query cbsearch.Query, opts *g
 type QueryIndexManager struct {
 	*gocb.QueryIndexManager
 	commonRetryable
+
+	// cluster is only set when constructed via Cluster.TryQueryIndexes, and is required by Reconcile for index
+	// statements gocb has no equivalent for
+	cluster *Cluster
 }
 
 func NewQueryIndexManager(queryIndexManager *gocb.QueryIndexManager, retries int, delay time.Duration) *QueryIndexManager {
 	qm := new(QueryIndexManager)
 	qm.QueryIndexManager = queryIndexManager
-	qm.retries = uint32(retries)
+	qm.retries = retryLimit(retries)
 	qm.delay = delay
 	return qm
 }
@@ -117,7 +157,11 @@ func (qm *QueryIndexManager) CreateQueryIndexOptions(in *gocb.CreateQueryIndexOp
 	if in != nil {
 		*out = *in
 	}
-	ctx := NewSimpleQueryIndexManagerRetryContext(qm.retries, qm.delay, out.RetryStrategy, fn)
+	policy, base := qm.resolvePolicy(OperationIndexManagement, out.RetryStrategy)
+	fn = limitConcurrency(qm.concurrency, out.Context, fn)
+	fn = throttle(qm.limiter, out.Context, fn)
+	ctx := NewSimpleQueryIndexManagerRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
 	out.RetryStrategy = ctx
 	return ctx, out
 }
@@ -127,7 +171,11 @@ func (qm *QueryIndexManager) CreatePrimaryQueryIndexOptions(in *gocb.CreatePrima
 	if in != nil {
 		*out = *in
 	}
-	ctx := NewSimpleQueryIndexManagerRetryContext(qm.retries, qm.delay, out.RetryStrategy, fn)
+	policy, base := qm.resolvePolicy(OperationIndexManagement, out.RetryStrategy)
+	fn = limitConcurrency(qm.concurrency, out.Context, fn)
+	fn = throttle(qm.limiter, out.Context, fn)
+	ctx := NewSimpleQueryIndexManagerRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
 	out.RetryStrategy = ctx
 	return ctx, out
 }
@@ -137,7 +185,11 @@ func (qm *QueryIndexManager) DropQueryIndexOptions(in *gocb.DropQueryIndexOption
 	if in != nil {
 		*out = *in
 	}
-	ctx := NewSimpleQueryIndexManagerRetryContext(qm.retries, qm.delay, out.RetryStrategy, fn)
+	policy, base := qm.resolvePolicy(OperationIndexManagement, out.RetryStrategy)
+	fn = limitConcurrency(qm.concurrency, out.Context, fn)
+	fn = throttle(qm.limiter, out.Context, fn)
+	ctx := NewSimpleQueryIndexManagerRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
 	out.RetryStrategy = ctx
 	return ctx, out
 }
@@ -147,7 +199,11 @@ func (qm *QueryIndexManager) DropPrimaryQueryIndexOptions(in *gocb.DropPrimaryQu
 	if in != nil {
 		*out = *in
 	}
-	ctx := NewSimpleQueryIndexManagerRetryContext(qm.retries, qm.delay, out.RetryStrategy, fn)
+	policy, base := qm.resolvePolicy(OperationIndexManagement, out.RetryStrategy)
+	fn = limitConcurrency(qm.concurrency, out.Context, fn)
+	fn = throttle(qm.limiter, out.Context, fn)
+	ctx := NewSimpleQueryIndexManagerRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
 	out.RetryStrategy = ctx
 	return ctx, out
 }
@@ -157,7 +213,11 @@ func (qm *QueryIndexManager) GetAllQueryIndexesOptions(in *gocb.GetAllQueryIndex
 	if in != nil {
 		*out = *in
 	}
-	ctx := NewSimpleQueryIndexManagerRetryContext(qm.retries, qm.delay, out.RetryStrategy, fn)
+	policy, base := qm.resolvePolicy(OperationIndexManagement, out.RetryStrategy)
+	fn = limitConcurrency(qm.concurrency, out.Context, fn)
+	fn = throttle(qm.limiter, out.Context, fn)
+	ctx := NewSimpleQueryIndexManagerRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
 	out.RetryStrategy = ctx
 	return ctx, out
 }
@@ -167,17 +227,28 @@ func (qm *QueryIndexManager) BuildDeferredQueryIndexOptions(in *gocb.BuildDeferr
 	if in != nil {
 		*out = *in
 	}
-	ctx := NewSimpleQueryIndexManagerRetryContext(qm.retries, qm.delay, out.RetryStrategy, fn)
+	policy, base := qm.resolvePolicy(OperationIndexManagement, out.RetryStrategy)
+	fn = limitConcurrency(qm.concurrency, out.Context, fn)
+	fn = throttle(qm.limiter, out.Context, fn)
+	ctx := NewSimpleQueryIndexManagerRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
 	out.RetryStrategy = ctx
 	return ctx, out
 }
 
+// WatchQueryIndexOptions builds the options and retry context for a watch.  A watch timing out is not retried, the
+// watch having already waited as long as it was asked to.
 func (qm *QueryIndexManager) WatchQueryIndexOptions(in *gocb.WatchQueryIndexOptions, fn QueryIndexManagerRetryFunc) (QueryIndexManagerRetryContext, *gocb.WatchQueryIndexOptions) {
 	out := new(gocb.WatchQueryIndexOptions)
 	if in != nil {
 		*out = *in
 	}
-	ctx := NewSimpleQueryIndexManagerRetryContext(qm.retries, qm.delay, out.RetryStrategy, fn)
+	policy, base := qm.resolvePolicy(OperationIndexManagement, out.RetryStrategy)
+	policy = policy.withoutTimeoutRetries()
+	fn = limitConcurrency(qm.concurrency, out.Context, fn)
+	fn = throttle(qm.limiter, out.Context, fn)
+	ctx := NewSimpleQueryIndexManagerRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
 	out.RetryStrategy = ctx
 	return ctx, out
 }
@@ -259,6 +330,234 @@ func (qm *QueryIndexManager) TryBuildDeferredIndexes(bucketName string, opts *go
 	return res, err
 }
 
+// TryWatchIndexes waits up to timeout for every index in indexNames to come online.  Failures other than the watch
+// timing out are retried according to the index management policy.
+func (qm *QueryIndexManager) TryWatchIndexes(bucketName string, indexNames []string, timeout time.Duration, opts *gocb.WatchQueryIndexOptions) error {
+	var ctx QueryIndexManagerRetryContext
+	ctx, opts = qm.WatchQueryIndexOptions(opts, func(qm *gocb.QueryIndexManager) error { return qm.WatchIndexes(bucketName, indexNames, timeout, opts) })
+	return qm.Try(ctx)
+}
+
+// TryBuildAndWaitDeferredIndexes builds all deferred indexes within the bucket and then waits up to timeout for them
+// to come online, returning the names of the indexes that were built.  The watch defaults to the scope, collection
+// and context of buildOpts where watchOpts does not set its own.
+func (qm *QueryIndexManager) TryBuildAndWaitDeferredIndexes(bucketName string, timeout time.Duration, buildOpts *gocb.BuildDeferredQueryIndexOptions, watchOpts *gocb.WatchQueryIndexOptions) ([]string, error) {
+	built, err := qm.TryBuildDeferredIndexes(bucketName, buildOpts)
+	if err != nil || len(built) == 0 {
+		return built, err
+	}
+	return built, qm.TryWatchIndexes(bucketName, built, timeout, watchOptionsFor(buildOpts, watchOpts))
+}
+
+// watchOptionsFor returns watchOpts with its keyspace and context defaulted from those of the build being watched
+func watchOptionsFor(buildOpts *gocb.BuildDeferredQueryIndexOptions, watchOpts *gocb.WatchQueryIndexOptions) *gocb.WatchQueryIndexOptions {
+	out := new(gocb.WatchQueryIndexOptions)
+	if watchOpts != nil {
+		*out = *watchOpts
+	}
+	if buildOpts == nil {
+		return out
+	}
+	if out.ScopeName == "" && out.CollectionName == "" {
+		out.ScopeName = buildOpts.ScopeName
+		out.CollectionName = buildOpts.CollectionName
+	}
+	if out.Context == nil {
+		out.Context = buildOpts.Context
+	}
+	return out
+}
+
+type CollectionQueryIndexManager struct {
+	*gocb.CollectionQueryIndexManager
+	commonRetryable
+}
+
+func NewCollectionQueryIndexManager(queryIndexManager *gocb.CollectionQueryIndexManager, retries int, delay time.Duration) *CollectionQueryIndexManager {
+	qm := new(CollectionQueryIndexManager)
+	qm.CollectionQueryIndexManager = queryIndexManager
+	qm.retries = retryLimit(retries)
+	qm.delay = delay
+	return qm
+}
+
+func (qm *CollectionQueryIndexManager) Try(ctx CollectionQueryIndexManagerRetryContext) error {
+	return ctx.Try(qm.CollectionQueryIndexManager)
+}
+
+func (qm *CollectionQueryIndexManager) CreateQueryIndexOptions(in *gocb.CreateQueryIndexOptions, fn CollectionQueryIndexManagerRetryFunc) (CollectionQueryIndexManagerRetryContext, *gocb.CreateQueryIndexOptions) {
+	out := new(gocb.CreateQueryIndexOptions)
+	if in != nil {
+		*out = *in
+	}
+	policy, base := qm.resolvePolicy(OperationIndexManagement, out.RetryStrategy)
+	fn = limitConcurrency(qm.concurrency, out.Context, fn)
+	fn = throttle(qm.limiter, out.Context, fn)
+	ctx := NewSimpleCollectionQueryIndexManagerRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	out.RetryStrategy = ctx
+	return ctx, out
+}
+
+func (qm *CollectionQueryIndexManager) CreatePrimaryQueryIndexOptions(in *gocb.CreatePrimaryQueryIndexOptions, fn CollectionQueryIndexManagerRetryFunc) (CollectionQueryIndexManagerRetryContext, *gocb.CreatePrimaryQueryIndexOptions) {
+	out := new(gocb.CreatePrimaryQueryIndexOptions)
+	if in != nil {
+		*out = *in
+	}
+	policy, base := qm.resolvePolicy(OperationIndexManagement, out.RetryStrategy)
+	fn = limitConcurrency(qm.concurrency, out.Context, fn)
+	fn = throttle(qm.limiter, out.Context, fn)
+	ctx := NewSimpleCollectionQueryIndexManagerRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	out.RetryStrategy = ctx
+	return ctx, out
+}
+
+func (qm *CollectionQueryIndexManager) DropQueryIndexOptions(in *gocb.DropQueryIndexOptions, fn CollectionQueryIndexManagerRetryFunc) (CollectionQueryIndexManagerRetryContext, *gocb.DropQueryIndexOptions) {
+	out := new(gocb.DropQueryIndexOptions)
+	if in != nil {
+		*out = *in
+	}
+	policy, base := qm.resolvePolicy(OperationIndexManagement, out.RetryStrategy)
+	fn = limitConcurrency(qm.concurrency, out.Context, fn)
+	fn = throttle(qm.limiter, out.Context, fn)
+	ctx := NewSimpleCollectionQueryIndexManagerRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	out.RetryStrategy = ctx
+	return ctx, out
+}
+
+func (qm *CollectionQueryIndexManager) DropPrimaryQueryIndexOptions(in *gocb.DropPrimaryQueryIndexOptions, fn CollectionQueryIndexManagerRetryFunc) (CollectionQueryIndexManagerRetryContext, *gocb.DropPrimaryQueryIndexOptions) {
+	out := new(gocb.DropPrimaryQueryIndexOptions)
+	if in != nil {
+		*out = *in
+	}
+	policy, base := qm.resolvePolicy(OperationIndexManagement, out.RetryStrategy)
+	fn = limitConcurrency(qm.concurrency, out.Context, fn)
+	fn = throttle(qm.limiter, out.Context, fn)
+	ctx := NewSimpleCollectionQueryIndexManagerRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	out.RetryStrategy = ctx
+	return ctx, out
+}
+
+func (qm *CollectionQueryIndexManager) GetAllQueryIndexesOptions(in *gocb.GetAllQueryIndexesOptions, fn CollectionQueryIndexManagerRetryFunc) (CollectionQueryIndexManagerRetryContext, *gocb.GetAllQueryIndexesOptions) {
+	out := new(gocb.GetAllQueryIndexesOptions)
+	if in != nil {
+		*out = *in
+	}
+	policy, base := qm.resolvePolicy(OperationIndexManagement, out.RetryStrategy)
+	fn = limitConcurrency(qm.concurrency, out.Context, fn)
+	fn = throttle(qm.limiter, out.Context, fn)
+	ctx := NewSimpleCollectionQueryIndexManagerRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	out.RetryStrategy = ctx
+	return ctx, out
+}
+
+func (qm *CollectionQueryIndexManager) BuildDeferredQueryIndexOptions(in *gocb.BuildDeferredQueryIndexOptions, fn CollectionQueryIndexManagerRetryFunc) (CollectionQueryIndexManagerRetryContext, *gocb.BuildDeferredQueryIndexOptions) {
+	out := new(gocb.BuildDeferredQueryIndexOptions)
+	if in != nil {
+		*out = *in
+	}
+	policy, base := qm.resolvePolicy(OperationIndexManagement, out.RetryStrategy)
+	fn = limitConcurrency(qm.concurrency, out.Context, fn)
+	fn = throttle(qm.limiter, out.Context, fn)
+	ctx := NewSimpleCollectionQueryIndexManagerRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	out.RetryStrategy = ctx
+	return ctx, out
+}
+
+// WatchQueryIndexOptions builds the options and retry context for a watch.  A watch timing out is not retried, the
+// watch having already waited as long as it was asked to.
+func (qm *CollectionQueryIndexManager) WatchQueryIndexOptions(in *gocb.WatchQueryIndexOptions, fn CollectionQueryIndexManagerRetryFunc) (CollectionQueryIndexManagerRetryContext, *gocb.WatchQueryIndexOptions) {
+	out := new(gocb.WatchQueryIndexOptions)
+	if in != nil {
+		*out = *in
+	}
+	policy, base := qm.resolvePolicy(OperationIndexManagement, out.RetryStrategy)
+	policy = policy.withoutTimeoutRetries()
+	fn = limitConcurrency(qm.concurrency, out.Context, fn)
+	fn = throttle(qm.limiter, out.Context, fn)
+	ctx := NewSimpleCollectionQueryIndexManagerRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	out.RetryStrategy = ctx
+	return ctx, out
+}
+
+func (qm *CollectionQueryIndexManager) TryCreateIndex(indexName string, fields []string, opts *gocb.CreateQueryIndexOptions) error {
+	var ctx CollectionQueryIndexManagerRetryContext
+	ctx, opts = qm.CreateQueryIndexOptions(opts, func(qm *gocb.CollectionQueryIndexManager) error { return qm.CreateIndex(indexName, fields, opts) })
+	return qm.Try(ctx)
+}
+
+func (qm *CollectionQueryIndexManager) TryCreatePrimaryIndex(opts *gocb.CreatePrimaryQueryIndexOptions) error {
+	var ctx CollectionQueryIndexManagerRetryContext
+	ctx, opts = qm.CreatePrimaryQueryIndexOptions(opts, func(qm *gocb.CollectionQueryIndexManager) error { return qm.CreatePrimaryIndex(opts) })
+	return qm.Try(ctx)
+}
+
+func (qm *CollectionQueryIndexManager) TryDropIndex(indexName string, opts *gocb.DropQueryIndexOptions) error {
+	var ctx CollectionQueryIndexManagerRetryContext
+	ctx, opts = qm.DropQueryIndexOptions(opts, func(qm *gocb.CollectionQueryIndexManager) error { return qm.DropIndex(indexName, opts) })
+	return qm.Try(ctx)
+}
+
+func (qm *CollectionQueryIndexManager) TryDropPrimaryIndex(opts *gocb.DropPrimaryQueryIndexOptions) error {
+	var ctx CollectionQueryIndexManagerRetryContext
+	ctx, opts = qm.DropPrimaryQueryIndexOptions(opts, func(qm *gocb.CollectionQueryIndexManager) error { return qm.DropPrimaryIndex(opts) })
+	return qm.Try(ctx)
+}
+
+func (qm *CollectionQueryIndexManager) TryGetAllIndexes(opts *gocb.GetAllQueryIndexesOptions) ([]gocb.QueryIndex, error) {
+	var (
+		res []gocb.QueryIndex
+		ctx CollectionQueryIndexManagerRetryContext
+		err error
+	)
+	ctx, opts = qm.GetAllQueryIndexesOptions(opts, func(qm *gocb.CollectionQueryIndexManager) error { res, err = qm.GetAllIndexes(opts); return err })
+	if tryErr := qm.Try(ctx); tryErr != nil {
+		return nil, tryErr
+	}
+	return res, err
+}
+
+func (qm *CollectionQueryIndexManager) TryBuildDeferredIndexes(opts *gocb.BuildDeferredQueryIndexOptions) ([]string, error) {
+	var (
+		res []string
+		ctx CollectionQueryIndexManagerRetryContext
+		err error
+	)
+	ctx, opts = qm.BuildDeferredQueryIndexOptions(opts, func(qm *gocb.CollectionQueryIndexManager) error {
+		res, err = qm.BuildDeferredIndexes(opts)
+		return err
+	})
+	if tryErr := qm.Try(ctx); tryErr != nil {
+		return nil, tryErr
+	}
+	return res, err
+}
+
+// TryWatchIndexes waits up to timeout for every index in indexNames to come online.  Failures other than the watch
+// timing out are retried according to the index management policy.
+func (qm *CollectionQueryIndexManager) TryWatchIndexes(indexNames []string, timeout time.Duration, opts *gocb.WatchQueryIndexOptions) error {
+	var ctx CollectionQueryIndexManagerRetryContext
+	ctx, opts = qm.WatchQueryIndexOptions(opts, func(qm *gocb.CollectionQueryIndexManager) error { return qm.WatchIndexes(indexNames, timeout, opts) })
+	return qm.Try(ctx)
+}
+
+// TryBuildAndWaitDeferredIndexes builds all deferred indexes within the collection and then waits up to timeout for
+// them to come online, returning the names of the indexes that were built.  The watch defaults to the context of
+// buildOpts where watchOpts does not set its own.
+func (qm *CollectionQueryIndexManager) TryBuildAndWaitDeferredIndexes(timeout time.Duration, buildOpts *gocb.BuildDeferredQueryIndexOptions, watchOpts *gocb.WatchQueryIndexOptions) ([]string, error) {
+	built, err := qm.TryBuildDeferredIndexes(buildOpts)
+	if err != nil || len(built) == 0 {
+		return built, err
+	}
+	return built, qm.TryWatchIndexes(built, timeout, watchOptionsFor(buildOpts, watchOpts))
+}
+
 // Pail is our gocb.Bucket wrapper, providing retry goodness.
 type Pail struct {
 	*gocb.Bucket
@@ -268,7 +567,7 @@ type Pail struct {
 func NewPail(bucket *gocb.Bucket, retries int, delay time.Duration) *Pail {
 	p := new(Pail)
 	p.Bucket = bucket
-	p.retries = uint32(retries)
+	p.retries = retryLimit(retries)
 	p.delay = delay
 	return p
 }
@@ -305,6 +604,7 @@ func (s *Scope) Collection(collectionName string) *Collection {
 	c := new(Collection)
 	c.Collection = s.Scope.Collection(collectionName)
 	c.commonRetryable = s.commonRetryable
+	c.adaptive = s.adaptive.child(s.BucketName() + "/" + s.Name() + "/" + collectionName)
 	return c
 }
 
@@ -315,6 +615,14 @@ func (s *Scope) DefaultCollection() *Collection {
 type Collection struct {
 	*gocb.Collection
 	commonRetryable
+	hedgeDelay time.Duration
+}
+
+// TryQueryIndexes returns a retrying wrapper around this collection's query index manager.
+func (c *Collection) TryQueryIndexes() *CollectionQueryIndexManager {
+	qm := NewCollectionQueryIndexManager(c.Collection.QueryIndexes(), int(c.retries), c.delay)
+	qm.commonRetryable = c.commonRetryable
+	return qm
 }
 
 // Try will attempt to execute retryFunc up to retries+1 times or until a
@@ -328,7 +636,12 @@ func (c *Collection) GetOptions(in *gocb.GetOptions, fn CollectionRetryFunc) (Co
 	if in != nil {
 		*out = *in
 	}
-	ctx := NewSimpleCollectionRetryContext(c.retries, c.delay, out.RetryStrategy, fn)
+	policy, base := c.resolvePolicy(OperationGet, out.RetryStrategy)
+	fn = adaptTimeout(c.adaptive, c.clock, OperationGet, &out.Timeout, out.Context, fn)
+	fn = limitConcurrency(c.concurrency, out.Context, fn)
+	fn = throttle(c.limiter, out.Context, fn)
+	ctx := NewSimpleCollectionRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
 	out.RetryStrategy = ctx
 	return ctx, out
 }
@@ -338,7 +651,12 @@ func (c *Collection) TouchOptions(in *gocb.TouchOptions, fn CollectionRetryFunc)
 	if in != nil {
 		*out = *in
 	}
-	ctx := NewSimpleCollectionRetryContext(c.retries, c.delay, out.RetryStrategy, fn)
+	policy, base := c.resolvePolicy(OperationTouch, out.RetryStrategy)
+	fn = adaptTimeout(c.adaptive, c.clock, OperationTouch, &out.Timeout, out.Context, fn)
+	fn = limitConcurrency(c.concurrency, out.Context, fn)
+	fn = throttle(c.limiter, out.Context, fn)
+	ctx := NewSimpleCollectionRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
 	out.RetryStrategy = ctx
 	return ctx, out
 }
@@ -348,7 +666,12 @@ func (c *Collection) UpsertOptions(in *gocb.UpsertOptions, fn CollectionRetryFun
 	if in != nil {
 		*out = *in
 	}
-	ctx := NewSimpleCollectionRetryContext(c.retries, c.delay, out.RetryStrategy, fn)
+	policy, base := c.resolvePolicy(OperationUpsert, out.RetryStrategy)
+	fn = adaptTimeout(c.adaptive, c.clock, OperationUpsert, &out.Timeout, out.Context, fn)
+	fn = limitConcurrency(c.concurrency, out.Context, fn)
+	fn = throttle(c.limiter, out.Context, fn)
+	ctx := NewSimpleCollectionRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
 	out.RetryStrategy = ctx
 	return ctx, out
 }
@@ -358,7 +681,12 @@ func (c *Collection) InsertOptions(in *gocb.InsertOptions, fn CollectionRetryFun
 	if in != nil {
 		*out = *in
 	}
-	ctx := NewSimpleCollectionRetryContext(c.retries, c.delay, out.RetryStrategy, fn)
+	policy, base := c.resolvePolicy(OperationInsert, out.RetryStrategy)
+	fn = adaptTimeout(c.adaptive, c.clock, OperationInsert, &out.Timeout, out.Context, fn)
+	fn = limitConcurrency(c.concurrency, out.Context, fn)
+	fn = throttle(c.limiter, out.Context, fn)
+	ctx := NewSimpleCollectionRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
 	out.RetryStrategy = ctx
 	return ctx, out
 }
@@ -368,7 +696,12 @@ func (c *Collection) ReplaceOptions(in *gocb.ReplaceOptions, fn CollectionRetryF
 	if in != nil {
 		*out = *in
 	}
-	ctx := NewSimpleCollectionRetryContext(c.retries, c.delay, out.RetryStrategy, fn)
+	policy, base := c.resolvePolicy(OperationReplace, out.RetryStrategy)
+	fn = adaptTimeout(c.adaptive, c.clock, OperationReplace, &out.Timeout, out.Context, fn)
+	fn = limitConcurrency(c.concurrency, out.Context, fn)
+	fn = throttle(c.limiter, out.Context, fn)
+	ctx := NewSimpleCollectionRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
 	out.RetryStrategy = ctx
 	return ctx, out
 }
@@ -378,7 +711,12 @@ func (c *Collection) RemoveOptions(in *gocb.RemoveOptions, fn CollectionRetryFun
 	if in != nil {
 		*out = *in
 	}
-	ctx := NewSimpleCollectionRetryContext(c.retries, c.delay, out.RetryStrategy, fn)
+	policy, base := c.resolvePolicy(OperationRemove, out.RetryStrategy)
+	fn = adaptTimeout(c.adaptive, c.clock, OperationRemove, &out.Timeout, out.Context, fn)
+	fn = limitConcurrency(c.concurrency, out.Context, fn)
+	fn = throttle(c.limiter, out.Context, fn)
+	ctx := NewSimpleCollectionRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
 	out.RetryStrategy = ctx
 	return ctx, out
 }
@@ -388,7 +726,12 @@ func (c *Collection) IncrementOptions(in *gocb.IncrementOptions, fn CollectionRe
 	if in != nil {
 		*out = *in
 	}
-	ctx := NewSimpleCollectionRetryContext(c.retries, c.delay, out.RetryStrategy, fn)
+	policy, base := c.resolvePolicy(OperationCounter, out.RetryStrategy)
+	fn = adaptTimeout(c.adaptive, c.clock, OperationCounter, &out.Timeout, out.Context, fn)
+	fn = limitConcurrency(c.concurrency, out.Context, fn)
+	fn = throttle(c.limiter, out.Context, fn)
+	ctx := NewSimpleCollectionRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
 	out.RetryStrategy = ctx
 	return ctx, out
 }
@@ -398,7 +741,12 @@ func (c *Collection) DecrementOptions(in *gocb.DecrementOptions, fn CollectionRe
 	if in != nil {
 		*out = *in
 	}
-	ctx := NewSimpleCollectionRetryContext(c.retries, c.delay, out.RetryStrategy, fn)
+	policy, base := c.resolvePolicy(OperationCounter, out.RetryStrategy)
+	fn = adaptTimeout(c.adaptive, c.clock, OperationCounter, &out.Timeout, out.Context, fn)
+	fn = limitConcurrency(c.concurrency, out.Context, fn)
+	fn = throttle(c.limiter, out.Context, fn)
+	ctx := NewSimpleCollectionRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
 	out.RetryStrategy = ctx
 	return ctx, out
 }
@@ -408,7 +756,12 @@ func (c *Collection) AppendOptions(in *gocb.AppendOptions, fn CollectionRetryFun
 	if in != nil {
 		*out = *in
 	}
-	ctx := NewSimpleCollectionRetryContext(c.retries, c.delay, out.RetryStrategy, fn)
+	policy, base := c.resolvePolicy(OperationAppendPrepend, out.RetryStrategy)
+	fn = adaptTimeout(c.adaptive, c.clock, OperationAppendPrepend, &out.Timeout, out.Context, fn)
+	fn = limitConcurrency(c.concurrency, out.Context, fn)
+	fn = throttle(c.limiter, out.Context, fn)
+	ctx := NewSimpleCollectionRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
 	out.RetryStrategy = ctx
 	return ctx, out
 }
@@ -417,7 +770,87 @@ func (c *Collection) PrependOptions(in *gocb.PrependOptions, fn CollectionRetryF
 	if in != nil {
 		*out = *in
 	}
-	ctx := NewSimpleCollectionRetryContext(c.retries, c.delay, out.RetryStrategy, fn)
+	policy, base := c.resolvePolicy(OperationAppendPrepend, out.RetryStrategy)
+	fn = adaptTimeout(c.adaptive, c.clock, OperationAppendPrepend, &out.Timeout, out.Context, fn)
+	fn = limitConcurrency(c.concurrency, out.Context, fn)
+	fn = throttle(c.limiter, out.Context, fn)
+	ctx := NewSimpleCollectionRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	out.RetryStrategy = ctx
+	return ctx, out
+}
+
+func (c *Collection) LookupInOptions(in *gocb.LookupInOptions, fn CollectionRetryFunc) (CollectionRetryContext, *gocb.LookupInOptions) {
+	out := new(gocb.LookupInOptions)
+	if in != nil {
+		*out = *in
+	}
+	policy, base := c.resolvePolicy(OperationLookupIn, out.RetryStrategy)
+	fn = adaptTimeout(c.adaptive, c.clock, OperationLookupIn, &out.Timeout, out.Context, fn)
+	fn = limitConcurrency(c.concurrency, out.Context, fn)
+	fn = throttle(c.limiter, out.Context, fn)
+	ctx := NewSimpleCollectionRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	out.RetryStrategy = ctx
+	return ctx, out
+}
+
+func (c *Collection) GetAnyReplicaOptions(in *gocb.GetAnyReplicaOptions, fn CollectionRetryFunc) (CollectionRetryContext, *gocb.GetAnyReplicaOptions) {
+	out := new(gocb.GetAnyReplicaOptions)
+	if in != nil {
+		*out = *in
+	}
+	policy, base := c.resolvePolicy(OperationGet, out.RetryStrategy)
+	fn = adaptTimeout(c.adaptive, c.clock, OperationGet, &out.Timeout, out.Context, fn)
+	fn = limitConcurrency(c.concurrency, out.Context, fn)
+	fn = throttle(c.limiter, out.Context, fn)
+	ctx := NewSimpleCollectionRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	out.RetryStrategy = ctx
+	return ctx, out
+}
+
+func (c *Collection) LookupInAnyReplicaOptions(in *gocb.LookupInAnyReplicaOptions, fn CollectionRetryFunc) (CollectionRetryContext, *gocb.LookupInAnyReplicaOptions) {
+	out := new(gocb.LookupInAnyReplicaOptions)
+	if in != nil {
+		*out = *in
+	}
+	policy, base := c.resolvePolicy(OperationLookupIn, out.RetryStrategy)
+	fn = adaptTimeout(c.adaptive, c.clock, OperationLookupIn, &out.Timeout, out.Context, fn)
+	fn = limitConcurrency(c.concurrency, out.Context, fn)
+	fn = throttle(c.limiter, out.Context, fn)
+	ctx := NewSimpleCollectionRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	out.RetryStrategy = ctx
+	return ctx, out
+}
+
+func (c *Collection) GetAndLockOptions(in *gocb.GetAndLockOptions, fn CollectionRetryFunc) (CollectionRetryContext, *gocb.GetAndLockOptions) {
+	out := new(gocb.GetAndLockOptions)
+	if in != nil {
+		*out = *in
+	}
+	policy, base := c.resolvePolicy(OperationLock, out.RetryStrategy)
+	fn = adaptTimeout(c.adaptive, c.clock, OperationLock, &out.Timeout, out.Context, fn)
+	fn = limitConcurrency(c.concurrency, out.Context, fn)
+	fn = throttle(c.limiter, out.Context, fn)
+	ctx := NewSimpleCollectionRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	out.RetryStrategy = ctx
+	return ctx, out
+}
+
+func (c *Collection) UnlockOptions(in *gocb.UnlockOptions, fn CollectionRetryFunc) (CollectionRetryContext, *gocb.UnlockOptions) {
+	out := new(gocb.UnlockOptions)
+	if in != nil {
+		*out = *in
+	}
+	policy, base := c.resolvePolicy(OperationLock, out.RetryStrategy)
+	fn = adaptTimeout(c.adaptive, c.clock, OperationLock, &out.Timeout, out.Context, fn)
+	fn = limitConcurrency(c.concurrency, out.Context, fn)
+	fn = throttle(c.limiter, out.Context, fn)
+	ctx := NewSimpleCollectionRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
 	out.RetryStrategy = ctx
 	return ctx, out
 }
@@ -427,7 +860,11 @@ func (c *Collection) BulkOpOptions(in *gocb.BulkOpOptions, fn CollectionRetryFun
 	if in != nil {
 		*out = *in
 	}
-	ctx := NewSimpleCollectionRetryContext(c.retries, c.delay, out.RetryStrategy, fn)
+	policy, base := c.resolvePolicy(OperationBulk, out.RetryStrategy)
+	fn = limitConcurrency(c.concurrency, out.Context, fn)
+	fn = throttle(c.limiter, out.Context, fn)
+	ctx := NewSimpleCollectionRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
 	out.RetryStrategy = ctx
 	return ctx, out
 }
@@ -444,7 +881,21 @@ func (c *Collection) TryDo(ops []gocb.BulkOp, opts *gocb.BulkOpOptions) error {
 	return err
 }
 
+// TryGet fetches the document identified by id.  If hedged reads are enabled, see WithHedging, a replica read may be
+// raced against the active read.  The result does not say which answered, so callers which must know whether the
+// document may be stale should use TryGetHedged instead.
 func (c *Collection) TryGet(id string, opts *gocb.GetOptions) (*gocb.GetResult, error) {
+	if c.hedgeDelay > 0 {
+		res, err := c.TryGetHedged(id, opts)
+		if err != nil {
+			return nil, err
+		}
+		return res.GetResult, nil
+	}
+	return c.tryGetActive(id, opts)
+}
+
+func (c *Collection) tryGetActive(id string, opts *gocb.GetOptions) (*gocb.GetResult, error) {
 	var (
 		res *gocb.GetResult
 		ctx CollectionRetryContext
@@ -468,6 +919,84 @@ func (c *Collection) TryGetContent(id string, ptr interface{}, opts *gocb.GetOpt
 	return res, res.Content(ptr)
 }
 
+func (c *Collection) TryGetAnyReplica(id string, opts *gocb.GetAnyReplicaOptions) (*gocb.GetReplicaResult, error) {
+	var (
+		res *gocb.GetReplicaResult
+		ctx CollectionRetryContext
+		err error
+	)
+	ctx, opts = c.GetAnyReplicaOptions(opts, func(c *gocb.Collection) error { res, err = c.GetAnyReplica(id, opts); return err })
+	if tryErr := c.Try(ctx); tryErr != nil {
+		return nil, tryErr
+	}
+	return res, err
+}
+
+// TryLookupIn performs the sub-document lookups in ops against the document identified by id.  If hedged reads are
+// enabled, see WithHedging, a replica lookup may be raced against the active lookup.  The result does not say which
+// answered, so callers which must know whether it may be stale should use TryLookupInHedged instead.
+func (c *Collection) TryLookupIn(id string, ops []gocb.LookupInSpec, opts *gocb.LookupInOptions) (*gocb.LookupInResult, error) {
+	if c.hedgeDelay > 0 {
+		res, err := c.TryLookupInHedged(id, ops, opts)
+		if err != nil {
+			return nil, err
+		}
+		return res.LookupInResult, nil
+	}
+	return c.tryLookupInActive(id, ops, opts)
+}
+
+func (c *Collection) tryLookupInActive(id string, ops []gocb.LookupInSpec, opts *gocb.LookupInOptions) (*gocb.LookupInResult, error) {
+	var (
+		res *gocb.LookupInResult
+		ctx CollectionRetryContext
+		err error
+	)
+	ctx, opts = c.LookupInOptions(opts, func(c *gocb.Collection) error { res, err = c.LookupIn(id, ops, opts); return err })
+	if tryErr := c.Try(ctx); tryErr != nil {
+		return nil, tryErr
+	}
+	return res, err
+}
+
+func (c *Collection) TryLookupInAnyReplica(id string, ops []gocb.LookupInSpec, opts *gocb.LookupInAnyReplicaOptions) (*gocb.LookupInReplicaResult, error) {
+	var (
+		res *gocb.LookupInReplicaResult
+		ctx CollectionRetryContext
+		err error
+	)
+	ctx, opts = c.LookupInAnyReplicaOptions(opts, func(c *gocb.Collection) error { res, err = c.LookupInAnyReplica(id, ops, opts); return err })
+	if tryErr := c.Try(ctx); tryErr != nil {
+		return nil, tryErr
+	}
+	return res, err
+}
+
+func (c *Collection) TryGetAndLock(id string, lockTime time.Duration, opts *gocb.GetAndLockOptions) (*gocb.GetResult, error) {
+	var (
+		res *gocb.GetResult
+		ctx CollectionRetryContext
+		err error
+	)
+	ctx, opts = c.GetAndLockOptions(opts, func(c *gocb.Collection) error { res, err = c.GetAndLock(id, lockTime, opts); return err })
+	if tryErr := c.Try(ctx); tryErr != nil {
+		return nil, tryErr
+	}
+	return res, err
+}
+
+func (c *Collection) TryUnlock(id string, cas gocb.Cas, opts *gocb.UnlockOptions) error {
+	var (
+		ctx CollectionRetryContext
+		err error
+	)
+	ctx, opts = c.UnlockOptions(opts, func(c *gocb.Collection) error { err = c.Unlock(id, cas, opts); return err })
+	if tryErr := c.Try(ctx); tryErr != nil {
+		return tryErr
+	}
+	return err
+}
+
 func (c *Collection) TryTouch(id string, expiry time.Duration, opts *gocb.TouchOptions) (*gocb.MutationResult, error) {
 	var (
 		res *gocb.MutationResult
@@ -487,7 +1016,14 @@ func (c *Collection) TryUpsert(id string, value interface{}, opts *gocb.UpsertOp
 		ctx CollectionRetryContext
 		err error
 	)
-	ctx, opts = c.UpsertOptions(opts, func(c *gocb.Collection) error { res, err = c.Upsert(id, value, opts); return err })
+	gate := c.payloadGate(value)
+	ctx, opts = c.UpsertOptions(opts, func(c *gocb.Collection) error {
+		if err = gate(opts.Context); err != nil {
+			return err
+		}
+		res, err = c.Upsert(id, value, opts)
+		return err
+	})
 	if tryErr := c.Try(ctx); tryErr != nil {
 		return nil, tryErr
 	}
@@ -500,7 +1036,14 @@ func (c *Collection) TryInsert(id string, value interface{}, opts *gocb.InsertOp
 		ctx CollectionRetryContext
 		err error
 	)
-	ctx, opts = c.InsertOptions(opts, func(c *gocb.Collection) error { res, err = c.Insert(id, value, opts); return err })
+	gate := c.payloadGate(value)
+	ctx, opts = c.InsertOptions(opts, func(c *gocb.Collection) error {
+		if err = gate(opts.Context); err != nil {
+			return err
+		}
+		res, err = c.Insert(id, value, opts)
+		return err
+	})
 	if tryErr := c.Try(ctx); tryErr != nil {
 		return nil, tryErr
 	}
@@ -513,7 +1056,14 @@ func (c *Collection) TryReplace(id string, value interface{}, opts *gocb.Replace
 		ctx CollectionRetryContext
 		err error
 	)
-	ctx, opts = c.ReplaceOptions(opts, func(c *gocb.Collection) error { res, err = c.Replace(id, value, opts); return err })
+	gate := c.payloadGate(value)
+	ctx, opts = c.ReplaceOptions(opts, func(c *gocb.Collection) error {
+		if err = gate(opts.Context); err != nil {
+			return err
+		}
+		res, err = c.Replace(id, value, opts)
+		return err
+	})
 	if tryErr := c.Try(ctx); tryErr != nil {
 		return nil, tryErr
 	}
@@ -565,7 +1115,14 @@ func (c *Collection) TryAppend(id string, value []byte, opts *gocb.AppendOptions
 		ctx CollectionRetryContext
 		err error
 	)
-	ctx, opts = c.AppendOptions(opts, func(c *gocb.Collection) error { res, err = c.Binary().Append(id, value, opts); return err })
+	gate := c.payloadGate(value)
+	ctx, opts = c.AppendOptions(opts, func(c *gocb.Collection) error {
+		if err = gate(opts.Context); err != nil {
+			return err
+		}
+		res, err = c.Binary().Append(id, value, opts)
+		return err
+	})
 	if tryErr := c.Try(ctx); tryErr != nil {
 		return nil, tryErr
 	}
@@ -578,7 +1135,14 @@ func (c *Collection) TryPrepend(id string, value []byte, opts *gocb.PrependOptio
 		ctx CollectionRetryContext
 		err error
 	)
-	ctx, opts = c.PrependOptions(opts, func(c *gocb.Collection) error { res, err = c.Binary().Prepend(id, value, opts); return err })
+	gate := c.payloadGate(value)
+	ctx, opts = c.PrependOptions(opts, func(c *gocb.Collection) error {
+		if err = gate(opts.Context); err != nil {
+			return err
+		}
+		res, err = c.Binary().Prepend(id, value, opts)
+		return err
+	})
 	if tryErr := c.Try(ctx); tryErr != nil {
 		return nil, tryErr
 	}
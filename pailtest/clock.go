@@ -0,0 +1,155 @@
+package pailtest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/myENA/pail/v2"
+)
+
+type sleeper struct {
+	until time.Time
+	ch    chan time.Time
+}
+
+// FakeClock is a manually advanced pail.Clock.  Sleeps and timers fire only once the clock has been advanced past
+// their deadline, unless auto-advance is enabled in which case the clock jumps forward to each deadline as it is
+// scheduled.  Every requested wait is recorded so that tests may assert on retry timing.  It is safe for concurrent
+// use.
+type FakeClock struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	now      time.Time
+	auto     bool
+	sleepers []*sleeper
+	sleeps   []time.Duration
+}
+
+var _ pail.Clock = (*FakeClock)(nil)
+
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// SetAutoAdvance enables or disables auto-advance.  With it enabled, waits complete immediately, having moved the
+// clock forward by their duration.
+func (c *FakeClock) SetAutoAdvance(auto bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.auto = auto
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch, _ := c.schedule(d)
+	return ch
+}
+
+func (c *FakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	c.mu.Lock()
+	ch, s := c.schedule(d)
+	c.mu.Unlock()
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		c.remove(s)
+		c.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// schedule records a wait of d, returning a channel that receives once it has elapsed.  c.mu must be held.
+func (c *FakeClock) schedule(d time.Duration) (chan time.Time, *sleeper) {
+	c.sleeps = append(c.sleeps, d)
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch, nil
+	}
+	if c.auto {
+		c.now = c.now.Add(d)
+		ch <- c.now
+		c.fire()
+		return ch, nil
+	}
+	s := &sleeper{until: c.now.Add(d), ch: ch}
+	c.sleepers = append(c.sleepers, s)
+	c.cond.Broadcast()
+	return ch, s
+}
+
+func (c *FakeClock) remove(s *sleeper) {
+	for i, other := range c.sleepers {
+		if other == s {
+			c.sleepers = append(c.sleepers[:i], c.sleepers[i+1:]...)
+			return
+		}
+	}
+}
+
+// fire releases every sleeper whose deadline has been reached.  c.mu must be held.
+func (c *FakeClock) fire() {
+	pending := c.sleepers[:0]
+	for _, s := range c.sleepers {
+		if s.until.After(c.now) {
+			pending = append(pending, s)
+			continue
+		}
+		s.ch <- c.now
+	}
+	c.sleepers = pending
+}
+
+// Advance moves the clock forward by d, firing any sleeps or timers that become due.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.fire()
+}
+
+// Set moves the clock to t, which may be in the past, firing any sleeps or timers that become due.
+func (c *FakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+	c.fire()
+}
+
+// Sleeps returns the duration of every wait requested of the clock, in order.
+func (c *FakeClock) Sleeps() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]time.Duration, len(c.sleeps))
+	copy(out, c.sleeps)
+	return out
+}
+
+// Pending returns the number of sleeps and timers yet to fire.
+func (c *FakeClock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.sleepers)
+}
+
+// BlockUntil waits until at least n sleeps or timers are pending, allowing a test to advance the clock only once the
+// code under test has begun waiting.
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.sleepers) < n {
+		c.cond.Wait()
+	}
+}
@@ -0,0 +1,105 @@
+package pailtest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFakeClockAdvance(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	ch := clock.After(time.Second)
+
+	clock.Advance(999 * time.Millisecond)
+	select {
+	case <-ch:
+		t.Fatal("timer fired before its deadline")
+	default:
+	}
+	if clock.Pending() != 1 {
+		t.Fatalf("expected 1 pending timer, got %d", clock.Pending())
+	}
+
+	clock.Advance(time.Millisecond)
+	select {
+	case at := <-ch:
+		if !at.Equal(epoch.Add(time.Second)) {
+			t.Fatalf("expected the timer to fire at %s, got %s", epoch.Add(time.Second), at)
+		}
+	default:
+		t.Fatal("timer did not fire at its deadline")
+	}
+	if !clock.Now().Equal(epoch.Add(time.Second)) {
+		t.Fatalf("expected now to be %s, got %s", epoch.Add(time.Second), clock.Now())
+	}
+	if clock.Pending() != 0 {
+		t.Fatalf("expected no pending timers, got %d", clock.Pending())
+	}
+}
+
+func TestFakeClockSleepOrder(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	woke := make(chan time.Duration, 3)
+	for _, d := range []time.Duration{3 * time.Second, time.Second, 2 * time.Second} {
+		go func(d time.Duration) {
+			if err := clock.Sleep(context.Background(), d); err != nil {
+				t.Errorf("unexpected sleep error: %v", err)
+			}
+			woke <- d
+		}(d)
+	}
+	clock.BlockUntil(3)
+
+	// each advance must release exactly the sleep that has come due
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second} {
+		clock.Advance(time.Second)
+		if got := <-woke; got != want {
+			t.Fatalf("expected the %s sleep to wake, got %s", want, got)
+		}
+		if clock.Pending() != 2-i {
+			t.Fatalf("expected %d pending sleeps, got %d", 2-i, clock.Pending())
+		}
+	}
+
+	sleeps := clock.Sleeps()
+	if len(sleeps) != 3 {
+		t.Fatalf("expected 3 recorded sleeps, got %v", sleeps)
+	}
+}
+
+func TestFakeClockAutoAdvance(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	clock.SetAutoAdvance(true)
+	for _, d := range []time.Duration{time.Second, 0, 5 * time.Millisecond} {
+		if err := clock.Sleep(context.Background(), d); err != nil {
+			t.Fatalf("unexpected sleep error: %v", err)
+		}
+	}
+	if want := epoch.Add(time.Second + 5*time.Millisecond); !clock.Now().Equal(want) {
+		t.Fatalf("expected now to be %s, got %s", want, clock.Now())
+	}
+	if want := []time.Duration{time.Second, 0, 5 * time.Millisecond}; !reflect.DeepEqual(clock.Sleeps(), want) {
+		t.Fatalf("expected sleeps %v, got %v", want, clock.Sleeps())
+	}
+}
+
+func TestFakeClockSleepCancelled(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() { errCh <- clock.Sleep(ctx, time.Minute) }()
+	clock.BlockUntil(1)
+
+	cancel()
+	if err := <-errCh; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the sleep to end with the context's error, got %v", err)
+	}
+	if clock.Pending() != 0 {
+		t.Fatalf("expected the cancelled sleep to be removed, %d pending", clock.Pending())
+	}
+	if !clock.Now().Equal(epoch) {
+		t.Fatalf("expected cancellation not to move the clock, now %s", clock.Now())
+	}
+}
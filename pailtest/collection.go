@@ -0,0 +1,485 @@
+// Package pailtest provides in-memory implementations of pail's interfaces, fault injection and record/replay of
+// cluster interactions for use in unit tests.
+package pailtest
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/myENA/pail/v2"
+)
+
+const (
+	defaultLockTime = 15 * time.Second
+	maxLockTime     = 30 * time.Second
+
+	// lockedCas is the cas reported by a plain get of a locked document, as Couchbase Server does
+	lockedCas = gocb.Cas(0xffffffffffffffff)
+)
+
+type document struct {
+	contents    []byte
+	flags       uint32
+	cas         gocb.Cas
+	expiry      time.Time
+	lockedUntil time.Time
+}
+
+// Collection is an in-memory pail.KV.  Documents expire, and locks lapse, according to its clock, which tests may
+// advance at will.  Retry options are ignored as no operation ever fails transiently.
+type Collection struct {
+	name  string
+	clock *FakeClock
+
+	mu   sync.Mutex
+	docs map[string]*document
+	cas  gocb.Cas
+}
+
+var _ pail.KV = (*Collection)(nil)
+
+// NewCollection creates an empty collection.  If clock is nil, a clock starting at the current time is used.
+func NewCollection(clock *FakeClock) *Collection {
+	if clock == nil {
+		clock = NewFakeClock(time.Now())
+	}
+	return &Collection{
+		name:  "_default",
+		clock: clock,
+		docs:  make(map[string]*document),
+	}
+}
+
+func (c *Collection) Clock() *FakeClock {
+	return c.clock
+}
+
+// Len returns the number of live documents.
+func (c *Collection) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	n := 0
+	for _, doc := range c.docs {
+		if !doc.expired(now) {
+			n++
+		}
+	}
+	return n
+}
+
+func (d *document) expired(now time.Time) bool {
+	return !d.expiry.IsZero() && !now.Before(d.expiry)
+}
+
+func (d *document) locked(now time.Time) bool {
+	return now.Before(d.lockedUntil)
+}
+
+func (c *Collection) kvError(id string, err error) error {
+	return &gocb.KeyValueError{
+		InnerError:     err,
+		DocumentID:     id,
+		CollectionName: c.name,
+	}
+}
+
+func (c *Collection) nextCas() gocb.Cas {
+	c.cas++
+	return c.cas
+}
+
+// live returns the unexpired document with the given id.  c.mu must be held.
+func (c *Collection) live(id string, now time.Time) (*document, bool) {
+	doc, ok := c.docs[id]
+	if !ok {
+		return nil, false
+	}
+	if doc.expired(now) {
+		delete(c.docs, id)
+		return nil, false
+	}
+	return doc, true
+}
+
+// mutable returns the document with the given id, provided it exists and the caller's cas permits its mutation.  A
+// locked document may only be mutated by providing the cas returned when it was locked.  c.mu must be held.
+func (c *Collection) mutable(id string, cas gocb.Cas, now time.Time) (*document, error) {
+	doc, ok := c.live(id, now)
+	if !ok {
+		return nil, c.kvError(id, gocb.ErrDocumentNotFound)
+	}
+	if doc.locked(now) {
+		if cas != doc.cas {
+			return nil, c.kvError(id, gocb.ErrDocumentLocked)
+		}
+	} else if cas != 0 && cas != doc.cas {
+		return nil, c.kvError(id, gocb.ErrCasMismatch)
+	}
+	return doc, nil
+}
+
+func expiryAt(now time.Time, expiry time.Duration) time.Time {
+	if expiry <= 0 {
+		return time.Time{}
+	}
+	return now.Add(expiry)
+}
+
+func encode(transcoder gocb.Transcoder, value interface{}) ([]byte, uint32, error) {
+	if transcoder == nil {
+		transcoder = gocb.NewJSONTranscoder()
+	}
+	return transcoder.Encode(value)
+}
+
+func decoder(transcoder gocb.Transcoder) gocb.Transcoder {
+	if transcoder == nil {
+		return gocb.NewJSONTranscoder()
+	}
+	return transcoder
+}
+
+func (c *Collection) TryGet(id string, opts *gocb.GetOptions) (*gocb.GetResult, error) {
+	if opts == nil {
+		opts = new(gocb.GetOptions)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	doc, ok := c.live(id, now)
+	if !ok {
+		return nil, c.kvError(id, gocb.ErrDocumentNotFound)
+	}
+	cas := doc.cas
+	if doc.locked(now) {
+		cas = lockedCas
+	}
+	var expiry *time.Time
+	if opts.WithExpiry {
+		e := doc.expiry
+		expiry = &e
+	}
+	contents := doc.contents
+	if len(opts.Project) > 0 {
+		var err error
+		if contents, err = project(doc.contents, opts.Project); err != nil {
+			return nil, c.kvError(id, err)
+		}
+	}
+	return newGetResult(cas, decoder(opts.Transcoder), contents, doc.flags, expiry), nil
+}
+
+// project builds a document containing only the given paths of contents
+func project(contents []byte, paths []string) ([]byte, error) {
+	var doc interface{}
+	if err := json.Unmarshal(contents, &doc); err != nil {
+		return nil, gocb.ErrDocumentNotJSON
+	}
+	out := make(map[string]interface{})
+	for _, path := range paths {
+		v, err := lookupPath(doc, path)
+		if err != nil {
+			continue
+		}
+		if err = setPath(out, path, v); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(out)
+}
+
+func (c *Collection) TryGetContent(id string, ptr interface{}, opts *gocb.GetOptions) (*gocb.GetResult, error) {
+	res, err := c.TryGet(id, opts)
+	if err != nil {
+		return nil, err
+	}
+	return res, res.Content(ptr)
+}
+
+func (c *Collection) TryGetAndLock(id string, lockTime time.Duration, opts *gocb.GetAndLockOptions) (*gocb.GetResult, error) {
+	if opts == nil {
+		opts = new(gocb.GetAndLockOptions)
+	}
+	if lockTime <= 0 || lockTime > maxLockTime {
+		lockTime = defaultLockTime
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	doc, ok := c.live(id, now)
+	if !ok {
+		return nil, c.kvError(id, gocb.ErrDocumentNotFound)
+	}
+	if doc.locked(now) {
+		return nil, c.kvError(id, gocb.ErrDocumentLocked)
+	}
+	doc.cas = c.nextCas()
+	doc.lockedUntil = now.Add(lockTime)
+	return newGetResult(doc.cas, decoder(opts.Transcoder), doc.contents, doc.flags, nil), nil
+}
+
+func (c *Collection) TryUnlock(id string, cas gocb.Cas, _ *gocb.UnlockOptions) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	doc, ok := c.live(id, now)
+	if !ok {
+		return c.kvError(id, gocb.ErrDocumentNotFound)
+	}
+	if !doc.locked(now) {
+		return c.kvError(id, gocb.ErrDocumentNotLocked)
+	}
+	if cas != doc.cas {
+		return c.kvError(id, gocb.ErrCasMismatch)
+	}
+	doc.lockedUntil = time.Time{}
+	return nil
+}
+
+// TryLookupIn supports get, exists and count specs against JSON documents.  Extended attributes are not supported,
+// and lookups of them report ErrPathNotFound.
+func (c *Collection) TryLookupIn(id string, ops []gocb.LookupInSpec, _ *gocb.LookupInOptions) (*gocb.LookupInResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	doc, ok := c.live(id, now)
+	if !ok {
+		return nil, c.kvError(id, gocb.ErrDocumentNotFound)
+	}
+	var body interface{}
+	if err := json.Unmarshal(doc.contents, &body); err != nil {
+		return nil, c.kvError(id, gocb.ErrDocumentNotJSON)
+	}
+	cas := doc.cas
+	if doc.locked(now) {
+		cas = lockedCas
+	}
+
+	partials := make([]lookupInPartial, len(ops))
+	for i, spec := range ops {
+		path, xattr := specPath(spec)
+		var (
+			v   interface{}
+			err = gocb.ErrPathNotFound
+		)
+		if !xattr {
+			v, err = lookupPath(body, path)
+		}
+		switch specOp(spec) {
+		case lookupExists:
+			switch err {
+			case nil:
+				partials[i].data = json.RawMessage("true")
+			case gocb.ErrPathNotFound:
+				partials[i].data = json.RawMessage("false")
+			default:
+				partials[i].err = err
+			}
+		case lookupCount:
+			if err != nil {
+				partials[i].err = err
+				break
+			}
+			switch tv := v.(type) {
+			case []interface{}:
+				partials[i].data = json.RawMessage(strconv.Itoa(len(tv)))
+			case map[string]interface{}:
+				partials[i].data = json.RawMessage(strconv.Itoa(len(tv)))
+			default:
+				partials[i].err = gocb.ErrPathMismatch
+			}
+		case lookupGet:
+			if err != nil {
+				partials[i].err = err
+				break
+			}
+			if partials[i].data, err = json.Marshal(v); err != nil {
+				partials[i].err = err
+			}
+		default:
+			partials[i].err = gocb.ErrFeatureNotAvailable
+		}
+	}
+	return newLookupInResult(cas, ops, partials), nil
+}
+
+func (c *Collection) TryTouch(id string, expiry time.Duration, _ *gocb.TouchOptions) (*gocb.MutationResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	doc, err := c.mutable(id, 0, now)
+	if err != nil {
+		return nil, err
+	}
+	doc.expiry = expiryAt(now, expiry)
+	doc.cas = c.nextCas()
+	return newMutationResult(doc.cas), nil
+}
+
+func (c *Collection) TryUpsert(id string, value interface{}, opts *gocb.UpsertOptions) (*gocb.MutationResult, error) {
+	if opts == nil {
+		opts = new(gocb.UpsertOptions)
+	}
+	contents, flags, err := encode(opts.Transcoder, value)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	doc, ok := c.live(id, now)
+	if ok && doc.locked(now) {
+		return nil, c.kvError(id, gocb.ErrDocumentLocked)
+	}
+	expiry := expiryAt(now, opts.Expiry)
+	if ok && opts.PreserveExpiry {
+		expiry = doc.expiry
+	}
+	doc = &document{contents: contents, flags: flags, cas: c.nextCas(), expiry: expiry}
+	c.docs[id] = doc
+	return newMutationResult(doc.cas), nil
+}
+
+func (c *Collection) TryInsert(id string, value interface{}, opts *gocb.InsertOptions) (*gocb.MutationResult, error) {
+	if opts == nil {
+		opts = new(gocb.InsertOptions)
+	}
+	contents, flags, err := encode(opts.Transcoder, value)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	if _, ok := c.live(id, now); ok {
+		return nil, c.kvError(id, gocb.ErrDocumentExists)
+	}
+	doc := &document{contents: contents, flags: flags, cas: c.nextCas(), expiry: expiryAt(now, opts.Expiry)}
+	c.docs[id] = doc
+	return newMutationResult(doc.cas), nil
+}
+
+func (c *Collection) TryReplace(id string, value interface{}, opts *gocb.ReplaceOptions) (*gocb.MutationResult, error) {
+	if opts == nil {
+		opts = new(gocb.ReplaceOptions)
+	}
+	contents, flags, err := encode(opts.Transcoder, value)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	doc, err := c.mutable(id, opts.Cas, now)
+	if err != nil {
+		return nil, err
+	}
+	if !opts.PreserveExpiry {
+		doc.expiry = expiryAt(now, opts.Expiry)
+	}
+	doc.contents, doc.flags = contents, flags
+	doc.lockedUntil = time.Time{}
+	doc.cas = c.nextCas()
+	return newMutationResult(doc.cas), nil
+}
+
+func (c *Collection) TryRemove(id string, opts *gocb.RemoveOptions) (*gocb.MutationResult, error) {
+	if opts == nil {
+		opts = new(gocb.RemoveOptions)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.mutable(id, opts.Cas, c.clock.Now()); err != nil {
+		return nil, err
+	}
+	delete(c.docs, id)
+	return newMutationResult(c.nextCas()), nil
+}
+
+// counter applies delta to the counter document id, creating it with initial if it does not exist and initial is
+// non-negative.  Decrements stop at zero.
+func (c *Collection) counter(id string, delta int64, initial int64, expiry time.Duration) (*gocb.CounterResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	doc, ok := c.live(id, now)
+	if !ok {
+		if initial < 0 {
+			return nil, c.kvError(id, gocb.ErrDocumentNotFound)
+		}
+		contents, flags, _ := encode(nil, json.RawMessage(strconv.FormatInt(initial, 10)))
+		doc = &document{contents: contents, flags: flags, cas: c.nextCas(), expiry: expiryAt(now, expiry)}
+		c.docs[id] = doc
+		return newCounterResult(doc.cas, uint64(initial)), nil
+	}
+	if doc.locked(now) {
+		return nil, c.kvError(id, gocb.ErrDocumentLocked)
+	}
+	current, err := strconv.ParseUint(strings.TrimSpace(string(doc.contents)), 10, 64)
+	if err != nil {
+		return nil, c.kvError(id, gocb.ErrDeltaInvalid)
+	}
+	switch {
+	case delta >= 0:
+		current += uint64(delta)
+	case uint64(-delta) > current:
+		current = 0
+	default:
+		current -= uint64(-delta)
+	}
+	doc.contents = []byte(strconv.FormatUint(current, 10))
+	doc.cas = c.nextCas()
+	return newCounterResult(doc.cas, current), nil
+}
+
+func (c *Collection) TryIncrement(id string, opts *gocb.IncrementOptions) (*gocb.CounterResult, error) {
+	if opts == nil {
+		opts = new(gocb.IncrementOptions)
+	}
+	return c.counter(id, int64(opts.Delta), opts.Initial, opts.Expiry)
+}
+
+func (c *Collection) TryDecrement(id string, opts *gocb.DecrementOptions) (*gocb.CounterResult, error) {
+	if opts == nil {
+		opts = new(gocb.DecrementOptions)
+	}
+	return c.counter(id, -int64(opts.Delta), opts.Initial, opts.Expiry)
+}
+
+func (c *Collection) concat(id string, value []byte, cas gocb.Cas, prepend bool) (*gocb.MutationResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	doc, err := c.mutable(id, cas, c.clock.Now())
+	if err != nil {
+		return nil, err
+	}
+	contents := make([]byte, 0, len(doc.contents)+len(value))
+	if prepend {
+		contents = append(append(contents, value...), doc.contents...)
+	} else {
+		contents = append(append(contents, doc.contents...), value...)
+	}
+	doc.contents = contents
+	doc.lockedUntil = time.Time{}
+	doc.cas = c.nextCas()
+	return newMutationResult(doc.cas), nil
+}
+
+func (c *Collection) TryAppend(id string, value []byte, opts *gocb.AppendOptions) (*gocb.MutationResult, error) {
+	if opts == nil {
+		opts = new(gocb.AppendOptions)
+	}
+	return c.concat(id, value, opts.Cas, false)
+}
+
+func (c *Collection) TryPrepend(id string, value []byte, opts *gocb.PrependOptions) (*gocb.MutationResult, error) {
+	if opts == nil {
+		opts = new(gocb.PrependOptions)
+	}
+	return c.concat(id, value, opts.Cas, true)
+}
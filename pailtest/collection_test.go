@@ -0,0 +1,277 @@
+package pailtest
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type user struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags,omitempty"`
+	Age  int      `json:"age,omitempty"`
+}
+
+func TestCollectionCasConflicts(t *testing.T) {
+	c := NewCollection(NewFakeClock(epoch))
+	first, err := c.TryInsert("u", user{Name: "ann"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected insert error: %v", err)
+	}
+	if _, err = c.TryInsert("u", user{Name: "bob"}, nil); !errors.Is(err, gocb.ErrDocumentExists) {
+		t.Fatalf("expected inserting an existing document to fail with ErrDocumentExists, got %v", err)
+	}
+
+	second, err := c.TryReplace("u", user{Name: "bob"}, &gocb.ReplaceOptions{Cas: first.Cas()})
+	if err != nil {
+		t.Fatalf("unexpected replace error: %v", err)
+	}
+	if second.Cas() == first.Cas() {
+		t.Fatal("expected the replace to change the cas")
+	}
+	if _, err = c.TryReplace("u", user{Name: "cat"}, &gocb.ReplaceOptions{Cas: first.Cas()}); !errors.Is(err, gocb.ErrCasMismatch) {
+		t.Fatalf("expected a stale cas replace to fail with ErrCasMismatch, got %v", err)
+	}
+	if _, err = c.TryRemove("u", &gocb.RemoveOptions{Cas: first.Cas()}); !errors.Is(err, gocb.ErrCasMismatch) {
+		t.Fatalf("expected a stale cas remove to fail with ErrCasMismatch, got %v", err)
+	}
+
+	var got user
+	res, err := c.TryGetContent("u", &got, nil)
+	if err != nil {
+		t.Fatalf("unexpected get error: %v", err)
+	}
+	if got.Name != "bob" || res.Cas() != second.Cas() {
+		t.Fatalf("expected bob at cas %d, got %+v at cas %d", second.Cas(), got, res.Cas())
+	}
+
+	if _, err = c.TryRemove("u", &gocb.RemoveOptions{Cas: second.Cas()}); err != nil {
+		t.Fatalf("unexpected remove error: %v", err)
+	}
+	var kvErr *gocb.KeyValueError
+	if _, err = c.TryGet("u", nil); !errors.Is(err, gocb.ErrDocumentNotFound) || !errors.As(err, &kvErr) || kvErr.DocumentID != "u" {
+		t.Fatalf("expected a KeyValueError for u wrapping ErrDocumentNotFound, got %v", err)
+	}
+}
+
+func TestCollectionExpiry(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	c := NewCollection(clock)
+	if _, err := c.TryUpsert("u", user{Name: "ann"}, &gocb.UpsertOptions{Expiry: 10 * time.Second}); err != nil {
+		t.Fatalf("unexpected upsert error: %v", err)
+	}
+
+	res, err := c.TryGet("u", &gocb.GetOptions{WithExpiry: true})
+	if err != nil {
+		t.Fatalf("unexpected get error: %v", err)
+	}
+	if exp := res.ExpiryTime(); !exp.Equal(epoch.Add(10 * time.Second)) {
+		t.Fatalf("expected expiry at %s, got %s", epoch.Add(10*time.Second), exp)
+	}
+
+	clock.Advance(9 * time.Second)
+	if _, err = c.TryTouch("u", 10*time.Second, nil); err != nil {
+		t.Fatalf("unexpected touch error: %v", err)
+	}
+	clock.Advance(9 * time.Second)
+	if c.Len() != 1 {
+		t.Fatal("expected the touched document to outlive its original expiry")
+	}
+
+	clock.Advance(time.Second)
+	if c.Len() != 0 {
+		t.Fatalf("expected the document to have expired, %d remain", c.Len())
+	}
+	if _, err = c.TryGet("u", nil); !errors.Is(err, gocb.ErrDocumentNotFound) {
+		t.Fatalf("expected an expired document not to be found, got %v", err)
+	}
+	if _, err = c.TryInsert("u", user{Name: "bob"}, nil); err != nil {
+		t.Fatalf("expected an expired document to be insertable, got %v", err)
+	}
+}
+
+func TestCollectionLocks(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	c := NewCollection(clock)
+	if _, err := c.TryUpsert("u", user{Name: "ann"}, nil); err != nil {
+		t.Fatalf("unexpected upsert error: %v", err)
+	}
+
+	locked, err := c.TryGetAndLock("u", 5*time.Second, nil)
+	if err != nil {
+		t.Fatalf("unexpected lock error: %v", err)
+	}
+	if _, err = c.TryGetAndLock("u", 5*time.Second, nil); !errors.Is(err, gocb.ErrDocumentLocked) {
+		t.Fatalf("expected a second lock to fail with ErrDocumentLocked, got %v", err)
+	}
+	if res, _ := c.TryGet("u", nil); res.Cas() == locked.Cas() {
+		t.Fatal("expected a plain get of a locked document not to reveal the lock cas")
+	}
+	if _, err = c.TryUpsert("u", user{Name: "bob"}, nil); !errors.Is(err, gocb.ErrDocumentLocked) {
+		t.Fatalf("expected an upsert of a locked document to fail with ErrDocumentLocked, got %v", err)
+	}
+	if _, err = c.TryReplace("u", user{Name: "bob"}, nil); !errors.Is(err, gocb.ErrDocumentLocked) {
+		t.Fatalf("expected a replace without the lock cas to fail with ErrDocumentLocked, got %v", err)
+	}
+	if err = c.TryUnlock("u", locked.Cas()+1, nil); !errors.Is(err, gocb.ErrCasMismatch) {
+		t.Fatalf("expected an unlock with the wrong cas to fail with ErrCasMismatch, got %v", err)
+	}
+
+	// the lock lapses by itself
+	clock.Advance(5 * time.Second)
+	if err = c.TryUnlock("u", locked.Cas(), nil); !errors.Is(err, gocb.ErrDocumentNotLocked) {
+		t.Fatalf("expected unlocking a lapsed lock to fail with ErrDocumentNotLocked, got %v", err)
+	}
+
+	// and is released by a mutation made with its cas
+	locked, err = c.TryGetAndLock("u", 5*time.Second, nil)
+	if err != nil {
+		t.Fatalf("unexpected lock error: %v", err)
+	}
+	if _, err = c.TryReplace("u", user{Name: "bob"}, &gocb.ReplaceOptions{Cas: locked.Cas()}); err != nil {
+		t.Fatalf("expected a replace with the lock cas to succeed, got %v", err)
+	}
+	if _, err = c.TryUpsert("u", user{Name: "cat"}, nil); err != nil {
+		t.Fatalf("expected the replace to have released the lock, got %v", err)
+	}
+}
+
+func TestCollectionCounters(t *testing.T) {
+	c := NewCollection(NewFakeClock(epoch))
+	if _, err := c.TryIncrement("missing", &gocb.IncrementOptions{Delta: 1, Initial: -1}); !errors.Is(err, gocb.ErrDocumentNotFound) {
+		t.Fatalf("expected a negative initial not to create the counter, got %v", err)
+	}
+
+	steps := []struct {
+		incr  bool
+		delta uint64
+		want  uint64
+	}{
+		{incr: true, delta: 5, want: 10}, // created with the initial value, the delta not applied
+		{incr: true, delta: 5, want: 15},
+		{incr: false, delta: 3, want: 12},
+		{incr: false, delta: 20, want: 0}, // decrements stop at zero
+	}
+	for i, step := range steps {
+		var (
+			res *gocb.CounterResult
+			err error
+		)
+		if step.incr {
+			res, err = c.TryIncrement("n", &gocb.IncrementOptions{Delta: step.delta, Initial: 10})
+		} else {
+			res, err = c.TryDecrement("n", &gocb.DecrementOptions{Delta: step.delta, Initial: 10})
+		}
+		if err != nil {
+			t.Fatalf("step %d: unexpected error: %v", i, err)
+		}
+		if res.Content() != step.want {
+			t.Fatalf("step %d: expected %d, got %d", i, step.want, res.Content())
+		}
+	}
+
+	var n uint64
+	if _, err := c.TryGetContent("n", &n, nil); err != nil || n != 0 {
+		t.Fatalf("expected the stored counter to read 0, got %d, %v", n, err)
+	}
+
+	if _, err := c.TryUpsert("s", user{Name: "ann"}, nil); err != nil {
+		t.Fatalf("unexpected upsert error: %v", err)
+	}
+	if _, err := c.TryIncrement("s", &gocb.IncrementOptions{Delta: 1}); !errors.Is(err, gocb.ErrDeltaInvalid) {
+		t.Fatalf("expected incrementing a non-numeric document to fail with ErrDeltaInvalid, got %v", err)
+	}
+}
+
+func TestCollectionLookupIn(t *testing.T) {
+	c := NewCollection(NewFakeClock(epoch))
+	doc := map[string]interface{}{
+		"name":    "ann",
+		"tags":    []string{"a", "b", "c"},
+		"address": map[string]interface{}{"city": "Austin", "zip": "78701"},
+	}
+	mut, err := c.TryUpsert("u", doc, nil)
+	if err != nil {
+		t.Fatalf("unexpected upsert error: %v", err)
+	}
+
+	res, err := c.TryLookupIn("u", []gocb.LookupInSpec{
+		gocb.GetSpec("name", nil),
+		gocb.GetSpec("address.city", nil),
+		gocb.GetSpec("tags[1]", nil),
+		gocb.ExistsSpec("address.zip", nil),
+		gocb.ExistsSpec("missing", nil),
+		gocb.CountSpec("tags", nil),
+		gocb.GetSpec("missing", nil),
+	}, nil)
+	if err != nil {
+		t.Fatalf("unexpected lookup error: %v", err)
+	}
+	if res.Cas() != mut.Cas() {
+		t.Fatalf("expected cas %d, got %d", mut.Cas(), res.Cas())
+	}
+
+	for i, want := range []string{"ann", "Austin", "b"} {
+		var got string
+		if err = res.ContentAt(uint(i), &got); err != nil || got != want {
+			t.Fatalf("spec %d: expected %q, got %q, %v", i, want, got, err)
+		}
+	}
+	if !res.Exists(3) || res.Exists(4) {
+		t.Fatalf("expected address.zip to exist and missing not to, got %t and %t", res.Exists(3), res.Exists(4))
+	}
+	var count int
+	if err = res.ContentAt(5, &count); err != nil || count != 3 {
+		t.Fatalf("expected a count of 3, got %d, %v", count, err)
+	}
+	var missing string
+	if err = res.ContentAt(6, &missing); !errors.Is(err, gocb.ErrPathNotFound) {
+		t.Fatalf("expected getting a missing path to fail with ErrPathNotFound, got %v", err)
+	}
+}
+
+func TestCollectionResultDecoding(t *testing.T) {
+	c := NewCollection(NewFakeClock(epoch))
+	in := user{Name: "ann", Tags: []string{"a"}, Age: 30}
+	if _, err := c.TryUpsert("u", in, nil); err != nil {
+		t.Fatalf("unexpected upsert error: %v", err)
+	}
+
+	res, err := c.TryGet("u", nil)
+	if err != nil {
+		t.Fatalf("unexpected get error: %v", err)
+	}
+	var out user
+	if err = res.Content(&out); err != nil || !reflect.DeepEqual(out, in) {
+		t.Fatalf("expected %+v, got %+v, %v", in, out, err)
+	}
+	if res.Expiry() != nil {
+		t.Fatalf("expected no expiry without WithExpiry, got %v", res.Expiry())
+	}
+
+	res, err = c.TryGet("u", &gocb.GetOptions{Project: []string{"name", "age"}})
+	if err != nil {
+		t.Fatalf("unexpected projected get error: %v", err)
+	}
+	out = user{}
+	if err = res.Content(&out); err != nil || !reflect.DeepEqual(out, user{Name: "ann", Age: 30}) {
+		t.Fatalf("expected only the projected fields, got %+v, %v", out, err)
+	}
+
+	if _, err = c.TryUpsert("raw", []byte("not json"), &gocb.UpsertOptions{Transcoder: gocb.NewRawBinaryTranscoder()}); err != nil {
+		t.Fatalf("unexpected raw upsert error: %v", err)
+	}
+	res, err = c.TryGet("raw", &gocb.GetOptions{Transcoder: gocb.NewRawBinaryTranscoder()})
+	if err != nil {
+		t.Fatalf("unexpected raw get error: %v", err)
+	}
+	var raw []byte
+	if err = res.Content(&raw); err != nil || string(raw) != "not json" {
+		t.Fatalf("expected the raw bytes back, got %q, %v", raw, err)
+	}
+}
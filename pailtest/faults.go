@@ -0,0 +1,278 @@
+package pailtest
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	cbsearch "github.com/couchbase/gocb/v2/search"
+	"github.com/myENA/pail/v2"
+)
+
+// Fault describes the outcome injected into a single attempt.
+type Fault struct {
+	// Latency is waited before the attempt proceeds.
+	Latency time.Duration
+	// Err is returned in place of the attempt's own outcome.  A nil Err only injects Latency.
+	Err error
+	// Ambiguous causes the underlying operation to be performed before Err is returned, as happens when a mutation
+	// is applied but its response is lost.  Err defaults to gocb.ErrAmbiguousTimeout.
+	Ambiguous bool
+}
+
+// FailWith returns a fault which fails the attempt with err without performing it.
+func FailWith(err error) Fault {
+	return Fault{Err: err}
+}
+
+// FaultInjector decides the fault, if any, injected into each attempt it sees.  Queued faults are used first, in
+// order, after which each attempt is faulted with the configured probability.  Given the same seed, the same
+// sequence of attempts is faulted identically.  It is safe for concurrent use.
+type FaultInjector struct {
+	mu          sync.Mutex
+	queue       []Fault
+	probability float64
+	probFault   Fault
+	latency     time.Duration
+	rnd         *rand.Rand
+	clock       pail.Clock
+	attempts    []time.Time
+	injected    int
+}
+
+func NewFaultInjector(seed int64) *FaultInjector {
+	return &FaultInjector{rnd: rand.New(rand.NewSource(seed)), clock: pail.SystemClock}
+}
+
+// WithClock sets the clock used to wait out injected latency and to time attempts.  Sharing a FakeClock with the
+// code under test allows latency and backoff to be asserted without waiting.
+func (fi *FaultInjector) WithClock(clock pail.Clock) *FaultInjector {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	fi.clock = clock
+	return fi
+}
+
+// Then queues faults for the next attempts, one per attempt.
+func (fi *FaultInjector) Then(faults ...Fault) *FaultInjector {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	fi.queue = append(fi.queue, faults...)
+	return fi
+}
+
+// FailTimes queues n attempts failing with err.
+func (fi *FaultInjector) FailTimes(n int, err error) *FaultInjector {
+	faults := make([]Fault, n)
+	for i := range faults {
+		faults[i] = FailWith(err)
+	}
+	return fi.Then(faults...)
+}
+
+// WithProbability faults attempts with f with probability p, once any queued faults have been used.
+func (fi *FaultInjector) WithProbability(p float64, f Fault) *FaultInjector {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	fi.probability, fi.probFault = p, f
+	return fi
+}
+
+// WithLatency adds d to the latency of every attempt.
+func (fi *FaultInjector) WithLatency(d time.Duration) *FaultInjector {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	fi.latency = d
+	return fi
+}
+
+func (fi *FaultInjector) next() (Fault, pail.Clock) {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	fi.attempts = append(fi.attempts, fi.clock.Now())
+	var f Fault
+	if len(fi.queue) > 0 {
+		f, fi.queue = fi.queue[0], fi.queue[1:]
+	} else if fi.probability > 0 && fi.rnd.Float64() < fi.probability {
+		f = fi.probFault
+	}
+	if f.Err != nil || f.Ambiguous {
+		fi.injected++
+	}
+	f.Latency += fi.latency
+	return f, fi.clock
+}
+
+// Inject runs a single attempt of fn, subject to the next fault.
+func (fi *FaultInjector) Inject(fn func() error) error {
+	f, clock := fi.next()
+	if f.Latency > 0 {
+		_ = clock.Sleep(context.Background(), f.Latency)
+	}
+	if f.Ambiguous {
+		_ = fn()
+		if f.Err == nil {
+			return gocb.ErrAmbiguousTimeout
+		}
+		return f.Err
+	}
+	if f.Err != nil {
+		return f.Err
+	}
+	return fn()
+}
+
+// Attempts returns the number of attempts seen.
+func (fi *FaultInjector) Attempts() int {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	return len(fi.attempts)
+}
+
+// Injected returns the number of attempts which were failed.
+func (fi *FaultInjector) Injected() int {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	return fi.injected
+}
+
+// Intervals returns the time elapsed between the start of each attempt and the next, allowing backoff to be asserted.
+func (fi *FaultInjector) Intervals() []time.Duration {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	if len(fi.attempts) < 2 {
+		return nil
+	}
+	out := make([]time.Duration, len(fi.attempts)-1)
+	for i := range out {
+		out[i] = fi.attempts[i+1].Sub(fi.attempts[i])
+	}
+	return out
+}
+
+// WrapRetryFunc returns fn with fi's faults injected into each attempt.  It accepts any of pail's retry func types,
+// e.g. a pail.CollectionRetryFunc handed to Collection.GetOptions.  As a faulted attempt need not reach fn, the
+// resulting retry context may be exercised without a cluster by calling its Try with a nil target.
+func WrapRetryFunc[T any](fi *FaultInjector, fn func(T) error) func(T) error {
+	return func(target T) error {
+		return fi.Inject(func() error { return fn(target) })
+	}
+}
+
+// FaultyKV injects faults into every call made to KV.
+type FaultyKV struct {
+	pail.KV
+	Injector *FaultInjector
+}
+
+var _ pail.KV = (*FaultyKV)(nil)
+
+func NewFaultyKV(kv pail.KV, fi *FaultInjector) *FaultyKV {
+	return &FaultyKV{KV: kv, Injector: fi}
+}
+
+func (k *FaultyKV) TryGet(id string, opts *gocb.GetOptions) (res *gocb.GetResult, err error) {
+	injErr := k.Injector.Inject(func() error { res, err = k.KV.TryGet(id, opts); return err })
+	return faultResult(res, err, injErr)
+}
+
+func (k *FaultyKV) TryGetContent(id string, ptr interface{}, opts *gocb.GetOptions) (res *gocb.GetResult, err error) {
+	injErr := k.Injector.Inject(func() error { res, err = k.KV.TryGetContent(id, ptr, opts); return err })
+	return faultResult(res, err, injErr)
+}
+
+func (k *FaultyKV) TryGetAndLock(id string, lockTime time.Duration, opts *gocb.GetAndLockOptions) (res *gocb.GetResult, err error) {
+	injErr := k.Injector.Inject(func() error { res, err = k.KV.TryGetAndLock(id, lockTime, opts); return err })
+	return faultResult(res, err, injErr)
+}
+
+func (k *FaultyKV) TryUnlock(id string, cas gocb.Cas, opts *gocb.UnlockOptions) error {
+	return k.Injector.Inject(func() error { return k.KV.TryUnlock(id, cas, opts) })
+}
+
+func (k *FaultyKV) TryLookupIn(id string, ops []gocb.LookupInSpec, opts *gocb.LookupInOptions) (res *gocb.LookupInResult, err error) {
+	injErr := k.Injector.Inject(func() error { res, err = k.KV.TryLookupIn(id, ops, opts); return err })
+	return faultResult(res, err, injErr)
+}
+
+func (k *FaultyKV) TryTouch(id string, expiry time.Duration, opts *gocb.TouchOptions) (res *gocb.MutationResult, err error) {
+	injErr := k.Injector.Inject(func() error { res, err = k.KV.TryTouch(id, expiry, opts); return err })
+	return faultResult(res, err, injErr)
+}
+
+func (k *FaultyKV) TryUpsert(id string, value interface{}, opts *gocb.UpsertOptions) (res *gocb.MutationResult, err error) {
+	injErr := k.Injector.Inject(func() error { res, err = k.KV.TryUpsert(id, value, opts); return err })
+	return faultResult(res, err, injErr)
+}
+
+func (k *FaultyKV) TryInsert(id string, value interface{}, opts *gocb.InsertOptions) (res *gocb.MutationResult, err error) {
+	injErr := k.Injector.Inject(func() error { res, err = k.KV.TryInsert(id, value, opts); return err })
+	return faultResult(res, err, injErr)
+}
+
+func (k *FaultyKV) TryReplace(id string, value interface{}, opts *gocb.ReplaceOptions) (res *gocb.MutationResult, err error) {
+	injErr := k.Injector.Inject(func() error { res, err = k.KV.TryReplace(id, value, opts); return err })
+	return faultResult(res, err, injErr)
+}
+
+func (k *FaultyKV) TryRemove(id string, opts *gocb.RemoveOptions) (res *gocb.MutationResult, err error) {
+	injErr := k.Injector.Inject(func() error { res, err = k.KV.TryRemove(id, opts); return err })
+	return faultResult(res, err, injErr)
+}
+
+func (k *FaultyKV) TryIncrement(id string, opts *gocb.IncrementOptions) (res *gocb.CounterResult, err error) {
+	injErr := k.Injector.Inject(func() error { res, err = k.KV.TryIncrement(id, opts); return err })
+	return faultResult(res, err, injErr)
+}
+
+func (k *FaultyKV) TryDecrement(id string, opts *gocb.DecrementOptions) (res *gocb.CounterResult, err error) {
+	injErr := k.Injector.Inject(func() error { res, err = k.KV.TryDecrement(id, opts); return err })
+	return faultResult(res, err, injErr)
+}
+
+func (k *FaultyKV) TryAppend(id string, value []byte, opts *gocb.AppendOptions) (res *gocb.MutationResult, err error) {
+	injErr := k.Injector.Inject(func() error { res, err = k.KV.TryAppend(id, value, opts); return err })
+	return faultResult(res, err, injErr)
+}
+
+func (k *FaultyKV) TryPrepend(id string, value []byte, opts *gocb.PrependOptions) (res *gocb.MutationResult, err error) {
+	injErr := k.Injector.Inject(func() error { res, err = k.KV.TryPrepend(id, value, opts); return err })
+	return faultResult(res, err, injErr)
+}
+
+// FaultyQuerier injects faults into every query made to Querier.
+type FaultyQuerier struct {
+	pail.Querier
+	Injector *FaultInjector
+}
+
+var _ pail.Querier = (*FaultyQuerier)(nil)
+
+func (q *FaultyQuerier) TryQuery(statement string, opts *gocb.QueryOptions) (res *gocb.QueryResult, err error) {
+	injErr := q.Injector.Inject(func() error { res, err = q.Querier.TryQuery(statement, opts); return err })
+	return faultResult(res, err, injErr)
+}
+
+// FaultySearcher injects faults into every search made to Searcher.
+type FaultySearcher struct {
+	pail.Searcher
+	Injector *FaultInjector
+}
+
+var _ pail.Searcher = (*FaultySearcher)(nil)
+
+func (s *FaultySearcher) TrySearchQuery(indexName string, query cbsearch.Query, opts *gocb.SearchOptions) (res *gocb.SearchResult, err error) {
+	injErr := s.Injector.Inject(func() error { res, err = s.Searcher.TrySearchQuery(indexName, query, opts); return err })
+	return faultResult(res, err, injErr)
+}
+
+// faultResult returns the outcome of a wrapped call, with any injected error taking precedence over the result.
+func faultResult[T any](res T, err, injErr error) (T, error) {
+	if injErr != nil {
+		var zero T
+		return zero, injErr
+	}
+	return res, err
+}
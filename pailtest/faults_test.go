@@ -0,0 +1,188 @@
+package pailtest
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/myENA/pail/v2"
+)
+
+// retryPolicy returns policy as handed out by a wrapper using clock
+func retryPolicy(clock pail.Clock, policy pail.RetryPolicy) pail.RetryPolicy {
+	return pail.NewCluster(nil, 0, 0).WithClock(clock).WithPolicy(policy).OperationPolicy(pail.OperationUpsert)
+}
+
+// upsertWithRetries upserts a document into kv through pail.Do, returning the retry context's stats
+func upsertWithRetries(kv pail.KV, policy pail.RetryPolicy) (pail.RetryStats, error) {
+	var rc *pail.RetryContext
+	_, err := pail.Do(policy, func(ctx *pail.RetryContext) (*gocb.MutationResult, error) {
+		rc = ctx
+		return kv.TryUpsert("doc", user{Name: "ann"}, nil)
+	})
+	return rc.Stats(), err
+}
+
+func TestFaultSequence(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	clock.SetAutoAdvance(true)
+	var (
+		fi     = NewFaultInjector(1).WithClock(clock).FailTimes(2, gocb.ErrTimeout)
+		c      = NewCollection(clock)
+		policy = retryPolicy(clock, pail.RetryPolicy{Retries: 3, Delay: 10 * time.Millisecond})
+	)
+
+	stats, err := upsertWithRetries(NewFaultyKV(c, fi), policy)
+	if err != nil {
+		t.Fatalf("expected the third attempt to succeed, got %v", err)
+	}
+	if fi.Attempts() != 3 || fi.Injected() != 2 || stats.Attempts != 3 {
+		t.Fatalf("expected 3 attempts of which 2 faulted, saw %d and %d, stats %+v", fi.Attempts(), fi.Injected(), stats)
+	}
+	if want := []time.Duration{10 * time.Millisecond, 10 * time.Millisecond}; !reflect.DeepEqual(fi.Intervals(), want) {
+		t.Fatalf("expected intervals %v, got %v", want, fi.Intervals())
+	}
+	if c.Len() != 1 {
+		t.Fatal("expected the successful attempt to have been applied")
+	}
+
+	// once the queue exceeds the budget the last injected error is returned
+	fi.FailTimes(5, gocb.ErrOverload)
+	if _, err = upsertWithRetries(NewFaultyKV(c, fi), policy); !errors.Is(err, gocb.ErrOverload) {
+		t.Fatalf("expected the retries to be exhausted by ErrOverload, got %v", err)
+	}
+	if fi.Attempts() != 7 {
+		t.Fatalf("expected 4 further attempts, saw %d in total", fi.Attempts())
+	}
+}
+
+func TestFaultLatencyAndBackoff(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	clock.SetAutoAdvance(true)
+	fi := NewFaultInjector(1).WithClock(clock).WithLatency(5*time.Millisecond).FailTimes(3, gocb.ErrOverload)
+	policy := retryPolicy(clock, pail.RetryPolicy{
+		Retries: 5,
+		Backoff: pail.ExponentialBackoff(10*time.Millisecond, time.Second, 2),
+	})
+
+	stats, err := upsertWithRetries(NewFaultyKV(NewCollection(clock), fi), policy)
+	if err != nil {
+		t.Fatalf("expected the fourth attempt to succeed, got %v", err)
+	}
+	// each interval is the attempt's injected latency followed by the backoff before the next
+	want := []time.Duration{15 * time.Millisecond, 25 * time.Millisecond, 45 * time.Millisecond}
+	if !reflect.DeepEqual(fi.Intervals(), want) {
+		t.Fatalf("expected intervals %v, got %v", want, fi.Intervals())
+	}
+	if stats.Elapsed != 90*time.Millisecond {
+		t.Fatalf("expected 90ms elapsed, got %s", stats.Elapsed)
+	}
+	sleeps := []time.Duration{
+		5 * time.Millisecond, 10 * time.Millisecond,
+		5 * time.Millisecond, 20 * time.Millisecond,
+		5 * time.Millisecond, 40 * time.Millisecond,
+		5 * time.Millisecond,
+	}
+	if !reflect.DeepEqual(clock.Sleeps(), sleeps) {
+		t.Fatalf("expected sleeps %v, got %v", sleeps, clock.Sleeps())
+	}
+}
+
+func TestFaultProbabilityIsDeterministic(t *testing.T) {
+	outcomes := func(seed int64, p float64) []bool {
+		fi := NewFaultInjector(seed).WithProbability(p, FailWith(gocb.ErrTimeout))
+		out := make([]bool, 200)
+		for i := range out {
+			out[i] = fi.Inject(func() error { return nil }) != nil
+		}
+		if n := fi.Injected(); n != count(out) {
+			t.Fatalf("expected Injected to match the %d failed attempts, got %d", count(out), n)
+		}
+		return out
+	}
+
+	first, second := outcomes(42, 0.3), outcomes(42, 0.3)
+	if !reflect.DeepEqual(first, second) {
+		t.Fatal("expected the same seed to fault the same attempts")
+	}
+	if n := count(first); n < 30 || n > 90 {
+		t.Fatalf("expected roughly 60 of 200 attempts to fail, got %d", n)
+	}
+	if n := count(outcomes(42, 0)); n != 0 {
+		t.Fatalf("expected no failures with probability 0, got %d", n)
+	}
+	if n := count(outcomes(42, 1)); n != 200 {
+		t.Fatalf("expected every attempt to fail with probability 1, got %d", n)
+	}
+
+	// queued faults are used before the probability applies
+	fi := NewFaultInjector(42).WithProbability(1, FailWith(gocb.ErrTimeout)).Then(Fault{})
+	if err := fi.Inject(func() error { return nil }); err != nil {
+		t.Fatalf("expected the queued no-op fault to be used first, got %v", err)
+	}
+	if err := fi.Inject(func() error { return nil }); !errors.Is(err, gocb.ErrTimeout) {
+		t.Fatalf("expected the probability to apply once the queue is empty, got %v", err)
+	}
+}
+
+func count(outcomes []bool) int {
+	n := 0
+	for _, failed := range outcomes {
+		if failed {
+			n++
+		}
+	}
+	return n
+}
+
+func TestFaultAmbiguous(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	clock.SetAutoAdvance(true)
+	var (
+		c      = NewCollection(clock)
+		fi     = NewFaultInjector(1).WithClock(clock).Then(Fault{Ambiguous: true})
+		kv     = NewFaultyKV(c, fi)
+		policy = retryPolicy(clock, pail.RetryPolicy{Retries: 3, Delay: time.Millisecond})
+		rc     *pail.RetryContext
+	)
+
+	// the insert is applied but reported as timing out, so its retry finds the document already present
+	_, err := pail.Do(policy, func(ctx *pail.RetryContext) (*gocb.MutationResult, error) {
+		rc = ctx
+		return kv.TryInsert("doc", user{Name: "ann"}, nil)
+	})
+	if !errors.Is(err, gocb.ErrDocumentExists) {
+		t.Fatalf("expected the retry to find the ambiguously applied insert, got %v", err)
+	}
+	if rc.Stats().Attempts != 2 || fi.Attempts() != 2 || fi.Injected() != 1 {
+		t.Fatalf("expected 2 attempts of which 1 faulted, got stats %+v, %d and %d", rc.Stats(), fi.Attempts(), fi.Injected())
+	}
+	if c.Len() != 1 {
+		t.Fatalf("expected exactly one document, got %d", c.Len())
+	}
+
+	fi.Then(Fault{Ambiguous: true, Err: gocb.ErrDurabilityAmbiguous})
+	if _, err = kv.TryRemove("doc", nil); !errors.Is(err, gocb.ErrDurabilityAmbiguous) {
+		t.Fatalf("expected the fault's own error, got %v", err)
+	}
+	if c.Len() != 0 {
+		t.Fatal("expected the ambiguous remove to have been applied")
+	}
+}
+
+func TestWrapRetryFunc(t *testing.T) {
+	fi := NewFaultInjector(1).FailTimes(2, gocb.ErrTimeout)
+	var calls int
+	rc := pail.NewSimpleCollectionRetryContext(3, 0, nil, WrapRetryFunc(fi, func(*gocb.Collection) error {
+		calls++
+		return nil
+	}))
+	if err := rc.Try(nil); err != nil {
+		t.Fatalf("expected the third attempt to succeed, got %v", err)
+	}
+	if calls != 1 || fi.Attempts() != 3 || rc.Attempts() != 3 {
+		t.Fatalf("expected 3 attempts reaching fn once, got %d calls, %d and %d attempts", calls, fi.Attempts(), rc.Attempts())
+	}
+}
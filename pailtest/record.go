@@ -0,0 +1,548 @@
+package pailtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/myENA/pail/v2"
+)
+
+const recordingVersion = 1
+
+// Recording is the golden file form of a sequence of interactions.
+type Recording struct {
+	Version      int           `json:"version"`
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is a single recorded call.  Key is the document id of key-value operations and the statement of
+// queries.  Value is the encoded payload of writes, and Content that of reads.  Payloads which are not themselves
+// JSON are held base64 encoded.
+type Interaction struct {
+	Op      string                 `json:"op"`
+	Key     string                 `json:"key"`
+	Options map[string]interface{} `json:"options,omitempty"`
+	Value   *Payload               `json:"value,omitempty"`
+	Specs   []LookupSpec           `json:"specs,omitempty"`
+
+	Content  *Payload          `json:"content,omitempty"`
+	Flags    uint32            `json:"flags,omitempty"`
+	Cas      uint64            `json:"cas,omitempty"`
+	Expiry   *time.Time        `json:"expiry,omitempty"`
+	Counter  uint64            `json:"counter,omitempty"`
+	Lookups  []LookupOutcome   `json:"lookups,omitempty"`
+	Rows     []json.RawMessage `json:"rows,omitempty"`
+	MetaData json.RawMessage   `json:"metadata,omitempty"`
+	Err      *RecordedError    `json:"error,omitempty"`
+}
+
+// Payload is document content, held as JSON where possible so that golden files remain readable.
+type Payload struct {
+	JSON   json.RawMessage `json:"json,omitempty"`
+	Binary []byte          `json:"binary,omitempty"`
+}
+
+func newPayload(b []byte) *Payload {
+	if b == nil {
+		return nil
+	}
+	if json.Valid(b) {
+		return &Payload{JSON: b}
+	}
+	return &Payload{Binary: b}
+}
+
+func (p *Payload) Bytes() []byte {
+	if p == nil {
+		return nil
+	}
+	if p.JSON != nil {
+		return p.JSON
+	}
+	return p.Binary
+}
+
+// equal compares payloads, ignoring the indentation a golden file adds to JSON payloads.
+func (p *Payload) equal(other *Payload) bool {
+	a, b := p.Bytes(), other.Bytes()
+	if p != nil && other != nil && p.JSON != nil && other.JSON != nil {
+		var ca, cb bytes.Buffer
+		if json.Compact(&ca, a) == nil && json.Compact(&cb, b) == nil {
+			a, b = ca.Bytes(), cb.Bytes()
+		}
+	}
+	return bytes.Equal(a, b)
+}
+
+type LookupSpec struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Xattr bool   `json:"xattr,omitempty"`
+}
+
+type LookupOutcome struct {
+	Data json.RawMessage `json:"data,omitempty"`
+	Err  *RecordedError  `json:"error,omitempty"`
+}
+
+// RecordedError is an error as recorded.  Kind names the gocb sentinel the error matched, if any, so that a replayed
+// error still satisfies errors.Is.
+type RecordedError struct {
+	Message string `json:"message"`
+	Kind    string `json:"kind,omitempty"`
+}
+
+var recordedSentinels = map[string]error{
+	"DocumentNotFound":    gocb.ErrDocumentNotFound,
+	"DocumentExists":      gocb.ErrDocumentExists,
+	"DocumentLocked":      gocb.ErrDocumentLocked,
+	"DocumentNotLocked":   gocb.ErrDocumentNotLocked,
+	"DocumentNotJSON":     gocb.ErrDocumentNotJSON,
+	"CasMismatch":         gocb.ErrCasMismatch,
+	"DeltaInvalid":        gocb.ErrDeltaInvalid,
+	"PathNotFound":        gocb.ErrPathNotFound,
+	"PathMismatch":        gocb.ErrPathMismatch,
+	"PathInvalid":         gocb.ErrPathInvalid,
+	"ValueTooLarge":       gocb.ErrValueTooLarge,
+	"AmbiguousTimeout":    gocb.ErrAmbiguousTimeout,
+	"UnambiguousTimeout":  gocb.ErrUnambiguousTimeout,
+	"Timeout":             gocb.ErrTimeout,
+	"Overload":            gocb.ErrOverload,
+	"TemporaryFailure":    gocb.ErrTemporaryFailure,
+	"RequestCanceled":     gocb.ErrRequestCanceled,
+	"ParsingFailure":      gocb.ErrParsingFailure,
+	"PlanningFailure":     gocb.ErrPlanningFailure,
+	"IndexNotFound":       gocb.ErrIndexNotFound,
+	"IndexExists":         gocb.ErrIndexExists,
+	"InvalidArgument":     gocb.ErrInvalidArgument,
+	"FeatureNotAvailable": gocb.ErrFeatureNotAvailable,
+}
+
+// recordedSentinelOrder checks the more specific sentinels first, e.g. AmbiguousTimeout ahead of Timeout
+var recordedSentinelOrder = []string{
+	"DocumentNotFound", "DocumentExists", "DocumentLocked", "DocumentNotLocked", "DocumentNotJSON", "CasMismatch",
+	"DeltaInvalid", "PathNotFound", "PathMismatch", "PathInvalid", "ValueTooLarge", "AmbiguousTimeout",
+	"UnambiguousTimeout", "Timeout", "Overload", "TemporaryFailure", "RequestCanceled", "ParsingFailure",
+	"PlanningFailure", "IndexNotFound", "IndexExists", "InvalidArgument", "FeatureNotAvailable",
+}
+
+func recordError(err error) *RecordedError {
+	if err == nil {
+		return nil
+	}
+	re := &RecordedError{Message: err.Error()}
+	for _, kind := range recordedSentinelOrder {
+		if errors.Is(err, recordedSentinels[kind]) {
+			re.Kind = kind
+			break
+		}
+	}
+	return re
+}
+
+type replayedError struct {
+	msg      string
+	sentinel error
+}
+
+func (e *replayedError) Error() string { return e.msg }
+func (e *replayedError) Unwrap() error { return e.sentinel }
+
+// Err reconstructs the recorded error.
+func (re *RecordedError) Err() error {
+	if re == nil {
+		return nil
+	}
+	return &replayedError{msg: re.Message, sentinel: recordedSentinels[re.Kind]}
+}
+
+// LoadRecording reads a golden file written by Recorder.Save.
+func LoadRecording(path string) (*Recording, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rec := new(Recording)
+	if err = json.Unmarshal(b, rec); err != nil {
+		return nil, fmt.Errorf("parsing recording %s: %w", path, err)
+	}
+	if rec.Version != recordingVersion {
+		return nil, fmt.Errorf("recording %s has unsupported version %d", path, rec.Version)
+	}
+	return rec, nil
+}
+
+// normalizeOptions round trips options through JSON so that recorded and live options compare equal
+func normalizeOptions(opts map[string]interface{}) map[string]interface{} {
+	if len(opts) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(opts)
+	if err != nil {
+		return map[string]interface{}{"unencodable": err.Error()}
+	}
+	var out map[string]interface{}
+	_ = json.Unmarshal(b, &out)
+	return out
+}
+
+func lookupSpecs(ops []gocb.LookupInSpec) []LookupSpec {
+	specs := make([]LookupSpec, len(ops))
+	for i, spec := range ops {
+		path, xattr := specPath(spec)
+		specs[i] = LookupSpec{Op: lookupOpName(specOp(spec)), Path: path, Xattr: xattr}
+	}
+	return specs
+}
+
+func lookupOpName(op lookupOp) string {
+	switch op {
+	case lookupGet:
+		return "get"
+	case lookupExists:
+		return "exists"
+	case lookupCount:
+		return "count"
+	}
+	return fmt.Sprintf("op-%#x", uint64(op))
+}
+
+func encodedValue(transcoder gocb.Transcoder, value interface{}) *Payload {
+	b, _, err := encode(transcoder, value)
+	if err != nil {
+		return nil
+	}
+	return newPayload(b)
+}
+
+// Recorder decorates a KV and Querier, typically a *pail.Collection and *pail.Cluster, recording every call made
+// through it.  Either may be nil if only the other is used.
+type Recorder struct {
+	KV      pail.KV
+	Querier pail.Querier
+
+	mu           sync.Mutex
+	interactions []Interaction
+}
+
+var (
+	_ pail.KV      = (*Recorder)(nil)
+	_ pail.Querier = (*Recorder)(nil)
+)
+
+func NewRecorder(kv pail.KV, querier pail.Querier) *Recorder {
+	return &Recorder{KV: kv, Querier: querier}
+}
+
+func (r *Recorder) record(in Interaction, err error) {
+	in.Options = normalizeOptions(in.Options)
+	in.Err = recordError(err)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interactions = append(r.interactions, in)
+}
+
+// Recording returns everything recorded so far.
+func (r *Recorder) Recording() *Recording {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]Interaction, len(r.interactions))
+	copy(out, r.interactions)
+	return &Recording{Version: recordingVersion, Interactions: out}
+}
+
+func (r *Recorder) WriteTo(w io.Writer) (int64, error) {
+	b, err := json.MarshalIndent(r.Recording(), "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(append(b, '\n'))
+	return int64(n), err
+}
+
+// Save writes everything recorded so far to the golden file at path.
+func (r *Recorder) Save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err = r.WriteTo(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func getInteraction(op, id string, res *gocb.GetResult) Interaction {
+	in := Interaction{Op: op, Key: id}
+	if res == nil {
+		return in
+	}
+	v := reflect.ValueOf(res).Elem()
+	in.Content = newPayload(field(v, "contents").Bytes())
+	in.Flags = uint32(field(v, "flags").Uint())
+	in.Cas = uint64(res.Cas())
+	if expiry, _ := field(v, "expiryTime").Interface().(*time.Time); expiry != nil {
+		e := *expiry
+		in.Expiry = &e
+	}
+	return in
+}
+
+func (r *Recorder) TryGet(id string, opts *gocb.GetOptions) (*gocb.GetResult, error) {
+	res, err := r.KV.TryGet(id, opts)
+	in := getInteraction("get", id, res)
+	if opts != nil {
+		in.Options = map[string]interface{}{"with_expiry": opts.WithExpiry, "project": opts.Project}
+	}
+	r.record(in, err)
+	return res, err
+}
+
+func (r *Recorder) TryGetContent(id string, ptr interface{}, opts *gocb.GetOptions) (*gocb.GetResult, error) {
+	res, err := r.TryGet(id, opts)
+	if err != nil {
+		return nil, err
+	}
+	return res, res.Content(ptr)
+}
+
+func (r *Recorder) TryGetAndLock(id string, lockTime time.Duration, opts *gocb.GetAndLockOptions) (*gocb.GetResult, error) {
+	res, err := r.KV.TryGetAndLock(id, lockTime, opts)
+	in := getInteraction("get_and_lock", id, res)
+	in.Options = map[string]interface{}{"lock_time": lockTime.String()}
+	r.record(in, err)
+	return res, err
+}
+
+func (r *Recorder) TryUnlock(id string, cas gocb.Cas, opts *gocb.UnlockOptions) error {
+	err := r.KV.TryUnlock(id, cas, opts)
+	r.record(Interaction{Op: "unlock", Key: id, Options: map[string]interface{}{"cas": uint64(cas)}}, err)
+	return err
+}
+
+func (r *Recorder) TryLookupIn(id string, ops []gocb.LookupInSpec, opts *gocb.LookupInOptions) (*gocb.LookupInResult, error) {
+	res, err := r.KV.TryLookupIn(id, ops, opts)
+	in := Interaction{Op: "lookup_in", Key: id, Specs: lookupSpecs(ops)}
+	if res != nil {
+		in.Cas = uint64(res.Cas())
+		contents := field(reflect.ValueOf(res).Elem(), "contents")
+		for i := 0; i < contents.Len(); i++ {
+			elem := contents.Index(i)
+			partialErr, _ := field(elem, "err").Interface().(error)
+			in.Lookups = append(in.Lookups, LookupOutcome{
+				Data: json.RawMessage(field(elem, "data").Bytes()),
+				Err:  recordError(partialErr),
+			})
+		}
+	}
+	r.record(in, err)
+	return res, err
+}
+
+func mutationInteraction(op, id string, res *gocb.MutationResult) Interaction {
+	in := Interaction{Op: op, Key: id}
+	if res != nil {
+		in.Cas = uint64(res.Cas())
+	}
+	return in
+}
+
+func (r *Recorder) TryTouch(id string, expiry time.Duration, opts *gocb.TouchOptions) (*gocb.MutationResult, error) {
+	res, err := r.KV.TryTouch(id, expiry, opts)
+	in := mutationInteraction("touch", id, res)
+	in.Options = map[string]interface{}{"expiry": expiry.String()}
+	r.record(in, err)
+	return res, err
+}
+
+func (r *Recorder) TryUpsert(id string, value interface{}, opts *gocb.UpsertOptions) (*gocb.MutationResult, error) {
+	if opts == nil {
+		opts = new(gocb.UpsertOptions)
+	}
+	res, err := r.KV.TryUpsert(id, value, opts)
+	in := mutationInteraction("upsert", id, res)
+	in.Value = encodedValue(opts.Transcoder, value)
+	in.Options = map[string]interface{}{"expiry": opts.Expiry.String(), "preserve_expiry": opts.PreserveExpiry}
+	r.record(in, err)
+	return res, err
+}
+
+func (r *Recorder) TryInsert(id string, value interface{}, opts *gocb.InsertOptions) (*gocb.MutationResult, error) {
+	if opts == nil {
+		opts = new(gocb.InsertOptions)
+	}
+	res, err := r.KV.TryInsert(id, value, opts)
+	in := mutationInteraction("insert", id, res)
+	in.Value = encodedValue(opts.Transcoder, value)
+	in.Options = map[string]interface{}{"expiry": opts.Expiry.String()}
+	r.record(in, err)
+	return res, err
+}
+
+func (r *Recorder) TryReplace(id string, value interface{}, opts *gocb.ReplaceOptions) (*gocb.MutationResult, error) {
+	if opts == nil {
+		opts = new(gocb.ReplaceOptions)
+	}
+	res, err := r.KV.TryReplace(id, value, opts)
+	in := mutationInteraction("replace", id, res)
+	in.Value = encodedValue(opts.Transcoder, value)
+	in.Options = map[string]interface{}{"expiry": opts.Expiry.String(), "preserve_expiry": opts.PreserveExpiry, "cas": uint64(opts.Cas)}
+	r.record(in, err)
+	return res, err
+}
+
+func (r *Recorder) TryRemove(id string, opts *gocb.RemoveOptions) (*gocb.MutationResult, error) {
+	if opts == nil {
+		opts = new(gocb.RemoveOptions)
+	}
+	res, err := r.KV.TryRemove(id, opts)
+	in := mutationInteraction("remove", id, res)
+	in.Options = map[string]interface{}{"cas": uint64(opts.Cas)}
+	r.record(in, err)
+	return res, err
+}
+
+func counterInteraction(op, id string, delta uint64, initial int64, res *gocb.CounterResult) Interaction {
+	in := Interaction{Op: op, Key: id, Options: map[string]interface{}{"delta": delta, "initial": initial}}
+	if res != nil {
+		in.Cas = uint64(res.Cas())
+		in.Counter = res.Content()
+	}
+	return in
+}
+
+func (r *Recorder) TryIncrement(id string, opts *gocb.IncrementOptions) (*gocb.CounterResult, error) {
+	if opts == nil {
+		opts = new(gocb.IncrementOptions)
+	}
+	res, err := r.KV.TryIncrement(id, opts)
+	r.record(counterInteraction("increment", id, opts.Delta, opts.Initial, res), err)
+	return res, err
+}
+
+func (r *Recorder) TryDecrement(id string, opts *gocb.DecrementOptions) (*gocb.CounterResult, error) {
+	if opts == nil {
+		opts = new(gocb.DecrementOptions)
+	}
+	res, err := r.KV.TryDecrement(id, opts)
+	r.record(counterInteraction("decrement", id, opts.Delta, opts.Initial, res), err)
+	return res, err
+}
+
+func (r *Recorder) TryAppend(id string, value []byte, opts *gocb.AppendOptions) (*gocb.MutationResult, error) {
+	if opts == nil {
+		opts = new(gocb.AppendOptions)
+	}
+	res, err := r.KV.TryAppend(id, value, opts)
+	in := mutationInteraction("append", id, res)
+	in.Value = newPayload(value)
+	in.Options = map[string]interface{}{"cas": uint64(opts.Cas)}
+	r.record(in, err)
+	return res, err
+}
+
+func (r *Recorder) TryPrepend(id string, value []byte, opts *gocb.PrependOptions) (*gocb.MutationResult, error) {
+	if opts == nil {
+		opts = new(gocb.PrependOptions)
+	}
+	res, err := r.KV.TryPrepend(id, value, opts)
+	in := mutationInteraction("prepend", id, res)
+	in.Value = newPayload(value)
+	in.Options = map[string]interface{}{"cas": uint64(opts.Cas)}
+	r.record(in, err)
+	return res, err
+}
+
+func queryOptions(opts *gocb.QueryOptions) map[string]interface{} {
+	if opts == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"positional_parameters": opts.PositionalParameters,
+		"named_parameters":      opts.NamedParameters,
+		"scan_consistency":      opts.ScanConsistency,
+		"readonly":              opts.Readonly,
+	}
+}
+
+// queryReader matches gocb's unexported row reader interface
+type queryReader interface {
+	NextRow() []byte
+	Err() error
+	MetaData() ([]byte, error)
+	Close() error
+	PreparedName() (string, error)
+	Endpoint() string
+}
+
+// TryQuery records the query's rows and metadata, reading the result in full before handing the caller an
+// equivalent result to iterate.
+func (r *Recorder) TryQuery(statement string, opts *gocb.QueryOptions) (*gocb.QueryResult, error) {
+	res, err := r.Querier.TryQuery(statement, opts)
+	in := Interaction{Op: "query", Key: statement, Options: queryOptions(opts)}
+	if err != nil {
+		r.record(in, err)
+		return nil, err
+	}
+	reader, _ := field(reflect.ValueOf(res).Elem(), "reader").Interface().(queryReader)
+	for res.Next() {
+		var row json.RawMessage
+		if err = res.Row(&row); err != nil {
+			break
+		}
+		in.Rows = append(in.Rows, append(json.RawMessage(nil), row...))
+	}
+	if err == nil {
+		err = res.Err()
+	}
+	if err == nil && reader != nil {
+		in.MetaData, err = reader.MetaData()
+	}
+	if closeErr := res.Close(); err == nil {
+		err = closeErr
+	}
+	r.record(in, err)
+	if err != nil {
+		return nil, err
+	}
+	return newQueryResult(in.Rows, in.MetaData), nil
+}
+
+// recordedRows replays recorded rows in place of gocb's row reader
+type recordedRows struct {
+	rows     []json.RawMessage
+	metaData []byte
+}
+
+func (rr *recordedRows) NextRow() []byte {
+	if len(rr.rows) == 0 {
+		return nil
+	}
+	row := rr.rows[0]
+	rr.rows = rr.rows[1:]
+	return row
+}
+
+func (rr *recordedRows) Err() error                    { return nil }
+func (rr *recordedRows) MetaData() ([]byte, error)     { return rr.metaData, nil }
+func (rr *recordedRows) Close() error                  { return nil }
+func (rr *recordedRows) PreparedName() (string, error) { return "", nil }
+func (rr *recordedRows) Endpoint() string              { return "" }
+
+func newQueryResult(rows []json.RawMessage, metaData []byte) *gocb.QueryResult {
+	reader := &recordedRows{rows: rows, metaData: metaData}
+	res := new(gocb.QueryResult)
+	v := reflect.ValueOf(res).Elem()
+	field(v, "reader").Set(reflect.ValueOf(reader))
+	setField(v, "nextRowBytes", reader.NextRow())
+	return res
+}
@@ -0,0 +1,227 @@
+package pailtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/myENA/pail/v2"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+const goldenRecording = "session.json"
+
+// staticQuerier answers every query with the same rows
+type staticQuerier struct {
+	rows []json.RawMessage
+}
+
+func (q staticQuerier) TryQuery(string, *gocb.QueryOptions) (*gocb.QueryResult, error) {
+	return newQueryResult(q.rows, []byte(`{"requestID":"req-1","status":"success"}`)), nil
+}
+
+// session makes a fixed sequence of calls, returning a description of every result so that a live run and its
+// replay may be compared
+func session(kv pail.KV, q pail.Querier) []string {
+	var out []string
+	note := func(format string, args ...interface{}) { out = append(out, fmt.Sprintf(format, args...)) }
+	noteErr := func(op string, err error) {
+		switch {
+		case errors.Is(err, gocb.ErrDocumentExists):
+			note("%s: exists", op)
+		case errors.Is(err, gocb.ErrCasMismatch):
+			note("%s: cas mismatch", op)
+		case err != nil:
+			note("%s: %v", op, err)
+		}
+	}
+
+	mut, err := kv.TryUpsert("u::1", user{Name: "ann", Tags: []string{"a", "b"}}, &gocb.UpsertOptions{Expiry: time.Hour})
+	noteErr("upsert", err)
+	_, err = kv.TryInsert("u::1", user{Name: "bob"}, nil)
+	noteErr("insert", err)
+
+	var u user
+	res, err := kv.TryGetContent("u::1", &u, &gocb.GetOptions{WithExpiry: true})
+	noteErr("get", err)
+	if res != nil {
+		note("get: %+v cas=%t expiry=%s", u, res.Cas() == mut.Cas(), res.ExpiryTime().UTC().Format(time.RFC3339))
+	}
+
+	_, err = kv.TryReplace("u::1", user{Name: "cat"}, &gocb.ReplaceOptions{Cas: mut.Cas() + 100})
+	noteErr("replace", err)
+
+	lookup, err := kv.TryLookupIn("u::1", []gocb.LookupInSpec{gocb.GetSpec("name", nil), gocb.CountSpec("tags", nil)}, nil)
+	noteErr("lookup", err)
+	if lookup != nil {
+		var (
+			name string
+			tags int
+		)
+		_ = lookup.ContentAt(0, &name)
+		_ = lookup.ContentAt(1, &tags)
+		note("lookup: name=%s tags=%d", name, tags)
+	}
+
+	counter, err := kv.TryIncrement("n", &gocb.IncrementOptions{Delta: 2, Initial: 5})
+	noteErr("increment", err)
+	if counter != nil {
+		note("increment: %d", counter.Content())
+	}
+
+	_, err = kv.TryUpsert("raw", []byte{0xff, 0x00}, &gocb.UpsertOptions{Transcoder: gocb.NewRawBinaryTranscoder()})
+	noteErr("upsert raw", err)
+
+	qres, err := q.TryQuery("SELECT name FROM users WHERE age > $age", &gocb.QueryOptions{
+		NamedParameters: map[string]interface{}{"age": 21},
+		Readonly:        true,
+	})
+	noteErr("query", err)
+	if qres != nil {
+		for qres.Next() {
+			var row map[string]string
+			_ = qres.Row(&row)
+			note("query row: %s", row["name"])
+		}
+		meta, _ := qres.MetaData()
+		note("query: request %s", meta.RequestID)
+	}
+
+	_, err = kv.TryRemove("u::1", nil)
+	noteErr("remove", err)
+	return out
+}
+
+func TestRecordReplayGolden(t *testing.T) {
+	golden := filepath.Join("testdata", goldenRecording)
+	rec := NewRecorder(
+		NewCollection(NewFakeClock(epoch)),
+		staticQuerier{rows: []json.RawMessage{json.RawMessage(`{"name":"ann"}`), json.RawMessage(`{"name":"bob"}`)}},
+	)
+	live := session(rec, rec)
+
+	var buf bytes.Buffer
+	if _, err := rec.WriteTo(&buf); err != nil {
+		t.Fatalf("error writing recording: %v", err)
+	}
+	if *update {
+		if err := os.WriteFile(golden, buf.Bytes(), 0o644); err != nil {
+			t.Fatalf("error updating golden file: %v", err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("error reading golden file, run with -update to create it: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("recording differs from %s, run with -update if the change is intended:\n%s", golden, buf.String())
+	}
+
+	loaded, err := LoadRecording(golden)
+	if err != nil {
+		t.Fatalf("error loading golden file: %v", err)
+	}
+	r := NewReplayer(t, loaded)
+	if replayed := session(r, r); !reflect.DeepEqual(replayed, live) {
+		t.Fatalf("replay differs from the live session:\nlive:     %q\nreplayed: %q", live, replayed)
+	}
+	r.Done()
+}
+
+// fakeTB captures replay failures instead of failing the test
+type fakeTB struct {
+	failures []string
+}
+
+func (tb *fakeTB) Helper() {}
+
+func (tb *fakeTB) Fatalf(format string, args ...interface{}) {
+	tb.failures = append(tb.failures, fmt.Sprintf(format, args...))
+}
+
+func TestReplayOptionsMismatch(t *testing.T) {
+	rec := NewRecorder(NewCollection(NewFakeClock(epoch)), nil)
+	if _, err := rec.TryUpsert("u::1", user{Name: "ann"}, &gocb.UpsertOptions{Expiry: time.Minute}); err != nil {
+		t.Fatalf("unexpected upsert error: %v", err)
+	}
+	if _, err := rec.TryIncrement("n", &gocb.IncrementOptions{Delta: 1, Initial: 3}); err != nil {
+		t.Fatalf("unexpected increment error: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := rec.WriteTo(&buf); err != nil {
+		t.Fatalf("error writing recording: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "recording.json")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("error writing recording: %v", err)
+	}
+	loaded, err := LoadRecording(path)
+	if err != nil {
+		t.Fatalf("error loading recording: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		call func(pail.KV)
+		want string
+	}{
+		{
+			name: "matching",
+			call: func(kv pail.KV) {
+				_, _ = kv.TryUpsert("u::1", user{Name: "ann"}, &gocb.UpsertOptions{Expiry: time.Minute})
+				_, _ = kv.TryIncrement("n", &gocb.IncrementOptions{Delta: 1, Initial: 3})
+			},
+		},
+		{
+			name: "expiry",
+			call: func(kv pail.KV) {
+				_, _ = kv.TryUpsert("u::1", user{Name: "ann"}, &gocb.UpsertOptions{Expiry: time.Hour})
+			},
+			want: "options differ",
+		},
+		{
+			name: "counter initial",
+			call: func(kv pail.KV) {
+				_, _ = kv.TryUpsert("u::1", user{Name: "ann"}, &gocb.UpsertOptions{Expiry: time.Minute})
+				_, _ = kv.TryIncrement("n", &gocb.IncrementOptions{Delta: 1, Initial: 4})
+			},
+			want: "options differ",
+		},
+		{
+			name: "payload",
+			call: func(kv pail.KV) {
+				_, _ = kv.TryUpsert("u::1", user{Name: "bob"}, &gocb.UpsertOptions{Expiry: time.Minute})
+			},
+			want: "payload differs",
+		},
+		{
+			name: "operation",
+			call: func(kv pail.KV) {
+				_, _ = kv.TryInsert("u::1", user{Name: "ann"}, &gocb.InsertOptions{Expiry: time.Minute})
+			},
+			want: "operation differs",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := new(fakeTB)
+			tt.call(NewReplayer(tb, loaded))
+			switch {
+			case tt.want == "" && len(tb.failures) > 0:
+				t.Fatalf("expected the calls to match, got %q", tb.failures)
+			case tt.want != "" && (len(tb.failures) == 0 || !strings.Contains(tb.failures[0], tt.want)):
+				t.Fatalf("expected a failure containing %q, got %q", tt.want, tb.failures)
+			}
+		})
+	}
+}
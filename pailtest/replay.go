@@ -0,0 +1,272 @@
+package pailtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/myENA/pail/v2"
+)
+
+// TB is the subset of testing.TB used to report replay failures.
+type TB interface {
+	Helper()
+	Fatalf(format string, args ...interface{})
+}
+
+// Replayer serves the interactions of a Recording in place of a live cluster.  Each call must match the next recorded
+// interaction by operation, key, options and payload; anything else, including calls made once the recording is
+// exhausted, fails the test immediately.  It is safe for concurrent use, though calls must still arrive in the
+// recorded order.
+type Replayer struct {
+	t   TB
+	mu  sync.Mutex
+	rec *Recording
+	pos int
+}
+
+var (
+	_ pail.KV      = (*Replayer)(nil)
+	_ pail.Querier = (*Replayer)(nil)
+)
+
+// NewReplayer returns a Replayer for rec reporting failures to t.  Should t be nil, failures panic.
+func NewReplayer(t TB, rec *Recording) *Replayer {
+	return &Replayer{t: t, rec: rec}
+}
+
+func (r *Replayer) fail(format string, args ...interface{}) {
+	if r.t == nil {
+		panic(fmt.Sprintf("pailtest: "+format, args...))
+	}
+	r.t.Helper()
+	r.t.Fatalf(format, args...)
+}
+
+// Remaining returns the number of recorded interactions not yet replayed.
+func (r *Replayer) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.rec.Interactions) - r.pos
+}
+
+// Done fails the test if any recorded interactions were not replayed.
+func (r *Replayer) Done() {
+	if n := r.Remaining(); n > 0 {
+		if r.t != nil {
+			r.t.Helper()
+		}
+		next := r.rec.Interactions[len(r.rec.Interactions)-n]
+		r.fail("%d recorded interactions not replayed, next is %s %q", n, next.Op, next.Key)
+	}
+}
+
+// next matches want against the next recorded interaction, returning it should they match.
+func (r *Replayer) next(want Interaction) *Interaction {
+	if r.t != nil {
+		r.t.Helper()
+	}
+	r.mu.Lock()
+	if r.pos >= len(r.rec.Interactions) {
+		r.mu.Unlock()
+		r.fail("unexpected %s %q, all %d recorded interactions have been replayed", want.Op, want.Key, len(r.rec.Interactions))
+		return nil
+	}
+	got := &r.rec.Interactions[r.pos]
+	idx := r.pos
+	r.pos++
+	r.mu.Unlock()
+
+	if mismatch := mismatch(got, &want); mismatch != "" {
+		r.fail("interaction %d: expected %s %q, got %s %q: %s", idx, got.Op, got.Key, want.Op, want.Key, mismatch)
+		return nil
+	}
+	return got
+}
+
+func mismatch(rec, call *Interaction) string {
+	switch {
+	case rec.Op != call.Op || rec.Key != call.Key:
+		return "operation differs"
+	case !reflect.DeepEqual(rec.Options, normalizeOptions(call.Options)):
+		return fmt.Sprintf("options differ, recorded %v, called with %v", rec.Options, normalizeOptions(call.Options))
+	case !rec.Value.equal(call.Value):
+		return fmt.Sprintf("payload differs, recorded %q, called with %q", rec.Value.Bytes(), call.Value.Bytes())
+	case !reflect.DeepEqual(rec.Specs, call.Specs):
+		return fmt.Sprintf("lookup specs differ, recorded %v, called with %v", rec.Specs, call.Specs)
+	}
+	return ""
+}
+
+func (r *Replayer) replayGet(in Interaction, transcoder gocb.Transcoder) (*gocb.GetResult, error) {
+	rec := r.next(in)
+	if rec == nil {
+		return nil, nil
+	}
+	if rec.Err != nil {
+		return nil, rec.Err.Err()
+	}
+	return newGetResult(gocb.Cas(rec.Cas), decoder(transcoder), rec.Content.Bytes(), rec.Flags, rec.Expiry), nil
+}
+
+func (r *Replayer) replayMutation(in Interaction) (*gocb.MutationResult, error) {
+	rec := r.next(in)
+	if rec == nil {
+		return nil, nil
+	}
+	if rec.Err != nil {
+		return nil, rec.Err.Err()
+	}
+	return newMutationResult(gocb.Cas(rec.Cas)), nil
+}
+
+func (r *Replayer) replayCounter(in Interaction) (*gocb.CounterResult, error) {
+	rec := r.next(in)
+	if rec == nil {
+		return nil, nil
+	}
+	if rec.Err != nil {
+		return nil, rec.Err.Err()
+	}
+	return newCounterResult(gocb.Cas(rec.Cas), rec.Counter), nil
+}
+
+func (r *Replayer) TryGet(id string, opts *gocb.GetOptions) (*gocb.GetResult, error) {
+	in := Interaction{Op: "get", Key: id}
+	if opts == nil {
+		opts = new(gocb.GetOptions)
+	} else {
+		in.Options = map[string]interface{}{"with_expiry": opts.WithExpiry, "project": opts.Project}
+	}
+	return r.replayGet(in, opts.Transcoder)
+}
+
+func (r *Replayer) TryGetContent(id string, ptr interface{}, opts *gocb.GetOptions) (*gocb.GetResult, error) {
+	res, err := r.TryGet(id, opts)
+	if err != nil || res == nil {
+		return nil, err
+	}
+	return res, res.Content(ptr)
+}
+
+func (r *Replayer) TryGetAndLock(id string, lockTime time.Duration, opts *gocb.GetAndLockOptions) (*gocb.GetResult, error) {
+	if opts == nil {
+		opts = new(gocb.GetAndLockOptions)
+	}
+	in := Interaction{Op: "get_and_lock", Key: id, Options: map[string]interface{}{"lock_time": lockTime.String()}}
+	return r.replayGet(in, opts.Transcoder)
+}
+
+func (r *Replayer) TryUnlock(id string, cas gocb.Cas, _ *gocb.UnlockOptions) error {
+	rec := r.next(Interaction{Op: "unlock", Key: id, Options: map[string]interface{}{"cas": uint64(cas)}})
+	if rec == nil {
+		return nil
+	}
+	return rec.Err.Err()
+}
+
+func (r *Replayer) TryLookupIn(id string, ops []gocb.LookupInSpec, _ *gocb.LookupInOptions) (*gocb.LookupInResult, error) {
+	rec := r.next(Interaction{Op: "lookup_in", Key: id, Specs: lookupSpecs(ops)})
+	if rec == nil {
+		return nil, nil
+	}
+	if rec.Err != nil {
+		return nil, rec.Err.Err()
+	}
+	partials := make([]lookupInPartial, len(rec.Lookups))
+	for i, l := range rec.Lookups {
+		partials[i] = lookupInPartial{data: l.Data, err: l.Err.Err()}
+	}
+	return newLookupInResult(gocb.Cas(rec.Cas), ops, partials), nil
+}
+
+func (r *Replayer) TryTouch(id string, expiry time.Duration, _ *gocb.TouchOptions) (*gocb.MutationResult, error) {
+	return r.replayMutation(Interaction{Op: "touch", Key: id, Options: map[string]interface{}{"expiry": expiry.String()}})
+}
+
+func (r *Replayer) TryUpsert(id string, value interface{}, opts *gocb.UpsertOptions) (*gocb.MutationResult, error) {
+	if opts == nil {
+		opts = new(gocb.UpsertOptions)
+	}
+	return r.replayMutation(Interaction{
+		Op:      "upsert",
+		Key:     id,
+		Value:   encodedValue(opts.Transcoder, value),
+		Options: map[string]interface{}{"expiry": opts.Expiry.String(), "preserve_expiry": opts.PreserveExpiry},
+	})
+}
+
+func (r *Replayer) TryInsert(id string, value interface{}, opts *gocb.InsertOptions) (*gocb.MutationResult, error) {
+	if opts == nil {
+		opts = new(gocb.InsertOptions)
+	}
+	return r.replayMutation(Interaction{
+		Op:      "insert",
+		Key:     id,
+		Value:   encodedValue(opts.Transcoder, value),
+		Options: map[string]interface{}{"expiry": opts.Expiry.String()},
+	})
+}
+
+func (r *Replayer) TryReplace(id string, value interface{}, opts *gocb.ReplaceOptions) (*gocb.MutationResult, error) {
+	if opts == nil {
+		opts = new(gocb.ReplaceOptions)
+	}
+	return r.replayMutation(Interaction{
+		Op:      "replace",
+		Key:     id,
+		Value:   encodedValue(opts.Transcoder, value),
+		Options: map[string]interface{}{"expiry": opts.Expiry.String(), "preserve_expiry": opts.PreserveExpiry, "cas": uint64(opts.Cas)},
+	})
+}
+
+func (r *Replayer) TryRemove(id string, opts *gocb.RemoveOptions) (*gocb.MutationResult, error) {
+	if opts == nil {
+		opts = new(gocb.RemoveOptions)
+	}
+	return r.replayMutation(Interaction{Op: "remove", Key: id, Options: map[string]interface{}{"cas": uint64(opts.Cas)}})
+}
+
+func (r *Replayer) TryIncrement(id string, opts *gocb.IncrementOptions) (*gocb.CounterResult, error) {
+	if opts == nil {
+		opts = new(gocb.IncrementOptions)
+	}
+	return r.replayCounter(counterInteraction("increment", id, opts.Delta, opts.Initial, nil))
+}
+
+func (r *Replayer) TryDecrement(id string, opts *gocb.DecrementOptions) (*gocb.CounterResult, error) {
+	if opts == nil {
+		opts = new(gocb.DecrementOptions)
+	}
+	return r.replayCounter(counterInteraction("decrement", id, opts.Delta, opts.Initial, nil))
+}
+
+func (r *Replayer) TryAppend(id string, value []byte, opts *gocb.AppendOptions) (*gocb.MutationResult, error) {
+	if opts == nil {
+		opts = new(gocb.AppendOptions)
+	}
+	return r.replayMutation(Interaction{Op: "append", Key: id, Value: newPayload(value), Options: map[string]interface{}{"cas": uint64(opts.Cas)}})
+}
+
+func (r *Replayer) TryPrepend(id string, value []byte, opts *gocb.PrependOptions) (*gocb.MutationResult, error) {
+	if opts == nil {
+		opts = new(gocb.PrependOptions)
+	}
+	return r.replayMutation(Interaction{Op: "prepend", Key: id, Value: newPayload(value), Options: map[string]interface{}{"cas": uint64(opts.Cas)}})
+}
+
+func (r *Replayer) TryQuery(statement string, opts *gocb.QueryOptions) (*gocb.QueryResult, error) {
+	rec := r.next(Interaction{Op: "query", Key: statement, Options: queryOptions(opts)})
+	if rec == nil {
+		return nil, nil
+	}
+	if rec.Err != nil {
+		return nil, rec.Err.Err()
+	}
+	rows := make([]json.RawMessage, len(rec.Rows))
+	copy(rows, rec.Rows)
+	return newQueryResult(rows, rec.MetaData), nil
+}
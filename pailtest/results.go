@@ -0,0 +1,96 @@
+package pailtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+	"unsafe"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// gocb offers no way of constructing its result types outside of the SDK, so they are populated via reflection.  This
+// ties pailtest to the layout of the gocb version pail depends upon; should it change, these helpers panic rather
+// than silently returning empty results.
+
+// field returns the named, possibly unexported, field of the addressable struct v in a form that may be read and set.
+func field(v reflect.Value, name string) reflect.Value {
+	f := v.FieldByName(name)
+	if !f.IsValid() {
+		panic(fmt.Sprintf("pailtest: %s has no field %q, gocb's result layout has changed", v.Type(), name))
+	}
+	return reflect.NewAt(f.Type(), unsafe.Pointer(f.UnsafeAddr())).Elem()
+}
+
+func setField(v reflect.Value, name string, value interface{}) {
+	if value == nil {
+		return
+	}
+	field(v, name).Set(reflect.ValueOf(value))
+}
+
+func newGetResult(cas gocb.Cas, transcoder gocb.Transcoder, contents []byte, flags uint32, expiry *time.Time) *gocb.GetResult {
+	res := new(gocb.GetResult)
+	v := reflect.ValueOf(res).Elem()
+	setField(v, "cas", cas)
+	setField(v, "transcoder", transcoder)
+	setField(v, "contents", contents)
+	setField(v, "flags", flags)
+	if expiry != nil {
+		setField(v, "expiryTime", expiry)
+	}
+	return res
+}
+
+func newMutationResult(cas gocb.Cas) *gocb.MutationResult {
+	res := new(gocb.MutationResult)
+	setField(reflect.ValueOf(res).Elem(), "cas", cas)
+	return res
+}
+
+func newCounterResult(cas gocb.Cas, content uint64) *gocb.CounterResult {
+	res := new(gocb.CounterResult)
+	v := reflect.ValueOf(res).Elem()
+	setField(v, "cas", cas)
+	setField(v, "content", content)
+	return res
+}
+
+type lookupInPartial struct {
+	data json.RawMessage
+	err  error
+}
+
+func newLookupInResult(cas gocb.Cas, specs []gocb.LookupInSpec, partials []lookupInPartial) *gocb.LookupInResult {
+	res := new(gocb.LookupInResult)
+	v := reflect.ValueOf(res).Elem()
+	setField(v, "cas", cas)
+	contents := field(v, "contents")
+	contents.Set(reflect.MakeSlice(contents.Type(), len(partials), len(partials)))
+	for i, p := range partials {
+		elem := contents.Index(i)
+		setField(elem, "data", p.data)
+		setField(elem, "err", p.err)
+		spec := specs[i]
+		field(elem, "op").Set(field(reflect.ValueOf(&spec).Elem(), "op"))
+	}
+	return res
+}
+
+type lookupOp uint64
+
+var (
+	lookupGet    = specOp(gocb.GetSpec("", nil))
+	lookupExists = specOp(gocb.ExistsSpec("", nil))
+	lookupCount  = specOp(gocb.CountSpec("", nil))
+)
+
+func specOp(spec gocb.LookupInSpec) lookupOp {
+	return lookupOp(field(reflect.ValueOf(&spec).Elem(), "op").Uint())
+}
+
+func specPath(spec gocb.LookupInSpec) (string, bool) {
+	v := reflect.ValueOf(&spec).Elem()
+	return field(v, "path").String(), field(v, "isXattr").Bool()
+}
@@ -0,0 +1,125 @@
+package pailtest
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// pathPart is either an object key or, if isIndex, an array index.  Negative indexes count from the end of the array.
+type pathPart struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// parsePath splits a sub-document path such as "a.b[0].c" into its parts.  Keys may be quoted with backticks to
+// include dots or brackets.
+func parsePath(path string) ([]pathPart, error) {
+	var (
+		parts []pathPart
+		key   strings.Builder
+		ended bool
+	)
+	flush := func() {
+		if key.Len() > 0 || ended {
+			parts = append(parts, pathPart{key: key.String()})
+		}
+		key.Reset()
+		ended = false
+	}
+	for i := 0; i < len(path); i++ {
+		switch ch := path[i]; ch {
+		case '`':
+			end := strings.IndexByte(path[i+1:], '`')
+			if end < 0 {
+				return nil, gocb.ErrPathInvalid
+			}
+			key.WriteString(path[i+1 : i+1+end])
+			ended = true
+			i += end + 1
+		case '.':
+			if key.Len() == 0 && !ended && (len(parts) == 0 || !parts[len(parts)-1].isIndex) {
+				return nil, gocb.ErrPathInvalid
+			}
+			flush()
+		case '[':
+			flush()
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, gocb.ErrPathInvalid
+			}
+			idx, err := strconv.Atoi(path[i+1 : i+end])
+			if err != nil {
+				return nil, gocb.ErrPathInvalid
+			}
+			parts = append(parts, pathPart{index: idx, isIndex: true})
+			i += end
+		default:
+			key.WriteByte(ch)
+		}
+	}
+	flush()
+	return parts, nil
+}
+
+// lookupPath walks doc, as decoded by encoding/json, along path.
+func lookupPath(doc interface{}, path string) (interface{}, error) {
+	parts, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	cur := doc
+	for _, p := range parts {
+		if p.isIndex {
+			arr, ok := cur.([]interface{})
+			if !ok {
+				return nil, gocb.ErrPathMismatch
+			}
+			idx := p.index
+			if idx < 0 {
+				idx += len(arr)
+			}
+			if idx < 0 || idx >= len(arr) {
+				return nil, gocb.ErrPathNotFound
+			}
+			cur = arr[idx]
+			continue
+		}
+		obj, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, gocb.ErrPathMismatch
+		}
+		if cur, ok = obj[p.key]; !ok {
+			return nil, gocb.ErrPathNotFound
+		}
+	}
+	return cur, nil
+}
+
+// setPath sets value within the object doc along path, creating intermediate objects as necessary.  Paths including
+// array indexes are not supported.
+func setPath(doc map[string]interface{}, path string, value interface{}) error {
+	parts, err := parsePath(path)
+	if err != nil {
+		return err
+	}
+	cur := doc
+	for i, p := range parts {
+		if p.isIndex {
+			return gocb.ErrPathInvalid
+		}
+		if i == len(parts)-1 {
+			cur[p.key] = value
+			break
+		}
+		next, ok := cur[p.key].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			cur[p.key] = next
+		}
+		cur = next
+	}
+	return nil
+}
@@ -0,0 +1,336 @@
+package pail
+
+import (
+	"errors"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// Backoff returns how long to wait before the given retry, where the first retry is 1.
+type Backoff func(retry uint32) time.Duration
+
+// ConstantBackoff waits the same delay before every retry.
+func ConstantBackoff(delay time.Duration) Backoff {
+	return func(uint32) time.Duration { return delay }
+}
+
+// ExponentialBackoff waits base before the first retry, multiplying the wait by multiplier for each subsequent retry
+// up to max.  A max of zero leaves the wait unbounded.
+func ExponentialBackoff(base, max time.Duration, multiplier float64) Backoff {
+	return func(retry uint32) time.Duration {
+		if retry == 0 {
+			retry = 1
+		}
+		d := float64(base) * math.Pow(multiplier, float64(retry-1))
+		if max > 0 && d > float64(max) {
+			return max
+		}
+		return time.Duration(d)
+	}
+}
+
+// ErrorClassifier returns true if err is worth retrying.
+type ErrorClassifier func(err error) bool
+
+// DefaultErrorClassifier retries errors deemed to probably be related to a connection issue.
+func DefaultErrorClassifier(err error) bool {
+	return isConnectErr(err)
+}
+
+// withoutTimeoutRetries returns a copy of the policy which never retries timeouts, for operations which wait up to a
+// timeout of their own and so would otherwise wait that long again on every retry
+func (p RetryPolicy) withoutTimeoutRetries() RetryPolicy {
+	classifier := p.Classifier
+	if classifier == nil {
+		classifier = DefaultErrorClassifier
+	}
+	p.Classifier = func(err error) bool {
+		return !errors.Is(err, gocb.ErrTimeout) && classifier(err)
+	}
+	return p
+}
+
+// RetryHooks are called as an operation is retried.  Any may be nil.
+type RetryHooks struct {
+	// OnRetry is called before waiting to retry after err.
+	OnRetry func(retry uint32, wait time.Duration, err error)
+	// OnGiveUp is called once the retry limit has been breached.
+	OnGiveUp func(err error)
+	// OnDone is called once the Try loop returns, successfully or otherwise, with the attempts made.
+	OnDone func(stats RetryStats)
+	// OnSDKRetry is called whenever gocb asks to retry a request, with the wait before the retry or zero if it was
+	// refused.  It may be called concurrently from gocb's goroutines.
+	OnSDKRetry func(reason gocb.RetryReason, wait time.Duration)
+}
+
+// RetryPolicy describes how an operation is retried.  A nil Backoff waits Delay between every attempt, and a nil
+// Classifier uses DefaultErrorClassifier.
+//
+// Retries is a single budget shared by pail's outer Try loop and gocb, which retries through the retry context's
+// RetryAfter, so that a call is attempted at most Retries+1 times in total.  The exception is retries for reasons gocb
+// always retries, such as a request reaching a node no longer hosting its vbucket, which do not draw on the budget.
+// MaxElapsed, if set, bounds every retry however it comes about: no retry is made whose wait would end more than
+// MaxElapsed after the first attempt began.  A negative Retries is treated as zero.
+type RetryPolicy struct {
+	Retries    int
+	Delay      time.Duration
+	Backoff    Backoff
+	Classifier ErrorClassifier
+	Hooks      RetryHooks
+	MaxElapsed time.Duration
+
+	clock   Clock
+	reasons ReasonPolicies
+}
+
+func (c *commonRetryable) policy() RetryPolicy {
+	return RetryPolicy{
+		Retries:    int(c.retries),
+		Delay:      c.delay,
+		Backoff:    c.backoff,
+		Classifier: c.classifier,
+		Hooks:      c.hooks,
+		MaxElapsed: c.maxElapsed,
+	}
+}
+
+func (c *commonRetryable) setPolicy(p RetryPolicy) {
+	c.retries = retryLimit(p.Retries)
+	c.delay = p.Delay
+	c.backoff = p.Backoff
+	c.classifier = p.Classifier
+	c.hooks = p.Hooks
+	c.maxElapsed = p.MaxElapsed
+}
+
+// OperationKind identifies a class of operation for the purpose of selecting a retry policy.
+type OperationKind string
+
+const (
+	OperationGet                OperationKind = "get"
+	OperationLookupIn           OperationKind = "lookup-in"
+	OperationTouch              OperationKind = "touch"
+	OperationLock               OperationKind = "lock"
+	OperationUpsert             OperationKind = "upsert"
+	OperationInsert             OperationKind = "insert"
+	OperationReplace            OperationKind = "replace"
+	OperationRemove             OperationKind = "remove"
+	OperationCounter            OperationKind = "counter"
+	OperationAppendPrepend      OperationKind = "append-prepend"
+	OperationBulk               OperationKind = "bulk"
+	OperationQuery              OperationKind = "query"
+	OperationSearch             OperationKind = "search"
+	OperationIndexManagement    OperationKind = "index-mgmt"
+	OperationUserManagement     OperationKind = "user-mgmt"
+	OperationEventingManagement OperationKind = "eventing-mgmt"
+	OperationDiagnostics        OperationKind = "diagnostics"
+	OperationTransaction        OperationKind = "transaction"
+)
+
+// RetryAfter allows a RetryPolicy to be set as the RetryStrategy of any options struct handed to a pail options
+// builder or Try method, overriding the retry policy for that call alone.  Should the options instead reach gocb
+// directly, the policy will not permit gocb to retry the request.
+func (p RetryPolicy) RetryAfter(req gocb.RetryRequest, reason gocb.RetryReason) gocb.RetryAction {
+	if reason.AlwaysRetry() {
+		return ConnectionErrorRetryAction(p.Delay)
+	}
+	return ConnectionErrorRetryAction(0)
+}
+
+// SetOperationPolicy overrides the retry policy used for operations of the given kind.  Wrappers derived afterwards,
+// e.g. a Pail from a Cluster or a Collection from a Scope, inherit the override.  It may be called concurrently with
+// operations on the same wrapper, and with other calls to SetOperationPolicy, but not with the derivation of wrappers
+// from it.
+func (c *commonRetryable) SetOperationPolicy(kind OperationKind, p RetryPolicy) {
+	for {
+		cur := c.policies.Load()
+		old, _ := cur.(*map[OperationKind]RetryPolicy)
+		policies := make(map[OperationKind]RetryPolicy, 1)
+		if old != nil {
+			for k, v := range *old {
+				policies[k] = v
+			}
+		}
+		policies[kind] = p
+		if c.policies.CompareAndSwap(cur, &policies) {
+			return
+		}
+	}
+}
+
+// operationPolicies returns the per-operation overrides, which must not be modified
+func (c *commonRetryable) operationPolicies() map[OperationKind]RetryPolicy {
+	if policies, ok := c.policies.Load().(*map[OperationKind]RetryPolicy); ok {
+		return *policies
+	}
+	return nil
+}
+
+// OperationPolicy returns the retry policy used for operations of the given kind.  Handed to Do, it waits between
+// attempts using the wrapper's clock.
+func (c *commonRetryable) OperationPolicy(kind OperationKind) RetryPolicy {
+	p, ok := c.operationPolicies()[kind]
+	if !ok {
+		p = c.policy()
+	}
+	p.clock = c.clock
+	p.reasons = c.reasons
+	return p
+}
+
+// resolvePolicy returns the policy for a call of the given kind, along with the base strategy to hand to gocb.  A
+// RetryPolicy provided as the call's strategy takes precedence and is not itself passed on.
+func (c *commonRetryable) resolvePolicy(kind OperationKind, strategy gocb.RetryStrategy) (RetryPolicy, gocb.RetryStrategy) {
+	p, ok := strategy.(RetryPolicy)
+	if ok {
+		strategy = nil
+	} else {
+		p = c.OperationPolicy(kind)
+	}
+	p.clock = c.clock
+	p.reasons = c.reasons
+	return p, strategy
+}
+
+// retryLimit converts a retry count to the budget a retry context enforces, a negative count meaning no retries
+func retryLimit(n int) uint32 {
+	if n < 0 {
+		return 0
+	}
+	return uint32(n)
+}
+
+func (c commonRetryable) withRetries(n int) commonRetryable {
+	c.retries = retryLimit(n)
+	return c
+}
+
+func (c commonRetryable) withDelay(d time.Duration) commonRetryable {
+	c.delay = d
+	return c
+}
+
+// withPolicy replaces the default policy and discards any per-operation overrides
+func (c commonRetryable) withPolicy(p RetryPolicy) commonRetryable {
+	c.setPolicy(p)
+	c.policies = atomic.Value{}
+	return c
+}
+
+// WithRetries returns a copy of the cluster whose default policy retries up to n times.  A negative n is treated as
+// zero.  Per-operation overrides are retained and, having their own retries, take precedence over it.
+func (c *Cluster) WithRetries(n int) *Cluster {
+	out := *c
+	out.commonRetryable = c.withRetries(n)
+	return &out
+}
+
+// WithDelay returns a copy of the cluster whose default policy waits d between attempts.  Per-operation overrides are
+// retained and, having their own delay, take precedence over it.
+func (c *Cluster) WithDelay(d time.Duration) *Cluster {
+	out := *c
+	out.commonRetryable = c.withDelay(d)
+	return &out
+}
+
+// WithPolicy returns a copy of the cluster which uses policy for every operation, discarding any per-operation overrides.
+func (c *Cluster) WithPolicy(policy RetryPolicy) *Cluster {
+	out := *c
+	out.commonRetryable = c.withPolicy(policy)
+	return &out
+}
+
+// WithRetries returns a copy of the pail whose default policy retries up to n times.  A negative n is treated as
+// zero.  Per-operation overrides are retained and, having their own retries, take precedence over it.
+func (p *Pail) WithRetries(n int) *Pail {
+	out := *p
+	out.commonRetryable = p.withRetries(n)
+	return &out
+}
+
+// WithDelay returns a copy of the pail whose default policy waits d between attempts.  Per-operation overrides are
+// retained and, having their own delay, take precedence over it.
+func (p *Pail) WithDelay(d time.Duration) *Pail {
+	out := *p
+	out.commonRetryable = p.withDelay(d)
+	return &out
+}
+
+// WithPolicy returns a copy of the pail which uses policy for every operation, discarding any per-operation overrides.
+func (p *Pail) WithPolicy(policy RetryPolicy) *Pail {
+	out := *p
+	out.commonRetryable = p.withPolicy(policy)
+	return &out
+}
+
+// WithRetries returns a copy of the scope whose default policy retries up to n times.  A negative n is treated as
+// zero.  Per-operation overrides are retained and, having their own retries, take precedence over it.
+func (s *Scope) WithRetries(n int) *Scope {
+	out := *s
+	out.commonRetryable = s.withRetries(n)
+	return &out
+}
+
+// WithDelay returns a copy of the scope whose default policy waits d between attempts.  Per-operation overrides are
+// retained and, having their own delay, take precedence over it.
+func (s *Scope) WithDelay(d time.Duration) *Scope {
+	out := *s
+	out.commonRetryable = s.withDelay(d)
+	return &out
+}
+
+// WithPolicy returns a copy of the scope which uses policy for every operation, discarding any per-operation overrides.
+func (s *Scope) WithPolicy(policy RetryPolicy) *Scope {
+	out := *s
+	out.commonRetryable = s.withPolicy(policy)
+	return &out
+}
+
+// WithRetries returns a copy of the collection whose default policy retries up to n times.  A negative n is treated
+// as zero.  Per-operation overrides are retained and, having their own retries, take precedence over it.
+func (c *Collection) WithRetries(n int) *Collection {
+	out := *c
+	out.commonRetryable = c.withRetries(n)
+	return &out
+}
+
+// WithDelay returns a copy of the collection whose default policy waits d between attempts.  Per-operation overrides are
+// retained and, having their own delay, take precedence over it.
+func (c *Collection) WithDelay(d time.Duration) *Collection {
+	out := *c
+	out.commonRetryable = c.withDelay(d)
+	return &out
+}
+
+// WithPolicy returns a copy of the collection which uses policy for every operation, discarding any per-operation overrides.
+func (c *Collection) WithPolicy(policy RetryPolicy) *Collection {
+	out := *c
+	out.commonRetryable = c.withPolicy(policy)
+	return &out
+}
+
+// WithRetries returns a copy of the query index manager whose default policy retries up to n times.  A negative n is
+// treated as zero.  Per-operation overrides are retained and, having their own retries, take precedence over it.
+func (qm *QueryIndexManager) WithRetries(n int) *QueryIndexManager {
+	out := *qm
+	out.commonRetryable = qm.withRetries(n)
+	return &out
+}
+
+// WithDelay returns a copy of the query index manager whose default policy waits d between attempts.  Per-operation
+// overrides are retained and, having their own delay, take precedence over it.
+func (qm *QueryIndexManager) WithDelay(d time.Duration) *QueryIndexManager {
+	out := *qm
+	out.commonRetryable = qm.withDelay(d)
+	return &out
+}
+
+// WithPolicy returns a copy of the query index manager which uses policy for every operation, discarding any per-operation overrides.
+func (qm *QueryIndexManager) WithPolicy(policy RetryPolicy) *QueryIndexManager {
+	out := *qm
+	out.commonRetryable = qm.withPolicy(policy)
+	return &out
+}
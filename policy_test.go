@@ -0,0 +1,159 @@
+package pail
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+func TestResolvePolicy(t *testing.T) {
+	var (
+		clock   = systemClock{}
+		reasons = ReasonPolicies{gocb.KVTemporaryFailureRetryReason: {GiveUp: true}}
+		base    = gocb.NewBestEffortRetryStrategy(nil)
+	)
+	c := NewCluster(nil, 2, 10*time.Millisecond).WithClock(clock).WithReasonPolicies(reasons)
+	c.SetOperationPolicy(OperationUpsert, RetryPolicy{Retries: 5, Delay: time.Second})
+
+	tests := []struct {
+		name     string
+		kind     OperationKind
+		strategy gocb.RetryStrategy
+		retries  int
+		delay    time.Duration
+		base     gocb.RetryStrategy
+	}{
+		{name: "default", kind: OperationGet, retries: 2, delay: 10 * time.Millisecond},
+		{name: "operation override", kind: OperationUpsert, retries: 5, delay: time.Second},
+		{
+			name:     "call policy over default",
+			kind:     OperationGet,
+			strategy: RetryPolicy{Retries: 1, Delay: time.Millisecond},
+			retries:  1,
+			delay:    time.Millisecond,
+		},
+		{
+			name:     "call policy over operation override",
+			kind:     OperationUpsert,
+			strategy: RetryPolicy{Retries: 0, Delay: time.Minute},
+			delay:    time.Minute,
+		},
+		{
+			name:     "other strategy passed through",
+			kind:     OperationUpsert,
+			strategy: base,
+			retries:  5,
+			delay:    time.Second,
+			base:     base,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, strategy := c.resolvePolicy(tt.kind, tt.strategy)
+			if p.Retries != tt.retries || p.Delay != tt.delay {
+				t.Fatalf("expected %d retries %s apart, got %d retries %s apart", tt.retries, tt.delay, p.Retries, p.Delay)
+			}
+			if strategy != tt.base {
+				t.Fatalf("expected base strategy %v, got %v", tt.base, strategy)
+			}
+			// the wrapper's clock and reason policies apply whichever policy is chosen
+			if p.clock != clock || len(p.reasons) != 1 || !p.reasons[gocb.KVTemporaryFailureRetryReason].GiveUp {
+				t.Fatalf("expected the wrapper's clock and reasons, got %v and %v", p.clock, p.reasons)
+			}
+		})
+	}
+}
+
+func TestSetOperationPolicy(t *testing.T) {
+	c := NewCluster(nil, 2, 0)
+	c.SetOperationPolicy(OperationGet, RetryPolicy{Retries: 4})
+	derived := c.WithRetries(3)
+	c.SetOperationPolicy(OperationGet, RetryPolicy{Retries: 6})
+	c.SetOperationPolicy(OperationQuery, RetryPolicy{Retries: 7})
+
+	if got := derived.OperationPolicy(OperationGet).Retries; got != 4 {
+		t.Fatalf("expected a derived wrapper to keep the override it was derived with, got %d retries", got)
+	}
+	if got := derived.OperationPolicy(OperationQuery).Retries; got != 3 {
+		t.Fatalf("expected a derived wrapper not to see later overrides, got %d retries", got)
+	}
+	if got := c.OperationPolicy(OperationGet).Retries; got != 6 {
+		t.Fatalf("expected the later override to replace the earlier, got %d retries", got)
+	}
+}
+
+func TestSetOperationPolicyConcurrent(t *testing.T) {
+	c := NewCluster(nil, 1, 0)
+	const n = 20
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			c.SetOperationPolicy(OperationKind(fmt.Sprint(i)), RetryPolicy{Retries: i})
+		}(i)
+		go func() {
+			defer wg.Done()
+			_, _ = c.resolvePolicy(OperationGet, nil)
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < n; i++ {
+		if got := c.OperationPolicy(OperationKind(fmt.Sprint(i))).Retries; got != i {
+			t.Fatalf("expected override %d to survive concurrent updates, got %d retries", i, got)
+		}
+	}
+}
+
+// policyWrapper is implemented by every wrapper offering the With* policy helpers
+type policyWrapper[T any] interface {
+	WithRetries(n int) T
+	WithDelay(d time.Duration) T
+	WithPolicy(policy RetryPolicy) T
+	OperationPolicy(kind OperationKind) RetryPolicy
+	SetOperationPolicy(kind OperationKind, p RetryPolicy)
+}
+
+// expectPolicy fails the test unless p retries retries times, delay apart
+func expectPolicy(t *testing.T, what string, p RetryPolicy, retries int, delay time.Duration) {
+	t.Helper()
+	if p.Retries != retries || p.Delay != delay {
+		t.Fatalf("%s: expected %d retries %s apart, got %d retries %s apart", what, retries, delay, p.Retries, p.Delay)
+	}
+}
+
+func testPolicyCopies[T policyWrapper[T]](t *testing.T, w T) {
+	w.SetOperationPolicy(OperationQuery, RetryPolicy{Retries: 9, Delay: 9 * time.Second})
+
+	r := w.WithRetries(5)
+	expectPolicy(t, "WithRetries default", r.OperationPolicy(OperationGet), 5, 10*time.Millisecond)
+	expectPolicy(t, "WithRetries override", r.OperationPolicy(OperationQuery), 9, 9*time.Second)
+	expectPolicy(t, "WithRetries negative", w.WithRetries(-1).OperationPolicy(OperationGet), 0, 10*time.Millisecond)
+
+	d := w.WithDelay(time.Second)
+	expectPolicy(t, "WithDelay default", d.OperationPolicy(OperationGet), 2, time.Second)
+	expectPolicy(t, "WithDelay override", d.OperationPolicy(OperationQuery), 9, 9*time.Second)
+
+	p := w.WithPolicy(RetryPolicy{Retries: 1, Delay: time.Millisecond})
+	expectPolicy(t, "WithPolicy default", p.OperationPolicy(OperationGet), 1, time.Millisecond)
+	expectPolicy(t, "WithPolicy discards overrides", p.OperationPolicy(OperationQuery), 1, time.Millisecond)
+
+	r.SetOperationPolicy(OperationGet, RetryPolicy{Retries: 7})
+	expectPolicy(t, "copy override", r.OperationPolicy(OperationGet), 7, 0)
+	expectPolicy(t, "original default", w.OperationPolicy(OperationGet), 2, 10*time.Millisecond)
+	expectPolicy(t, "original override", w.OperationPolicy(OperationQuery), 9, 9*time.Second)
+}
+
+func TestPolicyCopies(t *testing.T) {
+	common := func() commonRetryable { return NewCluster(nil, 2, 10*time.Millisecond).commonRetryable }
+	t.Run("cluster", func(t *testing.T) { testPolicyCopies(t, NewCluster(nil, 2, 10*time.Millisecond)) })
+	t.Run("pail", func(t *testing.T) { testPolicyCopies(t, NewPail(nil, 2, 10*time.Millisecond)) })
+	t.Run("scope", func(t *testing.T) { testPolicyCopies(t, &Scope{commonRetryable: common()}) })
+	t.Run("collection", func(t *testing.T) { testPolicyCopies(t, &Collection{commonRetryable: common()}) })
+	t.Run("query index manager", func(t *testing.T) {
+		testPolicyCopies(t, NewQueryIndexManager(nil, 2, 10*time.Millisecond))
+	})
+}
@@ -0,0 +1,220 @@
+package pail
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimit configures a RateLimiter.  A zero rate leaves the corresponding dimension unlimited, and a zero burst
+// permits a single second's worth of tokens.  A nil Clock uses the system clock.
+type RateLimit struct {
+	OpsPerSecond   float64
+	OpsBurst       int
+	BytesPerSecond float64
+	BytesBurst     int
+	Clock          Clock
+}
+
+// RateLimiterStats describes the time callers have spent waiting on a RateLimiter.
+type RateLimiterStats struct {
+	// Throttled is the number of attempts that had to wait before being admitted.
+	Throttled uint64
+	// ThrottledTime is the total time admitted attempts spent waiting, as measured by the limiter's clock, which may
+	// exceed the delay reserved for them should the clock oversleep.
+	ThrottledTime time.Duration
+	// Cancelled is the number of attempts whose context ended while waiting.
+	Cancelled uint64
+}
+
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	b := &tokenBucket{rate: rate, burst: float64(burst)}
+	if b.burst <= 0 {
+		b.burst = rate
+	}
+	b.tokens = b.burst
+	return b
+}
+
+// reserve takes n tokens, returning how long the caller must wait before they are considered available.  The
+// balance may go negative, in which case subsequent callers queue behind this one.
+func (b *tokenBucket) reserve(now time.Time, n float64) time.Duration {
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// refund returns n reserved tokens which went unused, never taking the balance beyond the burst
+func (b *tokenBucket) refund(n float64) {
+	b.tokens += n
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// RateLimiter is a token bucket limiter on operations and bytes per second.  It may be shared between any number of
+// wrappers, e.g. a Cluster and the collections derived from it, in which case they share its limits.
+type RateLimiter struct {
+	mu    sync.Mutex
+	ops   *tokenBucket
+	bytes *tokenBucket
+	clock Clock
+
+	throttled     uint64
+	throttledTime int64
+	cancelled     uint64
+}
+
+func NewRateLimiter(cfg RateLimit) *RateLimiter {
+	return &RateLimiter{
+		ops:   newTokenBucket(cfg.OpsPerSecond, cfg.OpsBurst),
+		bytes: newTokenBucket(cfg.BytesPerSecond, cfg.BytesBurst),
+		clock: clockOrSystem(cfg.Clock),
+	}
+}
+
+// Wait blocks until a single operation is admitted, or ctx ends.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	return l.WaitN(ctx, 1, 0)
+}
+
+// WaitN blocks until ops operations totalling bytes bytes are admitted, or ctx ends.  Should ctx end first, the
+// reserved tokens are returned, the balance never exceeding the burst, and ctx's error is returned.
+func (l *RateLimiter) WaitN(ctx context.Context, ops, bytes int) error {
+	l.mu.Lock()
+	now := l.clock.Now()
+	var d time.Duration
+	if l.ops != nil && ops > 0 {
+		d = l.ops.reserve(now, float64(ops))
+	}
+	if l.bytes != nil && bytes > 0 {
+		if bd := l.bytes.reserve(now, float64(bytes)); bd > d {
+			d = bd
+		}
+	}
+	l.mu.Unlock()
+	if d <= 0 {
+		return nil
+	}
+
+	start := l.clock.Now()
+	if err := l.clock.Sleep(ctx, d); err != nil {
+		l.mu.Lock()
+		if l.ops != nil && ops > 0 {
+			l.ops.refund(float64(ops))
+		}
+		if l.bytes != nil && bytes > 0 {
+			l.bytes.refund(float64(bytes))
+		}
+		l.mu.Unlock()
+		atomic.AddUint64(&l.cancelled, 1)
+		return err
+	}
+	atomic.AddUint64(&l.throttled, 1)
+	atomic.AddInt64(&l.throttledTime, int64(l.clock.Now().Sub(start)))
+	return nil
+}
+
+// LimitsBytes returns true if the limiter has a bytes per second limit.
+func (l *RateLimiter) LimitsBytes() bool {
+	return l.bytes != nil
+}
+
+func (l *RateLimiter) Stats() RateLimiterStats {
+	return RateLimiterStats{
+		Throttled:     atomic.LoadUint64(&l.throttled),
+		ThrottledTime: time.Duration(atomic.LoadInt64(&l.throttledTime)),
+		Cancelled:     atomic.LoadUint64(&l.cancelled),
+	}
+}
+
+// throttle wraps fn such that each attempt first waits to be admitted by l.  fn is returned unchanged if l is nil.
+func throttle[T any](l *RateLimiter, ctx context.Context, fn func(T) error) func(T) error {
+	if l == nil {
+		return fn
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return func(target T) error {
+		if err := l.Wait(ctx); err != nil {
+			return err
+		}
+		return fn(target)
+	}
+}
+
+// payloadSize returns the size of value once encoded.  Values other than raw bytes and strings are assumed to be
+// encoded as JSON, as they would be by gocb's default transcoder.
+func payloadSize(value interface{}) int {
+	switch v := value.(type) {
+	case []byte:
+		return len(v)
+	case json.RawMessage:
+		return len(v)
+	case string:
+		return len(v)
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return 0
+	}
+	return len(b)
+}
+
+// payloadGate returns a func which waits for the limiter to admit the bytes of value, if the limiter limits bytes.
+func (c *commonRetryable) payloadGate(value interface{}) func(context.Context) error {
+	l := c.limiter
+	if l == nil || !l.LimitsBytes() {
+		return func(context.Context) error { return nil }
+	}
+	size := payloadSize(value)
+	return func(ctx context.Context) error {
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		return l.WaitN(ctx, 0, size)
+	}
+}
+
+// RateLimiter returns the limiter applied to operations, if any.
+func (c *commonRetryable) RateLimiter() *RateLimiter {
+	return c.limiter
+}
+
+// WithRateLimiter returns a copy of the cluster whose operations, and those of everything subsequently derived from
+// it, wait on l before each attempt.  A nil l removes any limiter.
+func (c *Cluster) WithRateLimiter(l *RateLimiter) *Cluster {
+	out := *c
+	out.limiter = l
+	return &out
+}
+
+// WithRateLimiter returns a copy of the collection whose operations wait on l before each attempt.  Writes
+// additionally wait for their payload to be admitted should l limit bytes.  A nil l removes any limiter.
+func (c *Collection) WithRateLimiter(l *RateLimiter) *Collection {
+	out := *c
+	out.limiter = l
+	return &out
+}
@@ -0,0 +1,100 @@
+package pail_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/myENA/pail/v2"
+	"github.com/myENA/pail/v2/pailtest"
+)
+
+func TestRateLimiterRefill(t *testing.T) {
+	clock := pailtest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	clock.SetAutoAdvance(true)
+	l := pail.NewRateLimiter(pail.RateLimit{OpsPerSecond: 10, OpsBurst: 2, Clock: clock})
+
+	waits := func(n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			if err := l.Wait(context.Background()); err != nil {
+				t.Fatalf("unexpected wait error: %v", err)
+			}
+		}
+	}
+	waits(3)
+	// a second of idleness refills no more than the burst
+	clock.Advance(time.Second)
+	waits(3)
+	if want := []time.Duration{100 * time.Millisecond, 100 * time.Millisecond}; !reflect.DeepEqual(clock.Sleeps(), want) {
+		t.Fatalf("expected only the attempt beyond each burst to wait, got waits of %v", clock.Sleeps())
+	}
+	if stats := l.Stats(); stats.Throttled != 2 || stats.ThrottledTime != 200*time.Millisecond {
+		t.Fatalf("expected 2 attempts throttled for 200ms, got %+v", stats)
+	}
+}
+
+func TestRateLimiterBytes(t *testing.T) {
+	clock := pailtest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	clock.SetAutoAdvance(true)
+	l := pail.NewRateLimiter(pail.RateLimit{OpsPerSecond: 100, BytesPerSecond: 100, Clock: clock})
+	if !l.LimitsBytes() {
+		t.Fatal("expected the limiter to limit bytes")
+	}
+	if err := l.WaitN(context.Background(), 1, 150); err != nil {
+		t.Fatalf("unexpected wait error: %v", err)
+	}
+	// the longer of the two waits applies
+	if want := []time.Duration{500 * time.Millisecond}; !reflect.DeepEqual(clock.Sleeps(), want) {
+		t.Fatalf("expected the bytes beyond the burst to wait %v, got %v", want, clock.Sleeps())
+	}
+}
+
+func TestRateLimiterCancel(t *testing.T) {
+	clock := pailtest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	l := pail.NewRateLimiter(pail.RateLimit{OpsPerSecond: 10, OpsBurst: 1, Clock: clock})
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatalf("unexpected wait error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- l.Wait(ctx) }()
+	clock.BlockUntil(1)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the wait to end with its context, got %v", err)
+	}
+
+	// the cancelled wait's token was refunded, so the next waits as long as it would have rather than behind it
+	clock.SetAutoAdvance(true)
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatalf("unexpected wait error: %v", err)
+	}
+	if want := []time.Duration{100 * time.Millisecond, 100 * time.Millisecond}; !reflect.DeepEqual(clock.Sleeps(), want) {
+		t.Fatalf("expected the wait after cancellation to be unaffected by it, got waits of %v", clock.Sleeps())
+	}
+	if stats := l.Stats(); stats.Cancelled != 1 || stats.Throttled != 1 {
+		t.Fatalf("expected 1 cancelled and 1 throttled attempt, got %+v", stats)
+	}
+}
+
+func TestRateLimiterThrottledTime(t *testing.T) {
+	clock := pailtest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	l := pail.NewRateLimiter(pail.RateLimit{OpsPerSecond: 10, OpsBurst: 1, Clock: clock})
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatalf("unexpected wait error: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- l.Wait(context.Background()) }()
+	clock.BlockUntil(1)
+	clock.Advance(150 * time.Millisecond)
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected wait error: %v", err)
+	}
+	if stats := l.Stats(); stats.ThrottledTime != 150*time.Millisecond {
+		t.Fatalf("expected the time actually waited to be recorded, got %+v", stats)
+	}
+}
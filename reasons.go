@@ -0,0 +1,115 @@
+package pail
+
+import (
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// immediateRetryDelay is the wait given to gocb for an immediate retry, gocb treating a wait of zero as not retrying
+const immediateRetryDelay = time.Microsecond
+
+// ReasonPolicy describes how a retry gocb asks for, for a particular gocb.RetryReason, is handled.  Retries for reasons
+// without a policy are handled according to the operation's RetryPolicy.
+type ReasonPolicy struct {
+	// GiveUp refuses the retry, failing the request with the error which prompted it.
+	GiveUp bool
+	// Immediate retries without waiting.
+	Immediate bool
+	// Backoff, if set, replaces the operation's backoff for retries for this reason.
+	Backoff Backoff
+}
+
+// ReasonPolicies maps reasons gocb may retry a request for, such as gocb.KVTemporaryFailureRetryReason, to how such
+// retries are handled, e.g.
+//
+//	pail.ReasonPolicies{
+//		gocb.KVTemporaryFailureRetryReason: {Backoff: pail.ExponentialBackoff(100*time.Millisecond, 2*time.Second, 2)},
+//		gocb.CircuitBreakerOpenRetryReason: {GiveUp: true},
+//	}
+//
+// Reasons gocb always retries, such as gocb.KVNotMyVBucketRetryReason, are for the most part retried by gocb without
+// consulting the retry strategy, in which case their policies are not applied.
+type ReasonPolicies map[gocb.RetryReason]ReasonPolicy
+
+// lookup returns the policy for reason, if there is one
+func (rp ReasonPolicies) lookup(reason gocb.RetryReason) (ReasonPolicy, bool) {
+	if len(rp) == 0 || reason == nil {
+		return ReasonPolicy{}, false
+	}
+	p, ok := rp[reason]
+	return p, ok
+}
+
+// action returns the action p takes for the given retry, falling back to fallback if p does not determine the wait
+func (p ReasonPolicy) action(retry uint32, fallback func() gocb.RetryAction) gocb.RetryAction {
+	switch {
+	case p.GiveUp:
+		return ConnectionErrorRetryAction(0)
+	case p.Immediate:
+		return ConnectionErrorRetryAction(immediateRetryDelay)
+	case p.Backoff != nil:
+		d := p.Backoff(retry)
+		if d <= 0 {
+			d = immediateRetryDelay
+		}
+		return ConnectionErrorRetryAction(d)
+	}
+	return fallback()
+}
+
+// ReasonPolicy returns the policy applied to retries gocb asks for for reason, if there is one.
+func (c *commonRetryable) ReasonPolicy(reason gocb.RetryReason) (ReasonPolicy, bool) {
+	return c.reasons.lookup(reason)
+}
+
+// WithReasonPolicies returns a copy of the cluster which, along with everything subsequently derived from it, handles
+// retries gocb asks for according to policies.  They replace any policies previously set.
+func (c *Cluster) WithReasonPolicies(policies ReasonPolicies) *Cluster {
+	out := *c
+	out.reasons = copyReasonPolicies(policies)
+	return &out
+}
+
+// WithReasonPolicies returns a copy of the pail which, along with everything subsequently derived from it, handles
+// retries gocb asks for according to policies.  They replace any policies previously set.
+func (p *Pail) WithReasonPolicies(policies ReasonPolicies) *Pail {
+	out := *p
+	out.reasons = copyReasonPolicies(policies)
+	return &out
+}
+
+// WithReasonPolicies returns a copy of the scope which, along with everything subsequently derived from it, handles
+// retries gocb asks for according to policies.  They replace any policies previously set.
+func (s *Scope) WithReasonPolicies(policies ReasonPolicies) *Scope {
+	out := *s
+	out.reasons = copyReasonPolicies(policies)
+	return &out
+}
+
+// WithReasonPolicies returns a copy of the collection which handles retries gocb asks for according to policies.  They
+// replace any policies previously set.
+func (c *Collection) WithReasonPolicies(policies ReasonPolicies) *Collection {
+	out := *c
+	out.reasons = copyReasonPolicies(policies)
+	return &out
+}
+
+// WithReasonPolicies returns a copy of the query index manager which handles retries gocb asks for according to
+// policies.  They replace any policies previously set.
+func (qm *QueryIndexManager) WithReasonPolicies(policies ReasonPolicies) *QueryIndexManager {
+	out := *qm
+	out.reasons = copyReasonPolicies(policies)
+	return &out
+}
+
+func copyReasonPolicies(policies ReasonPolicies) ReasonPolicies {
+	if len(policies) == 0 {
+		return nil
+	}
+	out := make(ReasonPolicies, len(policies))
+	for reason, p := range policies {
+		out[reason] = p
+	}
+	return out
+}
@@ -0,0 +1,186 @@
+package pail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+type TransactionAttemptFunc func(*TransactionAttemptContext) error
+
+var (
+	// ErrTransactionFailed is matched by a TransactionError whose transaction was rolled back.
+	ErrTransactionFailed = errors.New("transaction failed")
+	// ErrTransactionExpired is matched by a TransactionError whose transaction ran out of time before committing.
+	ErrTransactionExpired = errors.New("transaction expired")
+	// ErrTransactionCommitAmbiguous is matched by a TransactionError whose commit may or may not have been applied.
+	ErrTransactionCommitAmbiguous = errors.New("transaction commit ambiguous")
+)
+
+// these causes leave nothing committed and stand a reasonable chance of succeeding on a fresh transaction.
+var transactionRetryableCauses = []error{
+	gocb.ErrTransient,
+	gocb.ErrWriteWriteConflict,
+	gocb.ErrDocAlreadyInTransaction,
+}
+
+// TransactionError is returned by TryTransaction when a transaction does not succeed.  Use errors.Is against
+// ErrTransactionFailed, ErrTransactionExpired or ErrTransactionCommitAmbiguous to determine what happened.
+type TransactionError struct {
+	// Kind is one of ErrTransactionFailed, ErrTransactionExpired or ErrTransactionCommitAmbiguous
+	Kind error
+	// Retryable is true if the transaction is known to have not committed and a fresh attempt may succeed.
+	Retryable bool
+	// Attempts is the number of times the whole transaction was run.
+	Attempts int
+	// Err is the error returned by gocb.
+	Err error
+}
+
+func (e *TransactionError) Error() string {
+	return fmt.Sprintf("%v after %d attempt(s): %v", e.Kind, e.Attempts, e.Err)
+}
+
+func (e *TransactionError) Unwrap() error {
+	return e.Err
+}
+
+func (e *TransactionError) Is(target error) bool {
+	return target == e.Kind
+}
+
+// classifyTransactionError converts a gocb transaction error into a *TransactionError, returning any other error
+// unchanged.
+func classifyTransactionError(err error, attempts int) error {
+	var (
+		failedErr    *gocb.TransactionFailedError
+		expiredErr   *gocb.TransactionExpiredError
+		ambiguousErr *gocb.TransactionCommitAmbiguousError
+	)
+	switch {
+	case errors.As(err, &ambiguousErr):
+		return &TransactionError{Kind: ErrTransactionCommitAmbiguous, Attempts: attempts, Err: err}
+	case errors.As(err, &expiredErr):
+		// nothing was committed, but gocb has already spent the whole transaction timeout re-running it, so running
+		// it afresh would most likely just double the time taken to fail.
+		return &TransactionError{Kind: ErrTransactionExpired, Attempts: attempts, Err: err}
+	case errors.As(err, &failedErr):
+		te := &TransactionError{Kind: ErrTransactionFailed, Attempts: attempts, Err: err}
+		if isConnectErr(err) {
+			te.Retryable = true
+		}
+		for _, cause := range transactionRetryableCauses {
+			if errors.Is(err, cause) {
+				te.Retryable = true
+				break
+			}
+		}
+		return te
+	}
+	return err
+}
+
+// TransactionAttemptContext wraps gocb's attempt context so that documents may be addressed using pail types.
+type TransactionAttemptContext struct {
+	*gocb.TransactionAttemptContext
+}
+
+// TransactionGetResult is a document read or written within a transaction, along with the Collection it belongs to.
+type TransactionGetResult struct {
+	*gocb.TransactionGetResult
+	Collection *Collection
+	ID         string
+}
+
+func (ac *TransactionAttemptContext) Get(c *Collection, id string) (*TransactionGetResult, error) {
+	return ac.GetWithOptions(c, id, nil)
+}
+
+func (ac *TransactionAttemptContext) GetWithOptions(c *Collection, id string, opts *gocb.TransactionGetOptions) (*TransactionGetResult, error) {
+	res, err := ac.TransactionAttemptContext.GetWithOptions(c.Collection, id, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &TransactionGetResult{TransactionGetResult: res, Collection: c, ID: id}, nil
+}
+
+func (ac *TransactionAttemptContext) Insert(c *Collection, id string, value interface{}) (*TransactionGetResult, error) {
+	return ac.InsertWithOptions(c, id, value, nil)
+}
+
+func (ac *TransactionAttemptContext) InsertWithOptions(c *Collection, id string, value interface{}, opts *gocb.TransactionInsertOptions) (*TransactionGetResult, error) {
+	res, err := ac.TransactionAttemptContext.InsertWithOptions(c.Collection, id, value, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &TransactionGetResult{TransactionGetResult: res, Collection: c, ID: id}, nil
+}
+
+func (ac *TransactionAttemptContext) Replace(doc *TransactionGetResult, value interface{}) (*TransactionGetResult, error) {
+	return ac.ReplaceWithOptions(doc, value, nil)
+}
+
+func (ac *TransactionAttemptContext) ReplaceWithOptions(doc *TransactionGetResult, value interface{}, opts *gocb.TransactionReplaceOptions) (*TransactionGetResult, error) {
+	res, err := ac.TransactionAttemptContext.ReplaceWithOptions(doc.TransactionGetResult, value, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &TransactionGetResult{TransactionGetResult: res, Collection: doc.Collection, ID: doc.ID}, nil
+}
+
+func (ac *TransactionAttemptContext) Remove(doc *TransactionGetResult) error {
+	return ac.TransactionAttemptContext.Remove(doc.TransactionGetResult)
+}
+
+// Query executes statement within the transaction.  If scope is non-nil, the statement is executed against it.
+func (ac *TransactionAttemptContext) Query(scope *Scope, statement string, opts *gocb.TransactionQueryOptions) (*gocb.TransactionQueryResult, error) {
+	out := new(gocb.TransactionQueryOptions)
+	if opts != nil {
+		*out = *opts
+	}
+	if scope != nil {
+		out.Scope = scope.Scope
+	}
+	return ac.TransactionAttemptContext.Query(statement, out)
+}
+
+// TryTransaction runs fn within a gocb transaction.  gocb will itself re-run fn for as long as the transaction
+// timeout allows; should the transaction as a whole still fail in a manner that is safe to retry, it will be run
+// afresh according to the OperationTransaction policy, its hooks, MaxElapsed and classifier included, though only
+// errors whose TransactionError is Retryable are ever retried.  Any transaction failure may be matched by errors.As
+// against a *TransactionError.
+func (c *Cluster) TryTransaction(fn TransactionAttemptFunc, opts *gocb.TransactionOptions) (*gocb.TransactionResult, error) {
+	return c.TryTransactionContext(context.Background(), fn, opts)
+}
+
+// TryTransactionContext is TryTransaction, with ctx bounding the waits between transactions, on the cluster's rate
+// and concurrency limiters as well as on the policy's delay.
+func (c *Cluster) TryTransactionContext(ctx context.Context, fn TransactionAttemptFunc, opts *gocb.TransactionOptions) (*gocb.TransactionResult, error) {
+	var res *gocb.TransactionResult
+	policy, _ := c.resolvePolicy(OperationTransaction, nil)
+	classifier := policy.Classifier
+	policy.Classifier = func(err error) bool {
+		var te *TransactionError
+		return errors.As(err, &te) && te.Retryable && (classifier == nil || classifier(err))
+	}
+	rc := &baseRetryContext{limit: retryLimit(policy.Retries), action: ConnectionErrorRetryAction(policy.Delay)}
+	rc.configure(policy, ctx)
+	attemptFn := func(ac *gocb.TransactionAttemptContext) error {
+		return fn(&TransactionAttemptContext{TransactionAttemptContext: ac})
+	}
+	transact := func(c *gocb.Cluster) error {
+		var err error
+		if res, err = c.Transactions().Run(attemptFn, opts); err != nil {
+			return classifyTransactionError(err, int(rc.Attempts()))
+		}
+		return nil
+	}
+	transact = limitConcurrency(c.concurrency, ctx, transact)
+	transact = throttle(c.limiter, ctx, transact)
+	if err := rc.run(func() error { return transact(c.Cluster) }); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,35 @@
+package pail
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+func TestClassifyTransactionError(t *testing.T) {
+	other := errors.New("other")
+	tests := []struct {
+		name      string
+		err       error
+		kind      error
+		retryable bool
+	}{
+		{name: "expired", err: &gocb.TransactionExpiredError{}, kind: ErrTransactionExpired},
+		{name: "ambiguous", err: &gocb.TransactionCommitAmbiguousError{}, kind: ErrTransactionCommitAmbiguous},
+		{name: "failed", err: &gocb.TransactionFailedError{}, kind: ErrTransactionFailed},
+		{name: "other", err: other, kind: other},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classifyTransactionError(tt.err, 2)
+			if !errors.Is(err, tt.kind) {
+				t.Fatalf("expected %v, got %v", tt.kind, err)
+			}
+			var te *TransactionError
+			if errors.As(err, &te) && (te.Retryable != tt.retryable || te.Attempts != 2) {
+				t.Fatalf("expected retryable=%t after 2 attempts, got %+v", tt.retryable, te)
+			}
+		})
+	}
+}
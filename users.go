@@ -0,0 +1,329 @@
+package pail
+
+import (
+	"errors"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+type UserManager struct {
+	*gocb.UserManager
+	commonRetryable
+}
+
+func NewUserManager(userManager *gocb.UserManager, retries int, delay time.Duration) *UserManager {
+	um := new(UserManager)
+	um.UserManager = userManager
+	um.retries = retryLimit(retries)
+	um.delay = delay
+	return um
+}
+
+func (um *UserManager) Try(ctx UserManagerRetryContext) error {
+	return ctx.Try(um.UserManager)
+}
+
+func (um *UserManager) GetAllUsersOptions(in *gocb.GetAllUsersOptions, fn UserManagerRetryFunc) (UserManagerRetryContext, *gocb.GetAllUsersOptions) {
+	out := new(gocb.GetAllUsersOptions)
+	if in != nil {
+		*out = *in
+	}
+	policy, base := um.resolvePolicy(OperationUserManagement, out.RetryStrategy)
+	fn = limitConcurrency(um.concurrency, out.Context, fn)
+	fn = throttle(um.limiter, out.Context, fn)
+	ctx := NewSimpleUserManagerRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	out.RetryStrategy = ctx
+	return ctx, out
+}
+
+func (um *UserManager) GetUserOptions(in *gocb.GetUserOptions, fn UserManagerRetryFunc) (UserManagerRetryContext, *gocb.GetUserOptions) {
+	out := new(gocb.GetUserOptions)
+	if in != nil {
+		*out = *in
+	}
+	policy, base := um.resolvePolicy(OperationUserManagement, out.RetryStrategy)
+	fn = limitConcurrency(um.concurrency, out.Context, fn)
+	fn = throttle(um.limiter, out.Context, fn)
+	ctx := NewSimpleUserManagerRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	out.RetryStrategy = ctx
+	return ctx, out
+}
+
+func (um *UserManager) UpsertUserOptions(in *gocb.UpsertUserOptions, fn UserManagerRetryFunc) (UserManagerRetryContext, *gocb.UpsertUserOptions) {
+	out := new(gocb.UpsertUserOptions)
+	if in != nil {
+		*out = *in
+	}
+	policy, base := um.resolvePolicy(OperationUserManagement, out.RetryStrategy)
+	fn = limitConcurrency(um.concurrency, out.Context, fn)
+	fn = throttle(um.limiter, out.Context, fn)
+	ctx := NewSimpleUserManagerRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	out.RetryStrategy = ctx
+	return ctx, out
+}
+
+func (um *UserManager) DropUserOptions(in *gocb.DropUserOptions, fn UserManagerRetryFunc) (UserManagerRetryContext, *gocb.DropUserOptions) {
+	out := new(gocb.DropUserOptions)
+	if in != nil {
+		*out = *in
+	}
+	policy, base := um.resolvePolicy(OperationUserManagement, out.RetryStrategy)
+	fn = limitConcurrency(um.concurrency, out.Context, fn)
+	fn = throttle(um.limiter, out.Context, fn)
+	ctx := NewSimpleUserManagerRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	out.RetryStrategy = ctx
+	return ctx, out
+}
+
+func (um *UserManager) GetRolesOptions(in *gocb.GetRolesOptions, fn UserManagerRetryFunc) (UserManagerRetryContext, *gocb.GetRolesOptions) {
+	out := new(gocb.GetRolesOptions)
+	if in != nil {
+		*out = *in
+	}
+	policy, base := um.resolvePolicy(OperationUserManagement, out.RetryStrategy)
+	fn = limitConcurrency(um.concurrency, out.Context, fn)
+	fn = throttle(um.limiter, out.Context, fn)
+	ctx := NewSimpleUserManagerRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	out.RetryStrategy = ctx
+	return ctx, out
+}
+
+func (um *UserManager) GetGroupOptions(in *gocb.GetGroupOptions, fn UserManagerRetryFunc) (UserManagerRetryContext, *gocb.GetGroupOptions) {
+	out := new(gocb.GetGroupOptions)
+	if in != nil {
+		*out = *in
+	}
+	policy, base := um.resolvePolicy(OperationUserManagement, out.RetryStrategy)
+	fn = limitConcurrency(um.concurrency, out.Context, fn)
+	fn = throttle(um.limiter, out.Context, fn)
+	ctx := NewSimpleUserManagerRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	out.RetryStrategy = ctx
+	return ctx, out
+}
+
+func (um *UserManager) GetAllGroupsOptions(in *gocb.GetAllGroupsOptions, fn UserManagerRetryFunc) (UserManagerRetryContext, *gocb.GetAllGroupsOptions) {
+	out := new(gocb.GetAllGroupsOptions)
+	if in != nil {
+		*out = *in
+	}
+	policy, base := um.resolvePolicy(OperationUserManagement, out.RetryStrategy)
+	fn = limitConcurrency(um.concurrency, out.Context, fn)
+	fn = throttle(um.limiter, out.Context, fn)
+	ctx := NewSimpleUserManagerRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	out.RetryStrategy = ctx
+	return ctx, out
+}
+
+func (um *UserManager) UpsertGroupOptions(in *gocb.UpsertGroupOptions, fn UserManagerRetryFunc) (UserManagerRetryContext, *gocb.UpsertGroupOptions) {
+	out := new(gocb.UpsertGroupOptions)
+	if in != nil {
+		*out = *in
+	}
+	policy, base := um.resolvePolicy(OperationUserManagement, out.RetryStrategy)
+	fn = limitConcurrency(um.concurrency, out.Context, fn)
+	fn = throttle(um.limiter, out.Context, fn)
+	ctx := NewSimpleUserManagerRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	out.RetryStrategy = ctx
+	return ctx, out
+}
+
+func (um *UserManager) DropGroupOptions(in *gocb.DropGroupOptions, fn UserManagerRetryFunc) (UserManagerRetryContext, *gocb.DropGroupOptions) {
+	out := new(gocb.DropGroupOptions)
+	if in != nil {
+		*out = *in
+	}
+	policy, base := um.resolvePolicy(OperationUserManagement, out.RetryStrategy)
+	fn = limitConcurrency(um.concurrency, out.Context, fn)
+	fn = throttle(um.limiter, out.Context, fn)
+	ctx := NewSimpleUserManagerRetryContext(retryLimit(policy.Retries), policy.Delay, base, fn)
+	ctx.configure(policy, out.Context)
+	out.RetryStrategy = ctx
+	return ctx, out
+}
+
+func (um *UserManager) TryGetAllUsers(opts *gocb.GetAllUsersOptions) ([]gocb.UserAndMetadata, error) {
+	var (
+		res []gocb.UserAndMetadata
+		ctx UserManagerRetryContext
+		err error
+	)
+	ctx, opts = um.GetAllUsersOptions(opts, func(um *gocb.UserManager) error { res, err = um.GetAllUsers(opts); return err })
+	if tryErr := um.Try(ctx); tryErr != nil {
+		return nil, tryErr
+	}
+	return res, err
+}
+
+func (um *UserManager) TryGetUser(name string, opts *gocb.GetUserOptions) (*gocb.UserAndMetadata, error) {
+	var (
+		res *gocb.UserAndMetadata
+		ctx UserManagerRetryContext
+		err error
+	)
+	ctx, opts = um.GetUserOptions(opts, func(um *gocb.UserManager) error { res, err = um.GetUser(name, opts); return err })
+	if tryErr := um.Try(ctx); tryErr != nil {
+		return nil, tryErr
+	}
+	return res, err
+}
+
+func (um *UserManager) TryUpsertUser(user gocb.User, opts *gocb.UpsertUserOptions) error {
+	var ctx UserManagerRetryContext
+	ctx, opts = um.UpsertUserOptions(opts, func(um *gocb.UserManager) error { return um.UpsertUser(user, opts) })
+	return um.Try(ctx)
+}
+
+func (um *UserManager) TryDropUser(name string, opts *gocb.DropUserOptions) error {
+	var ctx UserManagerRetryContext
+	ctx, opts = um.DropUserOptions(opts, func(um *gocb.UserManager) error { return um.DropUser(name, opts) })
+	return um.Try(ctx)
+}
+
+func (um *UserManager) TryGetRoles(opts *gocb.GetRolesOptions) ([]gocb.RoleAndDescription, error) {
+	var (
+		res []gocb.RoleAndDescription
+		ctx UserManagerRetryContext
+		err error
+	)
+	ctx, opts = um.GetRolesOptions(opts, func(um *gocb.UserManager) error { res, err = um.GetRoles(opts); return err })
+	if tryErr := um.Try(ctx); tryErr != nil {
+		return nil, tryErr
+	}
+	return res, err
+}
+
+func (um *UserManager) TryGetGroup(groupName string, opts *gocb.GetGroupOptions) (*gocb.Group, error) {
+	var (
+		res *gocb.Group
+		ctx UserManagerRetryContext
+		err error
+	)
+	ctx, opts = um.GetGroupOptions(opts, func(um *gocb.UserManager) error { res, err = um.GetGroup(groupName, opts); return err })
+	if tryErr := um.Try(ctx); tryErr != nil {
+		return nil, tryErr
+	}
+	return res, err
+}
+
+func (um *UserManager) TryGetAllGroups(opts *gocb.GetAllGroupsOptions) ([]gocb.Group, error) {
+	var (
+		res []gocb.Group
+		ctx UserManagerRetryContext
+		err error
+	)
+	ctx, opts = um.GetAllGroupsOptions(opts, func(um *gocb.UserManager) error { res, err = um.GetAllGroups(opts); return err })
+	if tryErr := um.Try(ctx); tryErr != nil {
+		return nil, tryErr
+	}
+	return res, err
+}
+
+func (um *UserManager) TryUpsertGroup(group gocb.Group, opts *gocb.UpsertGroupOptions) error {
+	var ctx UserManagerRetryContext
+	ctx, opts = um.UpsertGroupOptions(opts, func(um *gocb.UserManager) error { return um.UpsertGroup(group, opts) })
+	return um.Try(ctx)
+}
+
+func (um *UserManager) TryDropGroup(groupName string, opts *gocb.DropGroupOptions) error {
+	var ctx UserManagerRetryContext
+	ctx, opts = um.DropGroupOptions(opts, func(um *gocb.UserManager) error { return um.DropGroup(groupName, opts) })
+	return um.Try(ctx)
+}
+
+// UserDiff describes the changes EnsureUser made to bring a user in line with the desired definition.
+type UserDiff struct {
+	Created       bool
+	AddedRoles    []gocb.Role
+	RemovedRoles  []gocb.Role
+	GroupsChanged bool
+	NameChanged   bool
+}
+
+// Changed returns true if EnsureUser had to create or update the user.
+func (d *UserDiff) Changed() bool {
+	return d.Created || len(d.AddedRoles) > 0 || len(d.RemovedRoles) > 0 || d.GroupsChanged || d.NameChanged
+}
+
+// EnsureUser creates the user if they do not exist, otherwise diffs their directly assigned roles, groups and display
+// name against user and upserts them only if something differs.  As passwords cannot be read back, an existing
+// user's password is only updated when some other part of the definition has also changed.
+func (um *UserManager) EnsureUser(user gocb.User, opts *gocb.UpsertUserOptions) (*UserDiff, error) {
+	return ensureUser(um, user, opts)
+}
+
+// userStore is the part of UserManager EnsureUser needs, allowing it to be driven without a cluster
+type userStore interface {
+	TryGetUser(name string, opts *gocb.GetUserOptions) (*gocb.UserAndMetadata, error)
+	TryUpsertUser(user gocb.User, opts *gocb.UpsertUserOptions) error
+}
+
+func ensureUser(um userStore, user gocb.User, opts *gocb.UpsertUserOptions) (*UserDiff, error) {
+	getOpts := new(gocb.GetUserOptions)
+	if opts != nil {
+		getOpts.DomainName = opts.DomainName
+		getOpts.Timeout = opts.Timeout
+		getOpts.ParentSpan = opts.ParentSpan
+		getOpts.Context = opts.Context
+	}
+
+	diff := new(UserDiff)
+	current, err := um.TryGetUser(user.Username, getOpts)
+	if errors.Is(err, gocb.ErrUserNotFound) {
+		diff.Created = true
+		diff.AddedRoles = user.Roles
+		return diff, um.TryUpsertUser(user, opts)
+	} else if err != nil {
+		return nil, err
+	}
+
+	diff.AddedRoles = subtractRoles(user.Roles, current.Roles)
+	diff.RemovedRoles = subtractRoles(current.Roles, user.Roles)
+	diff.GroupsChanged = !sameStringSet(user.Groups, current.Groups)
+	diff.NameChanged = user.DisplayName != current.DisplayName
+	if !diff.Changed() {
+		return diff, nil
+	}
+	return diff, um.TryUpsertUser(user, opts)
+}
+
+// subtractRoles returns the roles in a that are not present in b
+func subtractRoles(a, b []gocb.Role) []gocb.Role {
+	var out []gocb.Role
+	for _, ra := range a {
+		found := false
+		for _, rb := range b {
+			if ra == rb {
+				found = true
+				break
+			}
+		}
+		if !found {
+			out = append(out, ra)
+		}
+	}
+	return out
+}
+
+func sameStringSet(a, b []string) bool {
+	setA := make(map[string]struct{}, len(a))
+	for _, s := range a {
+		setA[s] = struct{}{}
+	}
+	setB := make(map[string]struct{}, len(b))
+	for _, s := range b {
+		if _, ok := setA[s]; !ok {
+			return false
+		}
+		setB[s] = struct{}{}
+	}
+	return len(setA) == len(setB)
+}
@@ -0,0 +1,135 @@
+package pail
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// fakeUserStore holds a single user in memory, counting the upserts made to it
+type fakeUserStore struct {
+	user    *gocb.UserAndMetadata
+	getErr  error
+	upserts []gocb.User
+}
+
+func (s *fakeUserStore) TryGetUser(name string, _ *gocb.GetUserOptions) (*gocb.UserAndMetadata, error) {
+	if s.getErr != nil {
+		return nil, s.getErr
+	}
+	if s.user == nil || s.user.Username != name {
+		return nil, gocb.ErrUserNotFound
+	}
+	return s.user, nil
+}
+
+func (s *fakeUserStore) TryUpsertUser(user gocb.User, _ *gocb.UpsertUserOptions) error {
+	s.upserts = append(s.upserts, user)
+	s.user = &gocb.UserAndMetadata{User: user}
+	return nil
+}
+
+func TestEnsureUser(t *testing.T) {
+	var (
+		admin  = gocb.Role{Name: "bucket_admin", Bucket: "app"}
+		reader = gocb.Role{Name: "data_reader", Bucket: "app"}
+		writer = gocb.Role{Name: "data_writer", Bucket: "app"}
+	)
+	existing := gocb.User{Username: "svc", DisplayName: "Service", Roles: []gocb.Role{reader, writer}, Groups: []string{"a", "b"}}
+
+	tests := []struct {
+		name    string
+		current *gocb.User
+		user    gocb.User
+		want    UserDiff
+	}{
+		{
+			name: "user not existing",
+			user: existing,
+			want: UserDiff{Created: true, AddedRoles: []gocb.Role{reader, writer}},
+		},
+		{
+			name:    "unchanged",
+			current: &existing,
+			user:    gocb.User{Username: "svc", DisplayName: "Service", Roles: []gocb.Role{writer, reader}, Groups: []string{"b", "a"}},
+		},
+		{
+			name:    "add roles",
+			current: &existing,
+			user:    gocb.User{Username: "svc", DisplayName: "Service", Roles: []gocb.Role{reader, writer, admin}, Groups: []string{"a", "b"}},
+			want:    UserDiff{AddedRoles: []gocb.Role{admin}},
+		},
+		{
+			name:    "remove roles",
+			current: &existing,
+			user:    gocb.User{Username: "svc", DisplayName: "Service", Roles: []gocb.Role{writer}, Groups: []string{"a", "b"}},
+			want:    UserDiff{RemovedRoles: []gocb.Role{reader}},
+		},
+		{
+			name:    "groups and name",
+			current: &existing,
+			user:    gocb.User{Username: "svc", DisplayName: "Svc", Roles: []gocb.Role{reader, writer}, Groups: []string{"a"}},
+			want:    UserDiff{GroupsChanged: true, NameChanged: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := new(fakeUserStore)
+			if tt.current != nil {
+				store.user = &gocb.UserAndMetadata{User: *tt.current}
+			}
+			diff, err := ensureUser(store, tt.user, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(*diff, tt.want) {
+				t.Fatalf("expected diff %+v, got %+v", tt.want, *diff)
+			}
+			switch {
+			case diff.Changed() && (len(store.upserts) != 1 || !reflect.DeepEqual(store.upserts[0], tt.user)):
+				t.Fatalf("expected the user to be upserted once, got %+v", store.upserts)
+			case !diff.Changed() && len(store.upserts) != 0:
+				t.Fatalf("expected an unchanged user not to be upserted, got %+v", store.upserts)
+			}
+		})
+	}
+}
+
+func TestEnsureUserGetError(t *testing.T) {
+	boom := errors.New("boom")
+	store := &fakeUserStore{getErr: boom}
+	if _, err := ensureUser(store, gocb.User{Username: "svc"}, nil); !errors.Is(err, boom) {
+		t.Fatalf("expected the lookup error, got %v", err)
+	}
+	if len(store.upserts) != 0 {
+		t.Fatalf("expected no upsert after a failed lookup, got %+v", store.upserts)
+	}
+}
+
+func TestSubtractRoles(t *testing.T) {
+	var (
+		a = gocb.Role{Name: "data_reader", Bucket: "app"}
+		b = gocb.Role{Name: "data_reader", Bucket: "app", Scope: "tenant"}
+		c = gocb.Role{Name: "data_writer", Bucket: "app"}
+	)
+	tests := []struct {
+		name string
+		a, b []gocb.Role
+		want []gocb.Role
+	}{
+		{name: "empty", a: nil, b: []gocb.Role{a}},
+		{name: "disjoint", a: []gocb.Role{a, c}, b: nil, want: []gocb.Role{a, c}},
+		{name: "subset", a: []gocb.Role{a, c}, b: []gocb.Role{c}, want: []gocb.Role{a}},
+		{name: "scoped roles differ", a: []gocb.Role{a, b}, b: []gocb.Role{a}, want: []gocb.Role{b}},
+		{name: "equal", a: []gocb.Role{a, b, c}, b: []gocb.Role{c, b, a}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := subtractRoles(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}